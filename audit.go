@@ -0,0 +1,461 @@
+package loginjector
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// An audited message is written with the chain value after it, and every file the chain
+// enters opens with a header naming the value it continues from:
+//
+//	#audit v1 sha256 prev=<64 hex digits>
+//	<message> #<64 hex digits>
+//
+// The chain value of a message is SHA-256 (or HMAC-SHA256 under WithAuditKey) over the
+// previous value's 32 bytes followed by the message. The first header of a new chain
+// continues from 32 zero bytes.
+//
+// The lines of a message are escaped so that none reads as a header or as the end of a
+// message: a line starting with '#' or '\' gains a leading '\', and a line other than the
+// message's last that would pass for one ending in a chain value gains a trailing '\'.
+const (
+	auditHeaderPrefix = "#audit v1 "
+	auditChainLen     = sha256.Size * 2
+	auditSuffixLen    = 1 + auditChainLen // '#' and the hex digits.
+)
+
+// AuditOption configures AuditHandler and Verify.
+type AuditOption func(*auditConfig)
+
+type auditConfig struct {
+	key   []byte               // WithAuditKey: HMAC key; nil chains with plain SHA-256.
+	files []RotatingFileOption // WithAuditFiles: how Verify finds and reads the set.
+}
+
+// WithAuditKey keys the chain with HMAC-SHA256, so only a holder of key can extend it:
+// without a key anyone able to edit the files can recompute a plain SHA-256 chain after
+// an edit. Verify must be given the same key.
+func WithAuditKey(key []byte) AuditOption {
+	k := append([]byte(nil), key...)
+	return func(c *auditConfig) { c.key = k }
+}
+
+// WithAuditFiles gives Verify the RotatingFileHandler options it needs to read the
+// set: WithBackupNaming when TimestampBackups adds WithHostname or WithPID, and
+// WithEncryption for an encrypted set. Other options are ignored, as is WithAuditFiles by
+// AuditHandler, which learns both from the handler it wraps.
+func WithAuditFiles(opts ...RotatingFileOption) AuditOption {
+	return func(c *auditConfig) { c.files = append(c.files, opts...) }
+}
+
+// algorithm is the name the headers of c's chain carry.
+func (c auditConfig) algorithm() string {
+	if c.key != nil {
+		return "hmac-sha256"
+	}
+	return "sha256"
+}
+
+// next returns the chain value that follows prev for msg.
+func (c auditConfig) next(prev, msg []byte) []byte {
+	var h hash.Hash
+	if c.key != nil {
+		h = hmac.New(sha256.New, c.key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(prev)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// header returns the header line continuing from prev.
+func (c auditConfig) header(prev []byte) []byte {
+	return []byte(auditHeaderPrefix + c.algorithm() + " prev=" + hex.EncodeToString(prev))
+}
+
+// auditHost is what RotatingFileHandler offers AuditHandler: where its set is, so the
+// chain resumes from it, and a hook for the line that opens each new live file.
+type auditHost struct {
+	folder, prefix string
+	naming         BackupNaming
+	aead           cipher.AEAD
+	// header is called under the handler's lock on every Write, fresh when the Write
+	// starts a file; the line it returns, if any, goes before the message.
+	header *func(fresh bool) []byte
+}
+
+// AuditHandler makes inner's log tamper-evident: every message is written with a running
+// SHA-256 chain value appended, so removing, reordering or editing a line breaks the
+// chain from that line on. Verify checks a set and reports the first broken line.
+//
+// Wrapping a RotatingFileHandler (directly or through WithMinLevel), the chain carries
+// across rotations: each new file opens with a header line recording the previous file's
+// final chain value, and on construction the chain resumes from the last value already
+// in the set, so a restart continues it. A live file holding lines written before
+// auditing was enabled gets a header before its first audited line; the lines before a
+// set's first header are not covered. Any other inner writer gets one header, at its
+// first message.
+//
+// The chain covers the messages as AuditHandler sees them, so it refuses to wrap a
+// TimestampedHandler, which adds the stamp afterwards: wrap TimestampedHandler around
+// AuditHandler instead, and the stamp is chained with its message. A RotatingFileHandler
+// takes a single AuditHandler. In both cases every Write of the returned writer fails.
+//
+// The chain assumes every message goes through the returned writer: write nothing to the
+// wrapped handler directly, and do not share the set between processes under
+// WithProcessLock. The chain proves the order and content of the lines it covers; it
+// cannot tell lines cut from the end of the newest file, or whole files pruned from the
+// start of the set, from lines never written. Keep the latest chain value elsewhere when
+// that matters. An error reading the set back at construction is reported on the first
+// Write, and the chain then starts afresh.
+func AuditHandler(inner io.Writer, opts ...AuditOption) io.Writer {
+	var cfg auditConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	target := inner
+	if lw, ok := target.(*leveledWriter); ok {
+		target = lw.inner
+	}
+	var host *auditHost
+	if w, ok := target.(*writer); ok {
+		if w.stamped {
+			return failingAudit(errors.New("loginjector: AuditHandler cannot wrap a TimestampedHandler, " +
+				"which changes every message after it is chained; wrap the TimestampedHandler around it instead"))
+		}
+		host = w.audit
+	}
+
+	prev := make([]byte, sha256.Size)
+	needHeader := true
+	var seedErr error
+	if host != nil {
+		var liveStarted bool
+		var found bool
+		prev, found, liveStarted, seedErr = resumeAudit(host)
+		if !found {
+			prev = make([]byte, sha256.Size)
+		}
+		// the handler asks for the header itself, so it lands in the file the message does.
+		needHeader = liveStarted
+		hw := target.(*writer)
+		hw.m.Lock()
+		taken := *host.header != nil
+		if !taken {
+			*host.header = func(fresh bool) []byte {
+				if !fresh && !needHeader {
+					return nil
+				}
+				needHeader = false
+				return cfg.header(prev)
+			}
+		}
+		hw.m.Unlock()
+		if taken {
+			return failingAudit(errors.New("loginjector: the RotatingFileHandler already has an AuditHandler; a second one would fork its chain"))
+		}
+	}
+
+	// emit chains msg and writes it with write, which passes the level on when known.
+	emit := func(msg []byte, write func([]byte) (int, error)) (int, error) {
+		err := seedErr
+		seedErr = nil
+
+		trimmed := bytes.TrimSpace(msg)
+		chain := cfg.next(prev, trimmed)
+		line := make([]byte, 0, len(auditHeaderPrefix)+auditChainLen+len(trimmed)+auditSuffixLen+32)
+		if needHeader && host == nil {
+			line = append(append(line, cfg.header(prev)...), '\n')
+		}
+		line = appendAuditEscaped(line, trimmed)
+		if len(trimmed) > 0 {
+			line = append(line, ' ')
+		}
+		line = append(append(line, '#'), hex.EncodeToString(chain)...)
+		line = append(line, '\n')
+
+		n, e := write(line)
+		// bytes that reached the sink extend the chain on disk even when the Write also
+		// reports an error, such as a failed rotation after it.
+		if n > 0 {
+			prev = chain
+			if host == nil {
+				needHeader = false
+			}
+		}
+		if e != nil {
+			return 0, errors.Join(err, e)
+		}
+		return len(msg), err
+	}
+
+	return &writer{
+		original: inner,
+		closer:   func() error { return closeHandler(inner) },
+		h:        func(msg []byte) (int, error) { return emit(msg, inner.Write) },
+		hl: func(level LogLevel, msg []byte) (int, error) {
+			return emit(msg, func(p []byte) (int, error) { return writeLevel(inner, level, p) })
+		},
+	}
+}
+
+// failingAudit returns the writer of an AuditHandler that cannot chain: every Write fails
+// with err, and nothing is written.
+func failingAudit(err error) io.Writer {
+	return &writer{h: func([]byte) (int, error) { return 0, err }}
+}
+
+// appendAuditEscaped appends the lines of msg to dst, escaped as the file layout above
+// describes.
+func appendAuditEscaped(dst, msg []byte) []byte {
+	lines := bytes.Split(msg, []byte{'\n'})
+	for i, l := range lines {
+		if i > 0 {
+			dst = append(dst, '\n')
+		}
+		if len(l) > 0 && (l[0] == '#' || l[0] == '\\') {
+			dst = append(dst, '\\')
+		}
+		dst = append(dst, l...)
+		if i < len(lines)-1 && endsLikeAuditRecord(string(l)) {
+			dst = append(dst, '\\')
+		}
+	}
+	return dst
+}
+
+// endsLikeAuditRecord reports whether line, less any trailing '\', would end a message.
+func endsLikeAuditRecord(line string) bool {
+	_, _, ok := parseAuditRecord(strings.TrimRight(line, "\\"))
+	return ok
+}
+
+// unescapeAuditLine reverses appendAuditEscaped for one line; last is set for the text of
+// a message's last line, which never gains a trailing '\'.
+func unescapeAuditLine(line string, last bool) string {
+	if !last && strings.HasSuffix(line, "\\") && endsLikeAuditRecord(line) {
+		line = line[:len(line)-1]
+	}
+	return strings.TrimPrefix(line, "\\")
+}
+
+// resumeAudit returns the last chain value in host's set, whether there is one, and
+// whether the live file already holds lines but no header of its own.
+func resumeAudit(host *auditHost) (prev []byte, found bool, liveStarted bool, err error) {
+	files, err := RotatedFiles(host.folder, host.prefix, WithBackupNaming(host.naming))
+	if err != nil || len(files) == 0 {
+		return nil, false, false, err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		var last []byte
+		lines, headers := 0, 0
+		err := scanAuditFile(files[i], host.aead, func(_ int, line string) error {
+			lines++
+			if v, ok := parseAuditHeader(line); ok {
+				headers++
+				last = v
+			} else if _, v, ok := parseAuditRecord(line); ok {
+				last = v
+			}
+			return nil
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			continue // pruned or compressed since it was listed.
+		}
+		if err != nil {
+			return nil, false, false, err
+		}
+		if i == len(files)-1 {
+			liveStarted = lines > 0 && headers == 0
+		}
+		if last != nil {
+			return last, true, liveStarted, nil
+		}
+	}
+	return nil, false, liveStarted, nil
+}
+
+// AuditError reports where Verify found the chain broken.
+type AuditError struct {
+	File   string // path of the file holding the line.
+	Line   int    // 1-based line number in the file's plaintext.
+	Reason string
+}
+
+func (e *AuditError) Error() string {
+	return fmt.Sprintf("loginjector: audit chain broken at %s:%d: %s", e.File, e.Line, e.Reason)
+}
+
+// Verify checks the chain AuditHandler wrote over prefix's rotating set in folder,
+// oldest file first, and returns an *AuditError locating the first line that does not
+// follow from the lines before it: an edited or inserted line, a removed one, a removed
+// or reordered file, or a line with no chain value. It returns nil for an intact set. The
+// first header found starts the chain, so a set whose oldest files were pruned verifies
+// from where it now begins; lines before that header are not checked.
+//
+// Give the HMAC key with WithAuditKey, and the naming or encryption of the set with
+// WithAuditFiles. Errors reading the files are returned as they are.
+func Verify(folder, prefix string, opts ...AuditOption) error {
+	var cfg auditConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+	rc := rotatingFileConfig{}
+	for _, o := range cfg.files {
+		o(&rc)
+	}
+	var aead cipher.AEAD
+	if rc.key != nil {
+		var err error
+		if aead, err = newRecordCipher(rc.key); err != nil {
+			return err
+		}
+	}
+	files, err := RotatedFiles(folder, prefix, WithBackupNaming(rc.naming))
+	if err != nil {
+		return err
+	}
+
+	var prev []byte // nil until the first header.
+	for _, path := range files {
+		var pending []string // lines of a message whose chain value is still to come.
+		first := 0
+		broken := func(line int, reason string) error {
+			return &AuditError{File: path, Line: line, Reason: reason}
+		}
+		err := scanAuditFile(path, aead, func(n int, line string) error {
+			if text, v, ok := parseAuditRecord(line); ok {
+				if prev == nil {
+					return broken(n, "chained line before any audit header")
+				}
+				if len(pending) == 0 {
+					first = n
+				}
+				msg := strings.Join(append(pending, unescapeAuditLine(text, true)), "\n")
+				pending = pending[:0]
+				if !hmac.Equal(cfg.next(prev, []byte(msg)), v) {
+					return broken(first, "chain value does not match the line")
+				}
+				prev = v
+				return nil
+			}
+			if v, ok := parseAuditHeader(line); ok {
+				if len(pending) > 0 {
+					return broken(first, "line has no chain value")
+				}
+				if alg := strings.Fields(line[len(auditHeaderPrefix):])[0]; alg != cfg.algorithm() {
+					return broken(n, fmt.Sprintf("header chains with %s, verifying %s", alg, cfg.algorithm()))
+				}
+				if prev != nil && !bytes.Equal(prev, v) {
+					return broken(n, "header does not continue the previous line")
+				}
+				prev = v
+				return nil
+			}
+			if prev == nil {
+				return nil // written before auditing was enabled.
+			}
+			if len(pending) == 0 {
+				first = n
+			}
+			pending = append(pending, unescapeAuditLine(line, false))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			return broken(first, "line has no chain value")
+		}
+	}
+	return nil
+}
+
+// parseAuditHeader returns the value a header line continues from.
+func parseAuditHeader(line string) ([]byte, bool) {
+	rest, ok := strings.CutPrefix(line, auditHeaderPrefix)
+	if !ok {
+		return nil, false
+	}
+	f := strings.Fields(rest)
+	if len(f) != 2 || !strings.HasPrefix(f[1], "prev=") {
+		return nil, false
+	}
+	v, err := hex.DecodeString(strings.TrimPrefix(f[1], "prev="))
+	if err != nil || len(v) != sha256.Size {
+		return nil, false
+	}
+	return v, true
+}
+
+// parseAuditRecord splits the last line of a message into its text and chain value.
+func parseAuditRecord(line string) (string, []byte, bool) {
+	if len(line) < auditSuffixLen || line[len(line)-auditSuffixLen] != '#' {
+		return "", nil, false
+	}
+	v, err := hex.DecodeString(line[len(line)-auditChainLen:])
+	if err != nil {
+		return "", nil, false
+	}
+	return strings.TrimSuffix(line[:len(line)-auditSuffixLen], " "), v, true
+}
+
+// scanAuditFile calls fn with every line of the plaintext of the file at path, numbered
+// from 1, decrypting it with aead when it holds records and gunzipping a .gz.
+func scanAuditFile(path string, aead cipher.AEAD, fn func(n int, line string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	var src io.Reader = f
+	sealed, err := IsEncrypted(f)
+	if err != nil {
+		return err
+	}
+	if sealed {
+		if aead == nil {
+			return fmt.Errorf("loginjector: %s is encrypted: give the key with WithAuditFiles(WithEncryption(key))", filepath.Base(path))
+		}
+		src = &decryptingReader{src: f, aead: aead}
+	}
+	if strings.HasSuffix(path, "."+gzipExtension) {
+		zr, err := gzip.NewReader(src)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = zr.Close() }()
+		src = zr
+	}
+
+	br := bufio.NewReader(src)
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if len(line) > 0 {
+			if e := fn(n, strings.TrimSuffix(line, "\n")); e != nil {
+				return e
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
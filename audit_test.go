@@ -0,0 +1,201 @@
+package loginjector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requireBrokenAt asserts that err is an *AuditError at line of the file named base.
+func requireBrokenAt(t *testing.T, err error, base string, line int) {
+	t.Helper()
+	var ae *AuditError
+	require.True(t, errors.As(err, &ae), "want an *AuditError, got %v", err)
+	assert.Equal(t, base, filepath.Base(ae.File), ae.Error())
+	assert.Equal(t, line, ae.Line, ae.Error())
+}
+
+// editFile applies fn to the content of dir/base.
+func editFile(t *testing.T, dir, base string, fn func(string) string) {
+	t.Helper()
+	path := filepath.Join(dir, base)
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte(fn(string(b))), defaultFilePermissions))
+}
+
+// writeAudited writes n messages "msg <i>" through an AuditHandler over a rotating set
+// in dir that holds two messages per file.
+func writeAudited(t *testing.T, dir string, n int, opts ...AuditOption) {
+	t.Helper()
+	h := AuditHandler(RotatingFileHandler(dir, "app", WithMaxFileSize(200), WithMaxFiles(50)), opts...)
+	for i := 0; i < n; i++ {
+		writeRotating(t, h, fmt.Sprintf("msg %d", i))
+	}
+}
+
+func TestAuditHandler(t *testing.T) {
+	t.Parallel()
+
+	t.Run("the chain carries across rotations and restarts", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		writeAudited(t, dir, 5)
+		writeAudited(t, dir, 5)
+		require.NoError(t, Verify(dir, "app"))
+
+		files := extractFilesWithGzOrFail(t, dir)
+		require.Greater(t, len(files), 2)
+		first := files[idxName("app", 1)]
+		assert.True(t, strings.HasPrefix(first, "#audit v1 sha256 prev="+strings.Repeat("0", 64)+"\nmsg 0 #"), first)
+		for name, content := range files {
+			assert.True(t, strings.HasPrefix(content, "#audit v1 sha256 prev="), "%s opens with a header", name)
+			assert.Equal(t, 1, strings.Count(content, "#audit"), "%s has one header", name)
+		}
+	})
+
+	t.Run("an edited line is pinpointed", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		writeAudited(t, dir, 9)
+		editFile(t, dir, idxName("app", 2), func(s string) string { return strings.Replace(s, "msg 3", "msg 9", 1) })
+		requireBrokenAt(t, Verify(dir, "app"), idxName("app", 2), 3)
+	})
+
+	t.Run("a removed line breaks the chain at the next one", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		writeAudited(t, dir, 9)
+		editFile(t, dir, idxName("app", 2), func(s string) string {
+			lines := strings.SplitAfter(s, "\n")
+			return strings.Join(append(lines[:1], lines[2:]...), "")
+		})
+		requireBrokenAt(t, Verify(dir, "app"), idxName("app", 2), 2)
+	})
+
+	t.Run("a removed file breaks the chain at the next header", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		writeAudited(t, dir, 9)
+		require.NoError(t, os.Remove(filepath.Join(dir, idxName("app", 2))))
+		requireBrokenAt(t, Verify(dir, "app"), idxName("app", 3), 1)
+
+		require.NoError(t, os.Remove(filepath.Join(dir, idxName("app", 1))))
+		assert.NoError(t, Verify(dir, "app"), "a pruned start verifies from the first header left")
+	})
+
+	t.Run("multi-line messages are chained whole", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := TimestampedHandler(AuditHandler(RotatingFileHandler(dir, "app")))
+		writeRotating(t, h, "panic: boom\ngoroutine 1\nmain.go:10")
+		writeRotating(t, h, "recovered")
+		require.NoError(t, Verify(dir, "app"))
+
+		editFile(t, dir, idxName("app", 1), func(s string) string { return strings.Replace(s, "goroutine 1", "goroutine 2", 1) })
+		requireBrokenAt(t, Verify(dir, "app"), idxName("app", 1), 2)
+	})
+
+	t.Run("message text that looks like chain metadata is escaped", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		fake := "#" + strings.Repeat("ab", 32)
+		msgs := []string{
+			"#audit v1 sha256 prev=" + strings.Repeat("0", 64),
+			"first " + fake + "\nsecond " + fake + "\\\nthird",
+			"\\#not a header\n" + fake,
+			fake,
+		}
+		h := AuditHandler(RotatingFileHandler(dir, "app"))
+		for _, m := range msgs {
+			writeRotating(t, h, m)
+		}
+		require.NoError(t, Verify(dir, "app"))
+		content := extractFilesWithGzOrFail(t, dir)[idxName("app", 1)]
+		assert.Equal(t, 1, strings.Count("\n"+content, "\n#audit"), content)
+		assert.Contains(t, content, "\\#audit v1 sha256 prev=")
+		assert.Contains(t, content, "first "+fake+"\\\nsecond "+fake+"\\\\\nthird #")
+
+		h = AuditHandler(RotatingFileHandler(dir, "app"))
+		writeRotating(t, h, "after a restart")
+		require.NoError(t, Verify(dir, "app"), "the chain resumes past the escaped lines")
+
+		editFile(t, dir, idxName("app", 1), func(s string) string { return strings.Replace(s, "first ", "1st ", 1) })
+		requireBrokenAt(t, Verify(dir, "app"), idxName("app", 1), 3)
+	})
+
+	t.Run("a second AuditHandler and one over a TimestampedHandler are refused", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		rotating := RotatingFileHandler(dir, "app")
+		writeRotating(t, AuditHandler(rotating), "chained")
+		_, err := AuditHandler(WithMinLevel(1, rotating)).Write([]byte("forked"))
+		assert.ErrorContains(t, err, "already has an AuditHandler")
+
+		_, err = AuditHandler(TimestampedHandler(RotatingFileHandler(dir, "other"))).Write([]byte("stamped later"))
+		assert.ErrorContains(t, err, "cannot wrap a TimestampedHandler")
+		assert.NoFileExists(t, filepath.Join(dir, idxName("other", 1)))
+		require.NoError(t, Verify(dir, "app"))
+	})
+
+	t.Run("a keyed chain needs the key", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		key := []byte("audit key")
+		writeAudited(t, dir, 4, WithAuditKey(key))
+		require.NoError(t, Verify(dir, "app", WithAuditKey(key)))
+		requireBrokenAt(t, Verify(dir, "app", WithAuditKey([]byte("other key"))), idxName("app", 1), 2)
+		requireBrokenAt(t, Verify(dir, "app"), idxName("app", 1), 1)
+	})
+
+	t.Run("lines written before auditing are left out", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		writeRotating(t, RotatingFileHandler(dir, "app"), "not audited")
+		writeAudited(t, dir, 2)
+		require.NoError(t, Verify(dir, "app"))
+		assert.True(t, strings.HasPrefix(extractFilesWithGzOrFail(t, dir)[idxName("app", 1)], "not audited\n#audit v1 "))
+	})
+
+	t.Run("compressed and encrypted sets verify", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := AuditHandler(RotatingFileHandler(dir, "app", WithMaxFileSize(300), WithMaxFiles(50), WithCompress(), WithEncryption(testKey)))
+		for i := 0; i < 9; i++ {
+			writeRotating(t, h, fmt.Sprintf("msg %d", i))
+		}
+		require.FileExists(t, filepath.Join(dir, idxName("app", 1)+"."+gzipExtension))
+		require.NoError(t, Verify(dir, "app", WithAuditFiles(WithEncryption(testKey))))
+		assert.ErrorContains(t, Verify(dir, "app"), "is encrypted")
+	})
+
+	t.Run("a plain writer gets one header", func(t *testing.T) {
+		t.Parallel()
+
+		var buf strings.Builder
+		h := AuditHandler(&buf)
+		writeRotating(t, h, "one")
+		writeRotating(t, h, "two")
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		require.Len(t, lines, 3)
+		assert.True(t, strings.HasPrefix(lines[0], "#audit v1 sha256 prev="))
+		assert.True(t, strings.HasPrefix(lines[1], "one #"))
+		assert.True(t, strings.HasPrefix(lines[2], "two #"))
+	})
+}
@@ -0,0 +1,194 @@
+package loginjector
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BackupNaming selects how RotatingFileHandler names rotated backups; pass it to
+// WithBackupNaming. The zero value is IndexedBackups, the historical scheme.
+type BackupNaming struct {
+	timestamp bool
+	hostname  bool
+	pid       bool
+	host      string // resolved by WithHostname.
+}
+
+// IndexedBackups is the default naming scheme: prefix.<8 hex index>.log[.gz], e.g.
+// app.0000002A.log.
+func IndexedBackups() BackupNaming {
+	return BackupNaming{}
+}
+
+// TimestampBackups names each backup after the UTC second it was rotated out, e.g.
+// app-2026-10-16T14-00-00.log[.gz], so the folder reads meaningfully to a human. Names sort
+// lexically in rotation order. When two rotations fall in the same second the later
+// backup is stamped one second on, so a name is never reused and order is kept.
+func TimestampBackups() BackupNaming {
+	return BackupNaming{timestamp: true}
+}
+
+// WithHostname adds the host name (os.Hostname, path separators replaced by '_') after the
+// prefix — app-web1-2026-10-16T14-00-00.log — so hosts sharing a folder never collide. Only
+// this host's backups are then pruned or resumed. It applies to TimestampBackups only.
+func (n BackupNaming) WithHostname() BackupNaming {
+	n.hostname = true
+	if h, err := os.Hostname(); err == nil && h != "" {
+		n.host = strings.NewReplacer("/", "_", `\`, "_").Replace(h)
+	} else {
+		n.host = "localhost"
+	}
+	return n
+}
+
+// WithPID adds the process ID after the prefix (and host name) —
+// app-4242-2026-10-16T14-00-00.log. Backups carrying any PID count as the set's own, so
+// those written before a restart are still pruned. It applies to TimestampBackups only.
+func (n BackupNaming) WithPID() BackupNaming {
+	n.pid = true
+	return n
+}
+
+// WithBackupNaming selects the naming scheme for rotated backups. With TimestampBackups
+// the live file is the fixed prefix.log, as under WithStableCurrentName, and each rotation
+// renames it to its timestamped backup name; WithCompress then gzips that backup in place.
+// Pruning, resume, WithFreshStart and crash reconciliation all understand the scheme.
+//
+// Migration: when a TimestampBackups handler starts over a folder that still holds
+// indexed prefix.<8 hex>.log[.gz] files from an earlier version, it renames them once to
+// timestamp names derived from their mtime, oldest index first, so they stay in order, keep
+// aging out under WithMaxAge, and are never orphaned. A rename failure is reported on the
+// first Write and the file is still pruned under its old name. Going back to
+// IndexedBackups is not migrated: timestamped backups are then left untouched.
+func WithBackupNaming(n BackupNaming) RotatingFileOption {
+	return func(c *rotatingFileConfig) {
+		c.naming = n
+		if n.timestamp {
+			c.stableName = true
+		}
+	}
+}
+
+// backupTimeLayout is the time component of a TimestampBackups name. It avoids ':' so names
+// stay portable.
+const backupTimeLayout = "2006-01-02T15-04-05"
+
+// backupName renders the TimestampBackups name for a backup rotated out at t.
+func (n BackupNaming) backupName(prefix string, t time.Time) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	b.WriteByte('-')
+	if n.hostname {
+		b.WriteString(n.host)
+		b.WriteByte('-')
+	}
+	if n.pid {
+		b.WriteString(strconv.Itoa(os.Getpid()))
+		b.WriteByte('-')
+	}
+	b.WriteString(t.UTC().Format(backupTimeLayout))
+	b.WriteString("." + defaultFileExtension)
+	return b.String()
+}
+
+// parseBackup parses a backup base name under this scheme into an ordering key. Indexed
+// names yield their index; timestamp names yield their Unix second, which sorts after
+// any index a real folder reaches, so un-migrated legacy backups count as the oldest. A TimestampBackups
+// scheme also accepts indexed names for that reason; IndexedBackups accepts only indexed.
+func (n BackupNaming) parseBackup(base, prefix string) (key int, gz bool, ok bool) {
+	if n.timestamp {
+		if key, gz, ok = n.parseTimestamp(base, prefix); ok {
+			return key, gz, ok
+		}
+	}
+	return parseRotationIndex(base, prefix)
+}
+
+// parseTimestamp applies the strict prefix-[host-][pid-]<time>.log shape check, requiring
+// exactly the components this scheme writes so a sibling prefix such as app-errors never
+// parses as app's.
+func (n BackupNaming) parseTimestamp(base, prefix string) (key int, gz bool, ok bool) {
+	if strings.HasSuffix(base, "."+gzipExtension) {
+		gz = true
+		base = strings.TrimSuffix(base, "."+gzipExtension)
+	}
+	if !strings.HasSuffix(base, "."+defaultFileExtension) || !strings.HasPrefix(base, prefix+"-") {
+		return 0, gz, false
+	}
+	rest := strings.TrimSuffix(strings.TrimPrefix(base, prefix+"-"), "."+defaultFileExtension)
+	if len(rest) < len(backupTimeLayout) {
+		return 0, gz, false
+	}
+	stamp, mid := rest[len(rest)-len(backupTimeLayout):], rest[:len(rest)-len(backupTimeLayout)]
+	t, err := time.Parse(backupTimeLayout, stamp)
+	if err != nil {
+		return 0, gz, false
+	}
+	if n.hostname {
+		if !strings.HasPrefix(mid, n.host+"-") {
+			return 0, gz, false
+		}
+		mid = strings.TrimPrefix(mid, n.host+"-")
+	}
+	if n.pid {
+		digits := strings.TrimSuffix(mid, "-")
+		if digits == mid || digits == "" || strings.Trim(digits, "0123456789") != "" {
+			return 0, gz, false
+		}
+		mid = ""
+	}
+	if mid != "" {
+		return 0, gz, false
+	}
+	return int(t.Unix()), gz, true
+}
+
+// nextBackupName returns the first free TimestampBackups name after t and after every
+// timestamped backup already in folder, and the time it encodes. Never stamping earlier
+// than an existing backup keeps names in rotation order even once pruning frees an older
+// name in the same second.
+func (n BackupNaming) nextBackupName(folder, prefix string, t time.Time) (string, time.Time) {
+	t = t.UTC().Truncate(time.Second)
+	if entries, err := os.ReadDir(folder); err == nil {
+		for _, e := range entries {
+			if key, _, ok := n.parseTimestamp(e.Name(), prefix); ok && int64(key) >= t.Unix() {
+				t = time.Unix(int64(key)+1, 0).UTC()
+			}
+		}
+	}
+	for {
+		name := n.backupName(prefix, t)
+		_, e1 := os.Stat(filepath.Join(folder, name))
+		_, e2 := os.Stat(filepath.Join(folder, name+"."+gzipExtension))
+		if os.IsNotExist(e1) && os.IsNotExist(e2) {
+			return name, t
+		}
+		t = t.Add(time.Second)
+	}
+}
+
+// migrateIndexedBackups renames prefix's indexed backups in folder to TimestampBackups
+// names derived from their mtime, oldest index first. nextBackupName stamps each one after
+// the previous, so rotation order survives even when mtimes tie or run backwards.
+// A .log/.log.gz pair for one index is renamed together.
+func migrateIndexedBackups(folder, prefix string, n BackupNaming) error {
+	groups, err := listBackups(folder, prefix, IndexedBackups(), true, "")
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		name, _ := n.nextBackupName(folder, prefix, g.mtime)
+		for _, p := range g.paths {
+			dst := name
+			if strings.HasSuffix(p, "."+gzipExtension) {
+				dst += "." + gzipExtension
+			}
+			err = errors.Join(err, os.Rename(p, filepath.Join(folder, dst)))
+		}
+	}
+	return err
+}
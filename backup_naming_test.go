@@ -0,0 +1,179 @@
+package loginjector
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// backupNamesOrFail returns the sorted base names in folder matching re.
+func backupNamesOrFail(t *testing.T, folder string, re *regexp.Regexp) []string {
+	t.Helper()
+	entries, err := os.ReadDir(folder)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		if re.MatchString(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+var timestampBackupRe = regexp.MustCompile(`^app-\d{4}-\d{2}-\d{2}T\d{2}-\d{2}-\d{2}\.log(\.gz)?$`)
+
+func TestWithBackupNaming(t *testing.T) {
+	t.Run("timestamp backups behind a stable live file", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(5), WithMaxFiles(50), WithBackupNaming(TimestampBackups()))
+
+		writeRotating(t, h, "first") // rotates: app.log -> app-<now>.log
+		writeRotating(t, h, "again") // same second: stamped one second on
+		writeRotating(t, h, "z")
+
+		names := backupNamesOrFail(t, dir, timestampBackupRe)
+		require.Len(t, names, 2)
+		files := extractFilesWithGzOrFail(t, dir)
+		require.Equal(t, "first\n", files[names[0]], "names sort in rotation order")
+		require.Equal(t, "again\n", files[names[1]])
+		require.Equal(t, "z\n", files["app.log"])
+	})
+
+	t.Run("compression and pruning understand the scheme", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(5), WithMaxFiles(3), WithCompress(), WithBackupNaming(TimestampBackups()))
+		for _, m := range []string{"one-1", "two-2", "three", "four4"} {
+			writeRotating(t, h, m)
+		}
+
+		names := backupNamesOrFail(t, dir, timestampBackupRe)
+		require.Len(t, names, 2, "maxFiles-1 backups are kept")
+		require.Equal(t, "three\n", readGzOrFail(t, filepath.Join(dir, names[0])))
+		require.Equal(t, "four4\n", readGzOrFail(t, filepath.Join(dir, names[1])))
+	})
+
+	t.Run("indexed backups are migrated in order", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		// index order disagrees with mtime for 2 and 3: the index order must win.
+		touchOld(t, filepath.Join(dir, idxName("app", 1)), "one\n", 3*time.Hour)
+		touchOld(t, filepath.Join(dir, idxName("app", 2)), "two\n", time.Hour)
+		touchOld(t, filepath.Join(dir, idxName("app", 3)), "three\n", 2*time.Hour)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "other.00000001.log"), []byte("foreign\n"), defaultFilePermissions))
+
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(1000), WithMaxFiles(50), WithBackupNaming(TimestampBackups()))
+		writeRotating(t, h, "live")
+
+		names := backupNamesOrFail(t, dir, timestampBackupRe)
+		require.Len(t, names, 3)
+		files := extractFilesWithGzOrFail(t, dir)
+		require.Equal(t, "one\n", files[names[0]])
+		require.Equal(t, "two\n", files[names[1]])
+		require.Equal(t, "three\n", files[names[2]])
+		require.Equal(t, "live\n", files["app.log"])
+		require.Equal(t, "foreign\n", files["other.00000001.log"], "another prefix is untouched")
+		require.NotContains(t, files, idxName("app", 1))
+	})
+
+	t.Run("migrated backups still age out", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		touchOld(t, filepath.Join(dir, idxName("app", 1)), "stale\n", 48*time.Hour)
+
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(5), WithMaxFiles(50), WithMaxAge(24*time.Hour), WithBackupNaming(TimestampBackups()))
+		writeRotating(t, h, "abcde") // rotates and prunes
+
+		names := backupNamesOrFail(t, dir, timestampBackupRe)
+		require.Len(t, names, 1, "the migrated 48h-old backup is pruned; the fresh one stays")
+		require.Equal(t, "abcde\n", extractFilesWithGzOrFail(t, dir)[names[0]])
+	})
+
+	t.Run("fresh start wipes timestamped backups", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		name := TimestampBackups().backupName("app", time.Now().Add(-time.Hour))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("old\n"), defaultFilePermissions))
+
+		h := RotatingFileHandler(dir, "app", WithFreshStart(), WithBackupNaming(TimestampBackups()))
+		writeRotating(t, h, "fresh")
+
+		require.NoFileExists(t, filepath.Join(dir, name))
+	})
+}
+
+func TestWithBackupNaming_pidsInOneSecond(t *testing.T) {
+	t.Parallel()
+
+	// two earlier processes rotated in the same second; their backups share a time key.
+	dir := t.TempDir()
+	for _, name := range []string{"app-100-2026-10-16T14-00-00.log", "app-200-2026-10-16T14-00-00.log", "app-100-2026-10-16T14-00-01.log"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(name+"\n"), defaultFilePermissions))
+	}
+
+	h := RotatingFileHandler(dir, "app", WithMaxFileSize(5), WithMaxFiles(4), WithBackupNaming(TimestampBackups().WithPID()))
+	writeRotating(t, h, "abcde") // rotates out a fourth backup; three are kept.
+	writeRotating(t, h, "z")
+
+	names := backupNamesOrFail(t, dir, regexp.MustCompile(`^app-\d+-.*\.log$`))
+	require.Len(t, names, 3, "each PID's backup counts on its own")
+	require.NotContains(t, names, "app-100-2026-10-16T14-00-00.log", "only the oldest by name is pruned")
+	require.Contains(t, names, "app-200-2026-10-16T14-00-00.log")
+}
+
+func TestBackupNaming_parseBackup(t *testing.T) {
+	t.Parallel()
+
+	stamp := time.Date(2026, 10, 16, 14, 0, 0, 0, time.UTC)
+	plain := TimestampBackups()
+	pid := TimestampBackups().WithPID()
+	host := TimestampBackups().WithHostname()
+	host.host = "web-1.example"
+
+	cases := []struct {
+		name   string
+		naming BackupNaming
+		base   string
+		ok     bool
+		gz     bool
+	}{
+		{"timestamp", plain, "app-2026-10-16T14-00-00.log", true, false},
+		{"timestamp gz", plain, "app-2026-10-16T14-00-00.log.gz", true, true},
+		{"legacy index under timestamp scheme", plain, "app.00000003.log", true, false},
+		{"sibling prefix is not ours", plain, "app-errors-2026-10-16T14-00-00.log", false, false},
+		{"live file", plain, "app.log", false, false},
+		{"any pid", pid, "app-4242-2026-10-16T14-00-00.log", true, false},
+		{"pid missing", pid, "app-2026-10-16T14-00-00.log", false, false},
+		{"pid not numeric", pid, "app-errors-2026-10-16T14-00-00.log", false, false},
+		{"own host", host, "app-web-1.example-2026-10-16T14-00-00.log", true, false},
+		{"other host", host, "app-web-2.example-2026-10-16T14-00-00.log", false, false},
+		{"indexed scheme rejects timestamps", IndexedBackups(), "app-2026-10-16T14-00-00.log", false, false},
+	}
+	for _, tc := range cases {
+		key, gz, ok := tc.naming.parseBackup(tc.base, "app")
+		require.Equal(t, tc.ok, ok, tc.name)
+		if !ok {
+			continue
+		}
+		require.Equal(t, tc.gz, gz, tc.name)
+		if tc.naming.timestamp && tc.base != "app.00000003.log" {
+			require.Equal(t, int(stamp.Unix()), key, tc.name)
+		}
+	}
+
+	name := TimestampBackups().WithHostname().WithPID().backupName("app", stamp)
+	require.Regexp(t, `^app-.+-`+strconv.Itoa(os.Getpid())+`-2026-10-16T14-00-00\.log$`, name)
+}
@@ -0,0 +1,134 @@
+package loginjector
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// batcher collects the entries of a pushing handler, such as LokiHandler, and pushes
+// them encoded as one request body: by the add that fills a batch to size, in the
+// background once wait has passed since its first entry, and on close. The error of a
+// background push goes to onError, or is returned by close, but never by a later add: a
+// Retry or Failover in front of the handler would take that add as failed and send its
+// entry twice. A body encode returns with an error, for entries it left out, is still
+// pushed.
+type batcher[T any] struct {
+	size      int
+	wait      time.Duration
+	afterFunc func(d time.Duration, f func()) (stop func())
+	encode    func(entries []T) ([]byte, error)
+	push      io.Writer   // posts a request body, with retries.
+	onError   func(error) // nil keeps background errors for close.
+
+	mu      sync.Mutex
+	entries []T
+	stop    func() // cancels the batch's timer; nil when none runs.
+	gen     uint64 // counts batches, so a timer firing late ignores a newer one.
+	err     error  // the errors of background pushes, for close.
+
+	pushMu sync.Mutex // keeps pushes in order.
+}
+
+// add batches e and pushes the batch when it is full.
+func (b *batcher[T]) add(e T) error {
+	b.mu.Lock()
+	b.entries = append(b.entries, e)
+	if len(b.entries) == 1 {
+		gen := b.gen
+		b.stop = b.afterFunc(b.wait, func() {
+			b.mu.Lock()
+			if b.gen != gen {
+				b.mu.Unlock()
+				return // the batch was pushed already.
+			}
+			b.stop = nil
+			b.gen++
+			b.mu.Unlock()
+			b.report(b.flush())
+		})
+	}
+	due := len(b.entries) >= b.size
+	if due {
+		b.endBatch()
+	}
+	b.mu.Unlock()
+
+	if due {
+		return b.flush()
+	}
+	return nil
+}
+
+// endBatch stops the batch's timer; b.mu must be held.
+func (b *batcher[T]) endBatch() {
+	if b.stop != nil {
+		b.stop()
+		b.stop = nil
+	}
+	b.gen++
+}
+
+// report hands the error of a background push to onError, or keeps it for close.
+func (b *batcher[T]) report(err error) {
+	if err == nil {
+		return
+	}
+	if b.onError != nil {
+		b.onError(err)
+		return
+	}
+	b.mu.Lock()
+	b.err = errors.Join(b.err, err)
+	b.mu.Unlock()
+}
+
+// flush pushes the batch, if any.
+func (b *batcher[T]) flush() error {
+	b.pushMu.Lock()
+	defer b.pushMu.Unlock()
+
+	b.mu.Lock()
+	entries := b.entries
+	b.entries = nil
+	b.mu.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	body, err := b.encode(entries)
+	if body == nil {
+		return err
+	}
+	_, e := b.push.Write(body)
+	return errors.Join(err, e)
+}
+
+// close stops the batch's timer and pushes what is batched.
+func (b *batcher[T]) close() error {
+	b.mu.Lock()
+	b.endBatch()
+	b.mu.Unlock()
+	err := b.flush()
+	b.mu.Lock()
+	err = errors.Join(b.err, err)
+	b.err = nil
+	b.mu.Unlock()
+	return err
+}
+
+// gzipBody compresses a request body with gzip.
+func gzipBody(body []byte) ([]byte, error) {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -60,3 +60,22 @@ func CloseOrJoin(errp *error, c io.Closer) {
 	}
 	*errp = errors.Join(*errp, closeErr)
 }
+
+// closeHandler releases the background work of a handler built by this package: a
+// *writer with a closer runs it, and a wrapper (ensureThreadSafe's *writer, WithMinLevel)
+// is peeled to reach the handler inside. Any other io.Writer — os.Stdout, a user file —
+// is deliberately left open: the logger does not own caller-supplied sinks.
+func closeHandler(w io.Writer) error {
+	switch h := w.(type) {
+	case *writer:
+		if h.closer != nil {
+			return h.closer()
+		}
+		if h.original != nil {
+			return closeHandler(h.original)
+		}
+	case *leveledWriter:
+		return closeHandler(h.inner)
+	}
+	return nil
+}
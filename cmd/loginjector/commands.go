@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/prorochestvo/loginjector/logread"
+)
+
+// runCat prints every line of the sets, merged by timestamp when there are several.
+func runCat(_ context.Context, args []string, stdout, stderr io.Writer) error {
+	var c common
+	fs := newFlagSet("cat", stderr, &c, true)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return errors.New("want DIR PREFIX [PREFIX...]")
+	}
+	opts, err := c.options(time.Now())
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(stdout)
+	err = mergeSets(fs.Arg(0), fs.Args()[1:], opts, func(set string, l logread.Line) error {
+		return printLine(out, set, l)
+	})
+	return errors.Join(err, out.Flush())
+}
+
+// runGrep prints the lines matching PATTERN; it returns errNoMatch when there are none.
+func runGrep(_ context.Context, args []string, stdout, stderr io.Writer) error {
+	var c common
+	fs := newFlagSet("grep", stderr, &c, true)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 3 {
+		return errors.New("want PATTERN DIR PREFIX [PREFIX...]")
+	}
+	re, err := regexp.Compile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	opts, err := c.options(time.Now())
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(stdout)
+	matched := false
+	err = mergeSets(fs.Arg(1), fs.Args()[2:], opts, func(set string, l logread.Line) error {
+		if !re.MatchString(l.Text) {
+			return nil
+		}
+		matched = true
+		return printLine(out, set, l)
+	})
+	if err = errors.Join(err, out.Flush()); err == nil && !matched {
+		err = errNoMatch
+	}
+	return err
+}
+
+// runTail prints the last lines of a set and, with -f, follows it until ctx is done.
+func runTail(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	var c common
+	fs := newFlagSet("tail", stderr, &c, true)
+	follow := fs.Bool("f", false, "keep printing lines as they are written, across rotations")
+	n := fs.Int("n", 10, "number of existing lines to print first")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("want DIR PREFIX")
+	}
+	if *n < 0 {
+		return fmt.Errorf("-n: %d is negative", *n)
+	}
+	opts, err := c.options(time.Now())
+	if err != nil {
+		return err
+	}
+	dir, prefix := fs.Arg(0), fs.Arg(1)
+
+	if !*follow {
+		lines, err := logread.Tail(dir, prefix, *n, opts...)
+		if err != nil {
+			return err
+		}
+		for _, l := range lines {
+			if _, err := fmt.Fprintln(stdout, l.Text); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	f, err := logread.Follow(ctx, dir, prefix, append(opts, logread.WithTail(*n))...)
+	if err != nil {
+		return err
+	}
+	for f.Next() {
+		if _, err := fmt.Fprintln(stdout, f.Line().Text); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	return errors.Join(f.Err(), f.Close())
+}
+
+// printLine writes l, prefixed with its set's name when set is not empty.
+func printLine(w io.Writer, set string, l logread.Line) error {
+	var err error
+	if set != "" {
+		_, err = fmt.Fprintf(w, "%s: %s\n", set, l.Text)
+	} else {
+		_, err = fmt.Fprintln(w, l.Text)
+	}
+	return err
+}
+
+// mergeSets calls emit for every line of prefixes' sets in dir, oldest stamp first. A set
+// keeps its turn while its lines carry the stamp being emitted, so a multi-line message
+// is never interleaved with another set's lines. With a single prefix, set is empty.
+func mergeSets(dir string, prefixes []string, opts []logread.Option, emit func(set string, l logread.Line) error) error {
+	readers := make([]*logread.Reader, 0, len(prefixes))
+	defer func() {
+		for _, r := range readers {
+			_ = r.Close()
+		}
+	}()
+	for _, p := range prefixes {
+		r, err := logread.Open(dir, p, opts...)
+		if err != nil {
+			return err
+		}
+		readers = append(readers, r)
+	}
+
+	heads := make([]*logread.Line, len(readers))
+	advance := func(i int) {
+		heads[i] = nil
+		if readers[i].Next() {
+			l := readers[i].Line()
+			heads[i] = &l
+		}
+	}
+	for i := range readers {
+		advance(i)
+	}
+	last := -1
+	for {
+		pick := -1
+		for i, h := range heads {
+			if h == nil {
+				continue
+			}
+			if pick < 0 || h.Time.Before(heads[pick].Time) || (i == last && h.Time.Equal(heads[pick].Time)) {
+				pick = i
+			}
+		}
+		if pick < 0 {
+			break
+		}
+		set := ""
+		if len(prefixes) > 1 {
+			set = prefixes[pick]
+		}
+		if err := emit(set, *heads[pick]); err != nil {
+			return err
+		}
+		last = pick
+		advance(pick)
+	}
+
+	var err error
+	for _, r := range readers {
+		err = errors.Join(err, r.Err())
+	}
+	return err
+}
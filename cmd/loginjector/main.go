@@ -0,0 +1,238 @@
+// Command loginjector reads back the rotating log sets written by
+// github.com/prorochestvo/loginjector. It prints, follows, searches and summarises a set
+// in write order — gzipped backups, hex-indexed or timestamped names and the live file
+// alike — so reading the logs needs no zcat, sort and awk pipeline over the file names.
+//
+// Usage:
+//
+//	loginjector cat   [flags] DIR PREFIX [PREFIX...]
+//	loginjector tail  [-f] [-n N] [flags] DIR PREFIX
+//	loginjector grep  [flags] PATTERN DIR PREFIX [PREFIX...]
+//	loginjector stats [flags] DIR PREFIX [PREFIX...]
+//
+// cat prints every line of the set. tail prints the last N lines (10 by default), reading
+// backups newest first only as far back as they reach, and, with -f, keeps printing new
+// lines across rotations until interrupted. grep prints the lines
+// matching the regular expression PATTERN. stats counts lines per level per hour, reading
+// the level from the first word of each message as logread.LevelWord does.
+//
+// Given several prefixes in one DIR, cat, grep and stats merge the sets by timestamp and
+// prefix each line with its set's name; a multi-line message is never split.
+//
+// Every subcommand accepts -since and -until, which take an RFC 3339 time, a local
+// "2006-01-02[ 15:04[:05]]" time, or a duration counted back from now such as 2h; cat,
+// tail and grep also accept -level, the lowest level to print. -layout and -utc describe
+// the stamps when TimestampedHandler was given WithTimeLayout or a UTC clock, and
+// -hostname and -pid select TimestampBackups names that carry them. -key-file names a
+// file holding the hex key of a set written under WithEncryption; without it the key is
+// taken from $LOGINJECTOR_KEY when that is set.
+//
+// The exit status is 0 on success, 1 when grep matched nothing, and 2 on an error.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prorochestvo/loginjector"
+	"github.com/prorochestvo/loginjector/levels"
+	"github.com/prorochestvo/loginjector/logread"
+)
+
+const usage = `usage:
+  loginjector cat   [flags] DIR PREFIX [PREFIX...]
+  loginjector tail  [-f] [-n N] [flags] DIR PREFIX
+  loginjector grep  [flags] PATTERN DIR PREFIX [PREFIX...]
+  loginjector stats [flags] DIR PREFIX [PREFIX...]
+
+Run "loginjector <command> -h" for a command's flags.
+`
+
+// errNoMatch reports that grep printed nothing; it maps to exit status 1.
+var errNoMatch = errors.New("no lines matched")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run executes the command line args and returns the exit status. tail -f stops when ctx
+// is done.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		_, _ = fmt.Fprint(stderr, usage)
+		return 2
+	}
+	var cmd func(context.Context, []string, io.Writer, io.Writer) error
+	switch args[0] {
+	case "cat":
+		cmd = runCat
+	case "tail":
+		cmd = runTail
+	case "grep":
+		cmd = runGrep
+	case "stats":
+		cmd = runStats
+	case "help", "-h", "-help", "--help":
+		_, _ = fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		_, _ = fmt.Fprintf(stderr, "loginjector: unknown command %q\n%s", args[0], usage)
+		return 2
+	}
+
+	err := cmd(ctx, args[1:], stdout, stderr)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errNoMatch):
+		return 1
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	default:
+		_, _ = fmt.Fprintf(stderr, "loginjector %s: %v\n", args[0], err)
+		return 2
+	}
+}
+
+// common holds the flags every subcommand shares.
+type common struct {
+	since, until string
+	level        string
+	layout       string
+	utc          bool
+	hostname     bool
+	pid          bool
+	keyFile      string
+}
+
+// newFlagSet returns the flag set for subcommand name with the shared flags registered;
+// level adds -level. Its errors are returned, not printed and exited on.
+func newFlagSet(name string, stderr io.Writer, c *common, level bool) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&c.since, "since", "", "only messages stamped at or after this time")
+	fs.StringVar(&c.until, "until", "", "only messages stamped before this time")
+	if level {
+		fs.StringVar(&c.level, "level", "", "lowest level to print: debug, info, warning, error, severe or critical")
+	}
+	fs.StringVar(&c.layout, "layout", "2006/01/02 15:04:05", "timestamp layout of the lines")
+	fs.BoolVar(&c.utc, "utc", false, "the lines are stamped in UTC rather than local time")
+	fs.BoolVar(&c.hostname, "hostname", false, "timestamped backup names carry this host's name")
+	fs.BoolVar(&c.pid, "pid", false, "timestamped backup names carry a process ID")
+	fs.StringVar(&c.keyFile, "key-file", "", "file holding the hex WithEncryption key; defaults to $"+keyEnv)
+	return fs
+}
+
+// options turns the shared flags into logread options.
+func (c *common) options(now time.Time) ([]logread.Option, error) {
+	loc := time.Local
+	if c.utc {
+		loc = time.UTC
+	}
+	opts := []logread.Option{logread.WithTimeLayout(c.layout, loc)}
+
+	from, err := parseTime(c.since, now, loc)
+	if err != nil {
+		return nil, fmt.Errorf("-since: %w", err)
+	}
+	to, err := parseTime(c.until, now, loc)
+	if err != nil {
+		return nil, fmt.Errorf("-until: %w", err)
+	}
+	if !from.IsZero() || !to.IsZero() {
+		opts = append(opts, logread.WithTimeRange(from, to))
+	}
+
+	if c.level != "" {
+		l, err := parseLevel(c.level)
+		if err != nil {
+			return nil, fmt.Errorf("-level: %w", err)
+		}
+		opts = append(opts, logread.WithLevel(l, logread.LevelWord))
+	}
+
+	if c.hostname || c.pid {
+		n := loginjector.TimestampBackups()
+		if c.hostname {
+			n = n.WithHostname()
+		}
+		if c.pid {
+			n = n.WithPID()
+		}
+		opts = append(opts, logread.WithBackupNaming(n))
+	}
+
+	key, err := c.key()
+	if err != nil {
+		return nil, fmt.Errorf("key: %w", err)
+	}
+	if key != nil {
+		opts = append(opts, logread.WithKey(key))
+	}
+	return opts, nil
+}
+
+// keyEnv names the environment variable holding the hex key when -key-file is not given.
+const keyEnv = "LOGINJECTOR_KEY"
+
+// key returns the decryption key from -key-file or keyEnv, or nil when neither is set.
+func (c *common) key() ([]byte, error) {
+	s := os.Getenv(keyEnv)
+	if c.keyFile != "" {
+		b, err := os.ReadFile(c.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		s = string(b)
+	}
+	if s = strings.TrimSpace(s); s == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("the key is not hex")
+	}
+	return key, nil
+}
+
+// parseTime reads a -since or -until value: an RFC 3339 time, a time in loc in one of the
+// shorter layouts, or a duration counted back from now. An empty s is the zero time.
+func parseTime(s string, now time.Time, loc *time.Location) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02", "2006/01/02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: want RFC 3339, 2006-01-02[ 15:04[:05]] or a duration such as 2h", s)
+}
+
+// parseLevel reads a level name from the levels package, rejecting names it does not
+// know instead of falling back to Info as levels.Parse does.
+func parseLevel(s string) (loginjector.LogLevel, error) {
+	l := levels.Parse(s)
+	if l == levels.Info && !strings.EqualFold(strings.TrimSpace(s), "info") {
+		return 0, fmt.Errorf("unknown level %q", s)
+	}
+	return l, nil
+}
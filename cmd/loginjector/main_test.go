@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prorochestvo/loginjector"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runCapture runs the command line and returns its exit status, stdout and stderr.
+func runCapture(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// writeSet writes the files of a set; names are relative to dir.
+func writeSet(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(body), 0o640))
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe to write from run while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	code, _, stderr := runCapture(t)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "usage:")
+
+	code, _, stderr = runCapture(t, "cut", ".", "app")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown command "cut"`)
+
+	code, stdout, _ := runCapture(t, "help")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "loginjector grep")
+
+	code, _, stderr = runCapture(t, "cat", t.TempDir())
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "want DIR PREFIX")
+
+	code, _, stderr = runCapture(t, "grep", "-level", "loud", "x", t.TempDir(), "app")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown level "loud"`)
+}
+
+func TestCat(t *testing.T) {
+	t.Parallel()
+
+	t.Run("a compressed set in write order", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := loginjector.RotatingFileHandler(dir, "app", loginjector.WithMaxFileSize(5), loginjector.WithCompress())
+		for _, m := range []string{"one", "two-2", "three"} {
+			_, err := h.Write([]byte(m))
+			require.NoError(t, err)
+		}
+
+		code, stdout, stderr := runCapture(t, "cat", dir, "app")
+		require.Equal(t, 0, code, stderr)
+		assert.Equal(t, "one\ntwo-2\nthree\n", stdout)
+	})
+
+	t.Run("an encrypted set with -key-file", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		key := bytes.Repeat([]byte{0x42}, 32)
+		h := loginjector.RotatingFileHandler(dir, "app", loginjector.WithMaxFileSize(40), loginjector.WithCompress(), loginjector.WithEncryption(key))
+		for _, m := range []string{"one", "two", "three"} {
+			_, err := h.Write([]byte(m))
+			require.NoError(t, err)
+		}
+		keyFile := filepath.Join(t.TempDir(), "key")
+		require.NoError(t, os.WriteFile(keyFile, []byte(hex.EncodeToString(key)+"\n"), 0o600))
+
+		code, stdout, stderr := runCapture(t, "cat", "-key-file", keyFile, dir, "app")
+		require.Equal(t, 0, code, stderr)
+		assert.Equal(t, "one\ntwo\nthree\n", stdout)
+
+		require.NoError(t, os.WriteFile(keyFile, []byte("not hex"), 0o600))
+		code, _, stderr = runCapture(t, "cat", "-key-file", keyFile, dir, "app")
+		assert.Equal(t, 2, code)
+		assert.Contains(t, stderr, "not hex")
+	})
+
+	t.Run("several sets merge by stamp", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		writeSet(t, dir, map[string]string{
+			"app.00000001.log": "2026/10/18 14:00:00 first\n2026/10/18 14:02:00 third\n                    trace\n",
+			"db.00000001.log":  "2026/10/18 14:01:00 second\n2026/10/18 14:02:00 same second\n",
+		})
+
+		code, stdout, stderr := runCapture(t, "cat", dir, "app", "db")
+		require.Equal(t, 0, code, stderr)
+		assert.Equal(t, strings.Join([]string{
+			"app: 2026/10/18 14:00:00 first",
+			"db: 2026/10/18 14:01:00 second",
+			"db: 2026/10/18 14:02:00 same second",
+			"app: 2026/10/18 14:02:00 third",
+			"app:                     trace",
+		}, "\n")+"\n", stdout, "a message and its continuation stay together")
+	})
+}
+
+func TestGrep(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeSet(t, dir, map[string]string{
+		"app.00000001.log": "2026/10/18 14:00:00 info user alice signed in\n2026/10/18 14:05:00 ERROR payment failed\n                    card declined\n",
+		"app.00000002.log": "2026/10/18 15:00:00 warning payment slow\n",
+	})
+
+	code, stdout, stderr := runCapture(t, "grep", "payment", dir, "app")
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, "2026/10/18 14:05:00 ERROR payment failed\n2026/10/18 15:00:00 warning payment slow\n", stdout)
+
+	code, stdout, stderr = runCapture(t, "grep", "-level", "error", "", dir, "app")
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, "2026/10/18 14:05:00 ERROR payment failed\n                    card declined\n", stdout)
+
+	code, stdout, stderr = runCapture(t, "grep", "-since", "2026-10-18 14:30", "-until", "2026-10-18 16:00", "payment", dir, "app")
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, "2026/10/18 15:00:00 warning payment slow\n", stdout)
+
+	code, stdout, _ = runCapture(t, "grep", "refund", dir, "app")
+	assert.Equal(t, 1, code, "no match")
+	assert.Empty(t, stdout)
+
+	code, _, stderr = runCapture(t, "grep", "(", dir, "app")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "loginjector grep:")
+}
+
+func TestTail(t *testing.T) {
+	t.Parallel()
+
+	t.Run("last lines of the set", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		writeSet(t, dir, map[string]string{
+			"app.00000001.log": "a\nb\n",
+			"app.00000002.log": "c\n",
+		})
+
+		code, stdout, stderr := runCapture(t, "tail", "-n", "2", dir, "app")
+		require.Equal(t, 0, code, stderr)
+		assert.Equal(t, "b\nc\n", stdout)
+
+		code, stdout, _ = runCapture(t, "tail", "-n", "0", dir, "app")
+		assert.Equal(t, 0, code)
+		assert.Empty(t, stdout)
+	})
+
+	t.Run("only the backups the last lines reach are read", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		writeSet(t, dir, map[string]string{
+			"app.00000001.log.gz": "not gzip",
+			"app.00000002.log":    "a\nb\n",
+			"app.00000003.log":    "c\n",
+		})
+
+		code, stdout, stderr := runCapture(t, "tail", "-n", "3", dir, "app")
+		require.Equal(t, 0, code, stderr)
+		assert.Equal(t, "a\nb\nc\n", stdout)
+
+		code, _, stderr = runCapture(t, "tail", "-n", "4", dir, "app")
+		assert.Equal(t, 2, code, "the unreadable backup is reached")
+		assert.Contains(t, stderr, "loginjector tail:")
+	})
+
+	t.Run("-f follows across rotations", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := loginjector.RotatingFileHandler(dir, "app", loginjector.WithMaxFileSize(5), loginjector.WithCompress())
+		_, err := h.Write([]byte("before"))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var stdout syncBuffer
+		done := make(chan int)
+		go func() { done <- run(ctx, []string{"tail", "-f", "-n", "1", dir, "app"}, &stdout, &stdout) }()
+
+		require.Eventually(t, func() bool { return stdout.String() == "before\n" }, 5*time.Second, 5*time.Millisecond)
+		for _, m := range []string{"after-1", "after-2"} {
+			_, err := h.Write([]byte(m))
+			require.NoError(t, err)
+		}
+		require.Eventually(t, func() bool { return stdout.String() == "before\nafter-1\nafter-2\n" }, 5*time.Second, 5*time.Millisecond)
+		cancel()
+		assert.Equal(t, 0, <-done, "an interrupt is a clean stop")
+	})
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeSet(t, dir, map[string]string{
+		"app.00000001.log": "2026/10/18 14:01:00 info started\n2026/10/18 14:02:00 ERROR boom\n                    at main.go:1\n",
+		"app.00000002.log": "2026/10/18 15:00:00 warn slow\n2026/10/18 15:10:00 cache warmed\n",
+	})
+
+	code, stdout, stderr := runCapture(t, "stats", dir, "app")
+	require.Equal(t, 0, code, stderr)
+	lines := strings.Split(strings.TrimRight(stdout, "\n"), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"hour", "debug", "info", "warning", "error", "severe", "critical", "other"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"2026-10-18", "14:00", "0", "1", "0", "2", "0", "0", "0"}, strings.Fields(lines[1]), "a continuation line counts at its message's level")
+	assert.Equal(t, []string{"2026-10-18", "15:00", "0", "0", "1", "0", "0", "0", "1"}, strings.Fields(lines[2]))
+}
+
+func TestParseTime(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		in   string
+		want time.Time
+	}{
+		{"", time.Time{}},
+		{"2h", now.Add(-2 * time.Hour)},
+		{"2026-10-18T10:00:00Z", time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)},
+		{"2026-10-18 10:30", time.Date(2026, 10, 18, 10, 30, 0, 0, time.UTC)},
+		{"2026-10-17", time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		got, err := parseTime(c.in, now, time.UTC)
+		require.NoError(t, err, c.in)
+		assert.True(t, c.want.Equal(got), "%q: got %v, want %v", c.in, got, c.want)
+	}
+
+	_, err := parseTime("yesterday", now, time.UTC)
+	assert.Error(t, err)
+}
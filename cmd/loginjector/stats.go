@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prorochestvo/loginjector"
+	"github.com/prorochestvo/loginjector/levels"
+	"github.com/prorochestvo/loginjector/logread"
+)
+
+// statsLevels are the stats columns, in order; lines whose message names no level are
+// counted under "other".
+var statsLevels = []loginjector.LogLevel{levels.Debug, levels.Info, levels.Warning, levels.Error, levels.Severe, levels.Critical}
+
+// runStats prints a table of line counts per level for every hour that has lines. Lines
+// no stamp precedes belong to no hour and are not counted.
+func runStats(_ context.Context, args []string, stdout, stderr io.Writer) error {
+	var c common
+	fs := newFlagSet("stats", stderr, &c, false)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return errors.New("want DIR PREFIX [PREFIX...]")
+	}
+	opts, err := c.options(time.Now())
+	if err != nil {
+		return err
+	}
+	opts = append(opts, logread.WithClassifier(logread.LevelWord))
+
+	// counts[hour][i] is the count for statsLevels[i], with "other" last.
+	counts := make(map[time.Time][]int)
+	err = mergeSets(fs.Arg(0), fs.Args()[1:], opts, func(_ string, l logread.Line) error {
+		if l.Time.IsZero() {
+			return nil
+		}
+		t := l.Time
+		hour := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+		row := counts[hour]
+		if row == nil {
+			row = make([]int, len(statsLevels)+1)
+			counts[hour] = row
+		}
+		col := len(statsLevels)
+		for i, lv := range statsLevels {
+			if l.Level == lv {
+				col = i
+			}
+		}
+		row[col]++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	hours := make([]time.Time, 0, len(counts))
+	for h := range counts {
+		hours = append(hours, h)
+	}
+	sort.Slice(hours, func(i, j int) bool { return hours[i].Before(hours[j]) })
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprint(tw, "hour\t")
+	for _, lv := range statsLevels {
+		_, _ = fmt.Fprintf(tw, "%s\t", levels.Name(lv))
+	}
+	_, _ = fmt.Fprint(tw, "other\t\n")
+	for _, h := range hours {
+		_, _ = fmt.Fprintf(tw, "%s\t", h.Format("2006-01-02 15:04"))
+		for _, n := range counts[h] {
+			_, _ = fmt.Fprintf(tw, "%d\t", n)
+		}
+		_, _ = fmt.Fprint(tw, "\n")
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,58 @@
+package loginjector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WithCurrentSymlink keeps a symlink named name in folder pointing at the live file, so
+// tooling can follow the default index-named scheme at a stable path (tail -F name)
+// while backups stay immutable and are never renamed. The link is replaced atomically —
+// a new link is created under a temp name and renamed over the old one — so a reader
+// never finds it missing. It is set at construction, including on resume, retargeted on
+// every rotation, and checked again on each Write, which also follows a peer's rotation
+// under WithProcessLock. It may dangle briefly: after a rotation it names the next
+// index before the first write creates that file.
+//
+// The link target is the live file's base name, so the folder can be moved or mounted
+// elsewhere without breaking it. name must be a plain file name that is not part of the
+// rotating set — not prefix.log, a backup name, or the WithProcessLock lock file — and it
+// is owned by the handler: an existing file of that name is replaced. An invalid name
+// is reported on the first Write and no link is kept. Symlinks need Unix, or a Windows
+// account allowed to create them; a failure is reported on the Write's return value.
+func WithCurrentSymlink(name string) RotatingFileOption {
+	return func(c *rotatingFileConfig) { c.symlink = name }
+}
+
+// checkSymlinkName rejects a WithCurrentSymlink name that would escape folder or collide
+// with a file the rotating set itself manages.
+func checkSymlinkName(name, prefix string, naming BackupNaming) error {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return fmt.Errorf("loginjector: current symlink name %q must be a plain file name", name)
+	}
+	if _, _, ok := naming.parseBackup(name, prefix); ok {
+		return fmt.Errorf("loginjector: current symlink name %q collides with a backup of %q", name, prefix)
+	}
+	if _, _, ok := parseRotationIndex(name, prefix); ok || name == stableLiveName(prefix) || name == lockFileName(prefix) {
+		return fmt.Errorf("loginjector: current symlink name %q collides with a file of %q", name, prefix)
+	}
+	return nil
+}
+
+// replaceSymlink atomically points folder/name at target: the link is created as a
+// hidden temp and renamed over any existing entry, which rename(2) replaces in one step.
+func replaceSymlink(folder, name, target string) error {
+	tmp := filepath.Join(folder, "."+name+"."+tmpExtension)
+	if err := removeIfExists(tmp); err != nil {
+		return err
+	}
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(folder, name)); err != nil {
+		return errors.Join(err, removeIfExists(tmp))
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+//go:build unix
+
+package loginjector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readlinkOrFail returns the target of the symlink at path.
+func readlinkOrFail(t *testing.T, path string) string {
+	t.Helper()
+	target, err := os.Readlink(path)
+	require.NoError(t, err)
+	return target
+}
+
+func TestWithCurrentSymlink(t *testing.T) {
+	t.Run("the link follows every rotation", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		link := filepath.Join(dir, "app.current")
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(5), WithMaxFiles(50), WithCurrentSymlink("app.current"))
+		require.Equal(t, idxName("app", 1), readlinkOrFail(t, link), "the link is set at construction")
+
+		writeRotating(t, h, "abc")
+		b, err := os.ReadFile(link)
+		require.NoError(t, err)
+		require.Equal(t, "abc\n", string(b))
+
+		writeRotating(t, h, "defg") // 9 > 5 -> rotate to index 2
+		require.Equal(t, idxName("app", 2), readlinkOrFail(t, link), "relative target, retargeted on rotation")
+		require.NoFileExists(t, filepath.Join(dir, ".app.current."+tmpExtension))
+
+		writeRotating(t, h, "z")
+		b, err = os.ReadFile(link)
+		require.NoError(t, err)
+		require.Equal(t, "z\n", string(b))
+	})
+
+	t.Run("resume points the link at the resumed file", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, idxName("app", 3)), []byte("old\n"), defaultFilePermissions))
+		require.NoError(t, os.Symlink(idxName("app", 1), filepath.Join(dir, "current"))) // stale link from a prior run
+
+		RotatingFileHandler(dir, "app", WithCurrentSymlink("current"))
+		require.Equal(t, idxName("app", 3), readlinkOrFail(t, filepath.Join(dir, "current")))
+	})
+
+	t.Run("pruning never removes the link", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(5), WithMaxFiles(2), WithCurrentSymlink("current.log"))
+		for i := 0; i < 5; i++ {
+			writeRotating(t, h, "abcde")
+		}
+
+		require.Equal(t, idxName("app", 6), readlinkOrFail(t, filepath.Join(dir, "current.log")))
+		require.FileExists(t, filepath.Join(dir, idxName("app", 5)))
+		require.NoFileExists(t, filepath.Join(dir, idxName("app", 4)))
+	})
+
+	t.Run("a missing folder is linked on the first Write", func(t *testing.T) {
+		t.Parallel()
+
+		dir := filepath.Join(t.TempDir(), "later")
+		h := RotatingFileHandler(dir, "app", WithCurrentSymlink("current"))
+		require.NoError(t, os.Mkdir(dir, 0o750))
+
+		writeRotating(t, h, "hello")
+		require.Equal(t, idxName("app", 1), readlinkOrFail(t, filepath.Join(dir, "current")))
+	})
+
+	t.Run("a name inside the rotating set is rejected", func(t *testing.T) {
+		t.Parallel()
+
+		for _, name := range []string{"..", "sub/current", "app.log", idxName("app", 2), "app.lock"} {
+			dir := t.TempDir()
+			h := RotatingFileHandler(dir, "app", WithCurrentSymlink(name))
+
+			_, err := h.Write([]byte("hello"))
+			require.ErrorContains(t, err, "current symlink name", name)
+			_, err = h.Write([]byte("again"))
+			require.NoError(t, err, "reported once; the handler keeps logging without a link")
+			files, err := extractFilesOrFail(dir)
+			require.NoError(t, err)
+			require.Equal(t, "hello\nagain\n", files[idxName("app", 1)])
+		}
+	})
+}
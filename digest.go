@@ -0,0 +1,228 @@
+package loginjector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DigestOption configures Digest.
+type DigestOption func(*digestConfig)
+
+type digestConfig struct {
+	noun         string
+	flushLevel   LogLevel
+	flushLevelOn bool
+	onError      func(error)
+	clock        func() time.Time                              // withDigestClock: test seam.
+	afterFunc    func(d time.Duration, f func()) (stop func()) // withDigestTimer: test seam.
+}
+
+// WithDigestNoun sets what the header counts, e.g. "errors" for "17 errors in the last
+// 60s". The default is "messages".
+func WithDigestNoun(noun string) DigestOption {
+	return func(c *digestConfig) { c.noun = noun }
+}
+
+// WithDigestFlushLevel delivers the digest at once, without waiting for the window to
+// end, when a message at or above level arrives; the message is part of it.
+func WithDigestFlushLevel(level LogLevel) DigestOption {
+	return func(c *digestConfig) {
+		c.flushLevel = level
+		c.flushLevelOn = true
+	}
+}
+
+// WithDigestOnError registers fn to be called with the error of a digest delivered in
+// the background, when its window ends. fn runs on the timer's goroutine. Without it
+// those errors are kept and returned by Close.
+func WithDigestOnError(fn func(error)) DigestOption {
+	return func(c *digestConfig) { c.onError = fn }
+}
+
+// withDigestClock replaces the clock the digest's span is measured with.
+func withDigestClock(fn func() time.Time) DigestOption {
+	return func(c *digestConfig) { c.clock = fn }
+}
+
+// withDigestTimer replaces the timer that ends a window.
+func withDigestTimer(fn func(d time.Duration, f func()) (stop func())) DigestOption {
+	return func(c *digestConfig) { c.afterFunc = fn }
+}
+
+// digestGroup is the identical messages of a digest.
+type digestGroup struct {
+	text  string
+	count int
+}
+
+// Digest collects the messages written to it and delivers them to inner as one message,
+// so an alert sink such as a TelegramHandler hook gets one notification per burst rather
+// than one per line. The first message opens a window; when it ends — or earlier, once
+// maxItems messages are collected, a message reaches WithDigestFlushLevel, or Close is
+// called — inner receives a header like "17 errors in the last 60s" followed by the
+// messages in arrival order, identical ones given once with their count ("(×5)"). The
+// digest carries the highest level of its messages to an inner LevelWriter.
+//
+// Messages are compared whole, so put Digest in front of any handler that adds a
+// timestamp. A window's digest is delivered in the background; its error goes to
+// WithDigestOnError, or else is returned by Close, never by a later Write. A Write that
+// completes a digest early returns that digest's error. Close delivers what is collected
+// and closes inner when this package built it. A maxItems of zero or less means no bound.
+func Digest(inner io.Writer, window time.Duration, maxItems int, opts ...DigestOption) io.Writer {
+	cfg := digestConfig{noun: "messages", clock: time.Now, afterFunc: afterFunc}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	d := &digest{inner: inner, window: window, maxItems: maxItems, cfg: cfg, index: map[string]int{}}
+
+	return &writer{
+		h:  func(msg []byte) (int, error) { return d.add(msg, 0, false) },
+		hl: func(level LogLevel, msg []byte) (int, error) { return d.add(msg, level, true) },
+		closer: func() error {
+			d.mu.Lock()
+			d.endWindow()
+			d.mu.Unlock()
+			err := d.flush()
+			d.mu.Lock()
+			err = errors.Join(d.err, err)
+			d.err = nil
+			d.mu.Unlock()
+			return errors.Join(err, closeHandler(inner))
+		},
+	}
+}
+
+// digest is the state of a Digest.
+type digest struct {
+	inner    io.Writer
+	window   time.Duration
+	maxItems int
+	cfg      digestConfig
+
+	mu       sync.Mutex
+	groups   []*digestGroup
+	index    map[string]int // message text -> position in groups.
+	count    int
+	first    time.Time
+	level    LogLevel
+	hasLevel bool
+	stop     func() // cancels the window's timer; nil when none runs.
+	gen      uint64 // counts windows, so a timer firing late ignores a newer one.
+	err      error  // the errors of background deliveries, for Close.
+
+	deliverMu sync.Mutex // keeps deliveries in order.
+}
+
+// add collects msg and delivers the digest when it is due.
+func (d *digest) add(msg []byte, level LogLevel, hasLevel bool) (int, error) {
+	d.mu.Lock()
+	text := strings.TrimRight(string(msg), "\n")
+	if i, ok := d.index[text]; ok {
+		d.groups[i].count++
+	} else {
+		d.index[text] = len(d.groups)
+		d.groups = append(d.groups, &digestGroup{text: text, count: 1})
+	}
+	if d.count == 0 {
+		d.first = d.cfg.clock()
+		gen := d.gen
+		d.stop = d.cfg.afterFunc(d.window, func() {
+			d.mu.Lock()
+			if d.gen != gen {
+				d.mu.Unlock()
+				return // the window ended already.
+			}
+			d.stop = nil
+			d.gen++
+			d.mu.Unlock()
+			d.report(d.flush())
+		})
+	}
+	d.count++
+	if hasLevel && (!d.hasLevel || level > d.level) {
+		d.level, d.hasLevel = level, true
+	}
+	due := (d.maxItems > 0 && d.count >= d.maxItems) || (hasLevel && d.cfg.flushLevelOn && level >= d.cfg.flushLevel)
+	if due {
+		d.endWindow()
+	}
+	d.mu.Unlock()
+
+	if due {
+		if err := d.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(msg), nil
+}
+
+// endWindow stops the window's timer; d.mu must be held.
+func (d *digest) endWindow() {
+	if d.stop != nil {
+		d.stop()
+		d.stop = nil
+	}
+	d.gen++
+}
+
+// report hands the error of a background delivery to WithDigestOnError, or keeps it
+// for Close.
+func (d *digest) report(err error) {
+	if err == nil {
+		return
+	}
+	if d.cfg.onError != nil {
+		d.cfg.onError(err)
+		return
+	}
+	d.mu.Lock()
+	d.err = errors.Join(d.err, err)
+	d.mu.Unlock()
+}
+
+// flush delivers the collected messages, if any, as one.
+func (d *digest) flush() error {
+	d.deliverMu.Lock()
+	defer d.deliverMu.Unlock()
+
+	d.mu.Lock()
+	if d.count == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d %s in the last %s\n", d.count, d.cfg.noun, digestSpan(d.cfg.clock().Sub(d.first)))
+	for _, g := range d.groups {
+		b.WriteString(g.text)
+		if g.count > 1 {
+			fmt.Fprintf(&b, " (×%d)", g.count)
+		}
+		b.WriteByte('\n')
+	}
+	level, hasLevel := d.level, d.hasLevel
+	d.groups, d.index, d.count, d.hasLevel = nil, map[string]int{}, 0, false
+	d.mu.Unlock()
+
+	var err error
+	if hasLevel {
+		_, err = writeLevel(d.inner, level, b.Bytes())
+	} else {
+		_, err = d.inner.Write(b.Bytes())
+	}
+	return err
+}
+
+// digestSpan renders the time a digest covers in whole seconds, at least one, or in
+// whole minutes from ten minutes on.
+func digestSpan(d time.Duration) string {
+	s := max(int((d+time.Second-1)/time.Second), 1)
+	if s >= 600 && s%60 == 0 {
+		return fmt.Sprintf("%dm", s/60)
+	}
+	return fmt.Sprintf("%ds", s)
+}
@@ -0,0 +1,159 @@
+package loginjector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigest(t *testing.T) {
+	t.Parallel()
+
+	t.Run("a window is delivered as one message with identical lines counted", func(t *testing.T) {
+		t.Parallel()
+
+		inner := &gatedWriter{}
+		now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+		var timer manualTimer
+		h := Digest(inner, time.Minute, 0, WithDigestNoun("errors"),
+			withDigestClock(func() time.Time { return now }), withDigestTimer(timer.afterFunc))
+
+		l := NewLogger(1, h)
+		l.Printf(4, "db timeout")
+		l.Printf(3, "slow query")
+		l.Printf(4, "db timeout")
+		l.Printf(4, "db timeout")
+		assert.Empty(t, inner.received())
+		assert.Equal(t, []time.Duration{time.Minute}, timer.delays, "one window for the burst")
+
+		now = now.Add(time.Minute)
+		timer.fire(t)
+		assert.Equal(t, []string{"4 errors in the last 60s\ndb timeout (×3)\nslow query\n"}, inner.received())
+		assert.Equal(t, []LogLevel{4}, inner.levels, "the highest level of the window")
+
+		l.Printf(3, "next")
+		assert.Len(t, timer.delays, 2, "the next message opens a new window")
+	})
+
+	t.Run("maxItems delivers the digest early", func(t *testing.T) {
+		t.Parallel()
+
+		inner := &gatedWriter{}
+		now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+		var timer manualTimer
+		h := Digest(inner, time.Minute, 2, withDigestClock(func() time.Time { return now }), withDigestTimer(timer.afterFunc))
+
+		writeRotating(t, h, "a\n")
+		now = now.Add(1500 * time.Millisecond)
+		writeRotating(t, h, "b\n")
+		assert.Equal(t, []string{"2 messages in the last 2s\na\nb\n"}, inner.received())
+		assert.Nil(t, timer.fn, "the window's timer is stopped")
+	})
+
+	t.Run("a message at the flush level delivers the digest at once", func(t *testing.T) {
+		t.Parallel()
+
+		inner := &gatedWriter{}
+		var timer manualTimer
+		l := NewLogger(1, Digest(inner, time.Minute, 0, WithDigestFlushLevel(5), withDigestTimer(timer.afterFunc)))
+		l.Printf(3, "warning")
+		assert.Empty(t, inner.received())
+		l.Printf(5, "critical")
+		require.Len(t, inner.received(), 1)
+		assert.Contains(t, inner.received()[0], "warning\ncritical\n")
+		assert.Equal(t, []LogLevel{5}, inner.levels)
+	})
+
+	t.Run("Close delivers what is collected", func(t *testing.T) {
+		t.Parallel()
+
+		inner := &gatedWriter{}
+		var timer manualTimer
+		h := Digest(inner, time.Hour, 0, withDigestTimer(timer.afterFunc))
+		writeRotating(t, h, "pending\n")
+
+		require.NoError(t, closeHandler(h))
+		require.Len(t, inner.received(), 1)
+		assert.Contains(t, inner.received()[0], "1 messages in the last")
+		assert.Nil(t, timer.fn)
+	})
+
+	t.Run("a failed background delivery is returned by Close, not the next Write", func(t *testing.T) {
+		t.Parallel()
+
+		inner := &gatedWriter{}
+		var timer manualTimer
+		h := Digest(inner, time.Minute, 0, withDigestTimer(timer.afterFunc))
+		writeRotating(t, h, "lost\n")
+
+		inner.setDown(true)
+		timer.fire(t)
+		inner.setDown(false)
+		n, err := h.Write([]byte("next\n"))
+		require.NoError(t, err, "the message is collected: failing it would duplicate it behind a Retry")
+		assert.Equal(t, 5, n)
+		require.ErrorContains(t, closeHandler(h), "api unreachable")
+		assert.Equal(t, []string{"1 messages in the last 1s\nnext\n"}, inner.received())
+	})
+
+	t.Run("WithDigestOnError receives a failed background delivery", func(t *testing.T) {
+		t.Parallel()
+
+		inner := &gatedWriter{}
+		var timer manualTimer
+		var got []error
+		h := Digest(inner, time.Minute, 0, withDigestTimer(timer.afterFunc),
+			WithDigestOnError(func(err error) { got = append(got, err) }))
+		writeRotating(t, h, "lost\n")
+
+		inner.setDown(true)
+		timer.fire(t)
+		inner.setDown(false)
+		require.Len(t, got, 1)
+		assert.ErrorContains(t, got[0], "api unreachable")
+		require.NoError(t, closeHandler(h), "the error was reported already")
+	})
+
+	t.Run("a timer firing after its window ended leaves the next window alone", func(t *testing.T) {
+		t.Parallel()
+
+		inner := &gatedWriter{}
+		var timer manualTimer
+		h := Digest(inner, time.Minute, 2, withDigestTimer(timer.afterFunc))
+		writeRotating(t, h, "a\n")
+		stale := timer.fn // fires while the digest it ends is delivered early.
+		writeRotating(t, h, "b\n")
+		require.Len(t, inner.received(), 1)
+
+		writeRotating(t, h, "c\n")
+		require.NotNil(t, timer.fn, "the next window's timer")
+		stale()
+		assert.Len(t, inner.received(), 1, "the next window is not delivered early")
+		writeRotating(t, h, "d\n")
+		assert.Nil(t, timer.fn, "the next window's timer is still stopped")
+		assert.Equal(t, "2 messages in the last 1s\nc\nd\n", inner.received()[1])
+	})
+
+	t.Run("with real timers", func(t *testing.T) {
+		t.Parallel()
+
+		inner := &gatedWriter{}
+		h := Digest(inner, 20*time.Millisecond, 0)
+		writeRotating(t, h, "tick\n")
+		writeRotating(t, h, "tick\n")
+		require.Eventually(t, func() bool { return len(inner.received()) == 1 }, 2*time.Second, 5*time.Millisecond)
+		assert.Equal(t, "2 messages in the last 1s\ntick (×2)\n", inner.received()[0])
+		require.NoError(t, closeHandler(h))
+	})
+}
+
+func TestDigestSpan(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "1s", digestSpan(0))
+	assert.Equal(t, "60s", digestSpan(time.Minute))
+	assert.Equal(t, "90s", digestSpan(89*time.Second+time.Millisecond))
+	assert.Equal(t, "15m", digestSpan(15*time.Minute))
+}
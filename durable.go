@@ -0,0 +1,77 @@
+package loginjector
+
+import "os"
+
+// WithDurableWrites makes the file handler fsync the file before every Write returns, so
+// a message a caller has logged survives a power cut. The folder is fsynced too whenever a
+// Write creates a file, and after every rename and compression a rotation makes, so the
+// new names are as durable as the data. Each Write then costs at least one disk flush:
+// keep it for the messages that need it, or use WithDurableMinLevel.
+//
+// It applies to RotatingFileHandler and FileByFormatHandlerWithOptions. Under
+// WithAsyncCompress the compressed backup is made durable by the background goroutine,
+// after the Write that rotated has returned; the plaintext backup it replaces already is.
+func WithDurableWrites() RotatingFileOption {
+	return func(c *rotatingFileConfig) { c.durable = true }
+}
+
+// WithDurableMinLevel is WithDurableWrites for messages at or above level only, so regular
+// traffic stays fast. The level is known when the message comes through a Logger, which
+// hands it to handlers implementing LevelWriter — the handler itself, or one of this
+// package's wrappers around it such as TimestampedHandler; a plain Write is not fsynced.
+// Renames and compressions are always made durable under this option.
+func WithDurableMinLevel(level LogLevel) RotatingFileOption {
+	return func(c *rotatingFileConfig) {
+		c.durableMin = level
+		c.durableMinSet = true
+	}
+}
+
+// durableFor reports whether a message at level must be fsynced before its Write returns.
+func (c rotatingFileConfig) durableFor(level LogLevel) bool {
+	return c.durable || (c.durableMinSet && level >= c.durableMin)
+}
+
+// anyDurable reports whether either durability option is set, which makes the folder's
+// renames durable regardless of the message that caused them.
+func (c rotatingFileConfig) anyDurable() bool {
+	return c.durable || c.durableMinSet
+}
+
+// withSyncObserver calls fn with the path of every file and folder the handler fsyncs;
+// tests use it to see durability that a file system does not show.
+func withSyncObserver(fn func(path string)) RotatingFileOption {
+	return func(c *rotatingFileConfig) { c.onSync = fn }
+}
+
+// fsync fsyncs f.
+func (c rotatingFileConfig) fsync(f *os.File) error {
+	err := f.Sync()
+	if err == nil && c.onSync != nil {
+		c.onSync(f.Name())
+	}
+	return err
+}
+
+// fsyncFolder fsyncs the directory at folder.
+func (c rotatingFileConfig) fsyncFolder(folder string) error {
+	err := syncFolder(folder)
+	if err == nil && c.onSync != nil {
+		c.onSync(folder)
+	}
+	return err
+}
+
+// syncFolder fsyncs the directory at folder so the entries created, renamed or removed in
+// it survive a power cut. Platforms that cannot fsync a directory skip it.
+func syncFolder(folder string) error {
+	d, err := os.Open(folder)
+	if err != nil {
+		return err
+	}
+	err = syncDir(d)
+	if e := d.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package loginjector
+
+import "os"
+
+// syncDir does nothing: directories cannot be fsynced here, and entries are made durable
+// by the file system itself.
+func syncDir(*os.File) error {
+	return nil
+}
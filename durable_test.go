@@ -0,0 +1,123 @@
+package loginjector
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncLog records the paths withSyncObserver reports, by base name.
+type syncLog struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (s *syncLog) observe(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paths = append(s.paths, filepath.Base(path))
+}
+
+// take returns the paths recorded since the last take.
+func (s *syncLog) take() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.paths
+	s.paths = nil
+	return p
+}
+
+func TestWithDurableWrites(t *testing.T) {
+	t.Parallel()
+
+	t.Run("every write fsyncs the file, and the folder when it creates one", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		var synced syncLog
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(8), WithMaxFiles(50), WithDurableWrites(), withSyncObserver(synced.observe))
+
+		writeRotating(t, h, "one")
+		assert.Equal(t, []string{idxName("app", 1), filepath.Base(dir)}, synced.take())
+		writeRotating(t, h, "two-2") // 10 > 8 -> rotate
+		assert.Equal(t, []string{idxName("app", 1)}, synced.take())
+		writeRotating(t, h, "three")
+		assert.Equal(t, []string{idxName("app", 2), filepath.Base(dir)}, synced.take(), "the new file's entry is made durable")
+	})
+
+	t.Run("rotation fsyncs the folder after the rename and the compression", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		var synced syncLog
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(8), WithMaxFiles(50), WithStableCurrentName(), WithCompress(),
+			WithDurableWrites(), withSyncObserver(synced.observe))
+
+		writeRotating(t, h, "first")
+		synced.take()
+		writeRotating(t, h, "second")
+		assert.Equal(t, []string{"app.log", filepath.Base(dir), filepath.Base(dir)}, synced.take())
+		require.FileExists(t, filepath.Join(dir, idxName("app", 1)+"."+gzipExtension))
+	})
+
+	t.Run("without the option nothing is fsynced", func(t *testing.T) {
+		t.Parallel()
+
+		var synced syncLog
+		h := RotatingFileHandler(t.TempDir(), "app", WithMaxFileSize(5), WithMaxFiles(50), withSyncObserver(synced.observe))
+		writeRotating(t, h, "one")
+		writeRotating(t, h, "two")
+		assert.Empty(t, synced.take())
+	})
+
+	t.Run("FileByFormatHandlerWithOptions fsyncs too", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		var synced syncLog
+		h := FileByFormatHandlerWithOptions(dir, "app-*", func() string { return "app-1" }, WithDurableWrites(), withSyncObserver(synced.observe))
+		writeRotating(t, h, "one")
+		writeRotating(t, h, "two")
+		assert.Equal(t, []string{"app-1.log", filepath.Base(dir), "app-1.log"}, synced.take())
+	})
+}
+
+func TestWithDurableMinLevel(t *testing.T) {
+	t.Parallel()
+
+	t.Run("only messages at or above the level are fsynced", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		var synced syncLog
+		file := RotatingFileHandler(dir, "app", WithDurableMinLevel(4), withSyncObserver(synced.observe))
+		l := NewLogger(1, TimestampedHandler(file))
+
+		l.Printf(2, "info")
+		assert.Empty(t, synced.take(), "a lower level is not fsynced")
+		l.Printf(4, "payment captured")
+		assert.Equal(t, []string{idxName("app", 1), filepath.Base(dir)}, synced.take(), "the folder entry created by the earlier Write is synced too")
+		l.Printf(5, "ledger mismatch")
+		assert.Equal(t, []string{idxName("app", 1)}, synced.take())
+
+		_, err := file.Write([]byte("no level"))
+		require.NoError(t, err)
+		assert.Empty(t, synced.take(), "a plain Write carries no level")
+	})
+
+	t.Run("the level passes through WithMinLevel and AuditHandler", func(t *testing.T) {
+		t.Parallel()
+
+		var synced syncLog
+		file := RotatingFileHandler(t.TempDir(), "app", WithDurableMinLevel(4), withSyncObserver(synced.observe))
+		l := NewLogger(1, WithMinLevel(2, AuditHandler(file)))
+
+		l.Printf(3, "below")
+		assert.Empty(t, synced.take())
+		l.Printf(4, "at")
+		assert.NotEmpty(t, synced.take())
+	})
+}
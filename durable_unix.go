@@ -0,0 +1,10 @@
+//go:build unix
+
+package loginjector
+
+import "os"
+
+// syncDir fsyncs the open directory d.
+func syncDir(d *os.File) error {
+	return d.Sync()
+}
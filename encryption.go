@@ -0,0 +1,432 @@
+package loginjector
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// An encrypted log file is a header followed by self-contained records, one per Write (a
+// larger Write is split across several), so the file stays appendable and never has to be
+// rewritten:
+//
+//	header: magic "LJE1" | 16-byte random file ID
+//	record: sealed length, uint32 big-endian | 12-byte nonce | AES-GCM sealed data
+//
+// A record's additional authenticated data is the file ID, the record's index in the file
+// (uint64 big-endian, from 0) and its length, so a record cannot be altered, dropped,
+// reordered or moved to another file unnoticed; as for any appendable file, only whole
+// records cut off its end go unnoticed. Every record has its own random nonce.
+const (
+	recordMagic     = "LJE1"
+	recordIDSize    = 16
+	recordFileHead  = len(recordMagic) + recordIDSize
+	recordNonceSize = 12
+	recordHeaderLen = 4 + recordNonceSize
+	// recordChunk bounds the plaintext of one record, and with it the memory a reader
+	// needs; recordMaxSealed rejects a garbage length before anything is allocated.
+	recordChunk     = 64 << 10
+	recordMaxSealed = recordChunk + 16
+)
+
+// ErrDecrypt is returned by a decrypting reader when a file fails to decode anywhere
+// before a record torn at its end: the key is wrong, or the file was altered.
+var ErrDecrypt = errors.New("loginjector: log record failed authentication")
+
+// WithEncryption makes RotatingFileHandler encrypt everything it writes with AES-GCM under
+// key, which must be 16, 24 or 32 bytes long (AES-128, AES-192 or AES-256). Each Write is
+// appended as one or more records, each authenticated along with the file's random ID and
+// its position in the file, so the live file stays appendable while no record can be
+// dropped or moved unnoticed. A record torn by a crash is cut off before the handler
+// appends again, and readers stop before it. With WithCompress a backup is decrypted, gzipped and encrypted
+// again, so compression still sees the plaintext; the .log and .log.gz names do not
+// change. Read the files back with NewDecryptingReader, or logread.WithKey.
+//
+// With an invalid key every Write fails and nothing is written: the handler never falls
+// back to plaintext. A live file written before encryption was enabled is left as it is
+// and the handler starts a new one, so no file mixes plaintext and records. Rotated sizes
+// count the encrypted bytes: a 20-byte header per file, and about 32 bytes more per Write
+// than the message.
+func WithEncryption(key []byte) RotatingFileOption {
+	k := append([]byte(nil), key...)
+	return func(c *rotatingFileConfig) { c.key = k }
+}
+
+// newRecordCipher returns the AES-GCM cipher for key.
+func newRecordCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("loginjector: invalid encryption key: %w", err)
+	}
+	return cipher.NewGCMWithNonceSize(block, recordNonceSize)
+}
+
+// recordAAD returns the additional authenticated data of the record of file id at index,
+// sealed bytes long.
+func recordAAD(id []byte, index uint64, sealed int) []byte {
+	aad := make([]byte, 0, recordIDSize+8+4)
+	aad = append(aad, id...)
+	aad = binary.BigEndian.AppendUint64(aad, index)
+	return binary.BigEndian.AppendUint32(aad, uint32(sealed))
+}
+
+// recordSealer seals the records of one file: it knows the file's ID and how many records
+// the file holds.
+type recordSealer struct {
+	aead  cipher.AEAD
+	id    [recordIDSize]byte
+	count uint64
+}
+
+// start appends the header of a new file, with a fresh random ID, to dst.
+func (s *recordSealer) start(dst []byte) ([]byte, error) {
+	if _, err := rand.Read(s.id[:]); err != nil {
+		return dst, err
+	}
+	s.count = 0
+	return append(append(dst, recordMagic...), s.id[:]...), nil
+}
+
+// seal appends p to dst as the file's next records, of at most recordChunk plaintext
+// bytes each.
+func (s *recordSealer) seal(dst, p []byte) ([]byte, error) {
+	for len(p) > 0 {
+		chunk := p[:min(len(p), recordChunk)]
+		p = p[len(chunk):]
+
+		start := len(dst)
+		sealed := len(chunk) + s.aead.Overhead()
+		dst = binary.BigEndian.AppendUint32(dst, uint32(sealed))
+		dst = append(dst, make([]byte, recordNonceSize)...)
+		nonce := dst[start+4:]
+		if _, err := rand.Read(nonce); err != nil {
+			return dst[:start], err
+		}
+		dst = s.aead.Seal(dst, nonce, chunk, recordAAD(s.id[:], s.count, sealed))
+		s.count++
+	}
+	return dst, nil
+}
+
+// recordAppender seals the Writes of RotatingFileHandler onto its live file. It remembers
+// the file it last appended to and where that left the file's end; a file that is new to
+// it, or that another process appended to under WithProcessLock, is scanned again for its
+// ID and record count, and a record torn at its end is cut off first.
+type recordAppender struct {
+	recordSealer
+	file os.FileInfo // the file last appended to; nil when it must be scanned.
+	end  int64       // that file's size after the append.
+}
+
+// seal returns p sealed as the next records of f, an open live file, preceded by a header
+// when f is empty. The caller reports a failed write with lost.
+func (a *recordAppender) seal(f *os.File, p []byte) ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	if a.file == nil || !os.SameFile(a.file, fi) || size != a.end {
+		// only the records another process appended need reading when the file grew.
+		var from int64
+		if a.file != nil && os.SameFile(a.file, fi) && size > a.end {
+			from = a.end
+		}
+		if size, err = a.scan(f, from, size); err != nil {
+			a.file = nil
+			return nil, err
+		}
+	}
+	var out []byte
+	if size == 0 {
+		if out, err = a.start(nil); err != nil {
+			a.file = nil
+			return nil, err
+		}
+	}
+	count := a.count
+	if out, err = a.recordSealer.seal(out, p); err != nil {
+		a.count, a.file = count, nil
+		return nil, err
+	}
+	a.file, a.end = fi, size+int64(len(out))
+	return out, nil
+}
+
+// lost forgets the file after a failed write, so the next seal scans it again.
+func (a *recordAppender) lost() { a.file = nil }
+
+// scan reads on through f, size bytes long, from from: from the header, reading the ID
+// and counting records anew, when from is 0, and otherwise from a record boundary whose
+// count the appender holds. It cuts off a record torn at the end and returns the size
+// that leaves; a header torn before its ID is complete leaves f empty.
+func (a *recordAppender) scan(f *os.File, from, size int64) (int64, error) {
+	off := from
+	if off == 0 {
+		var head [recordFileHead]byte
+		if n, err := f.ReadAt(head[:], 0); n < len(head) {
+			if err != nil && !errors.Is(err, io.EOF) {
+				return size, err
+			}
+			return 0, truncateTo(f, size, 0)
+		}
+		if string(head[:len(recordMagic)]) != recordMagic {
+			return size, fmt.Errorf("loginjector: %s is not an encrypted log file", f.Name())
+		}
+		copy(a.id[:], head[len(recordMagic):])
+		off, a.count = int64(recordFileHead), 0
+	}
+	end, n, err := walkRecords(f, off, size)
+	if err != nil {
+		return size, err
+	}
+	a.count += n
+	return end, truncateTo(f, size, end)
+}
+
+// truncateTo cuts f, size bytes long, to end when that is shorter.
+func truncateTo(f *os.File, size, end int64) error {
+	if end == size {
+		return nil
+	}
+	return f.Truncate(end)
+}
+
+// errRecordStream is returned by walkRecords for record headers that make no sense.
+var errRecordStream = errors.New("loginjector: encrypted log file holds a damaged record")
+
+// walkRecords reads the record headers of f from off, a record boundary, up to size. It
+// returns the end of the last whole record and the number of records up to it; a record
+// cut short by size ends the walk, a header that makes no sense fails it.
+func walkRecords(f io.ReaderAt, off, size int64) (int64, uint64, error) {
+	var n uint64
+	var hdr [recordHeaderLen]byte
+	for off < size {
+		if k, err := f.ReadAt(hdr[:], off); k < len(hdr) {
+			if err != nil && !errors.Is(err, io.EOF) {
+				return off, n, err
+			}
+			break // a header cut short.
+		}
+		sealed := int64(binary.BigEndian.Uint32(hdr[:4]))
+		if sealed < 16 || sealed > recordMaxSealed { // 16: the GCM tag alone.
+			return off, n, errRecordStream
+		}
+		if off+int64(recordHeaderLen)+sealed > size {
+			break // a body cut short.
+		}
+		off += int64(recordHeaderLen) + sealed
+		n++
+	}
+	return off, n, nil
+}
+
+// sealWriter encrypts a stream into a new file of records of recordChunk bytes; Close
+// writes the last, shorter one. It is what a compressed backup's gzip stream is written
+// through.
+type sealWriter struct {
+	w       io.Writer
+	sealer  recordSealer
+	started bool
+	buf     []byte
+	out     []byte
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := min(len(p), recordChunk-len(s.buf))
+		s.buf = append(s.buf, p[:take]...)
+		p = p[take:]
+		if len(s.buf) == recordChunk {
+			if err := s.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (s *sealWriter) flush() error {
+	var err error
+	s.out = s.out[:0]
+	if !s.started {
+		if s.out, err = s.sealer.start(s.out); err != nil {
+			return err
+		}
+		s.started = true
+	}
+	if s.out, err = s.sealer.seal(s.out, s.buf); err != nil {
+		return err
+	}
+	s.buf = s.buf[:0]
+	_, err = s.w.Write(s.out)
+	return err
+}
+
+// Close seals what is buffered. It does not close the underlying writer.
+func (s *sealWriter) Close() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+	return s.flush()
+}
+
+// NewDecryptingReader returns a reader of the plaintext of a file RotatingFileHandler
+// wrote under WithEncryption with key. For a compressed backup the plaintext is the gzip
+// stream. A record torn at the end of r, the tail of a crash, is not read; anything else
+// that fails to decode — a damaged header or length, a record that fails authentication,
+// or a record dropped, reordered or taken from another file — stops the reader with
+// ErrDecrypt.
+//
+// At the end of r the reader returns io.EOF but keeps any incomplete record, and a later
+// Read resumes with whatever r has gained since, so it can follow a live file.
+func NewDecryptingReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newRecordCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{src: r, aead: aead}, nil
+}
+
+// decryptingReader decodes the record stream of src.
+type decryptingReader struct {
+	src   io.Reader
+	aead  cipher.AEAD
+	id    []byte // the file ID, once its header is read.
+	count uint64 // the index of the next record.
+	buf   []byte // bytes read from src and not yet decoded.
+	out   []byte // decrypted bytes not yet returned.
+	err   error
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if ok, err := d.decode(); err != nil {
+			d.err = err
+			return 0, err
+		} else if ok {
+			continue
+		}
+		if err := d.fill(); err != nil {
+			if !errors.Is(err, io.EOF) {
+				d.err = err
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// fill reads more of src into buf. It returns io.EOF when src has nothing more for now.
+func (d *decryptingReader) fill() error {
+	var chunk [32 << 10]byte
+	n, err := d.src.Read(chunk[:])
+	d.buf = append(d.buf, chunk[:n]...)
+	if n > 0 {
+		return nil
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	return err
+}
+
+// decode decrypts the record at the start of buf into out, reading the file header first.
+// It reports false when buf does not hold a whole record yet.
+func (d *decryptingReader) decode() (bool, error) {
+	if d.id == nil {
+		if len(d.buf) < recordFileHead {
+			return false, nil
+		}
+		if string(d.buf[:len(recordMagic)]) != recordMagic {
+			return false, ErrDecrypt
+		}
+		d.id = append([]byte(nil), d.buf[len(recordMagic):recordFileHead]...)
+		d.buf = d.buf[recordFileHead:]
+	}
+	if len(d.buf) < recordHeaderLen {
+		return false, nil
+	}
+	sealed := int(binary.BigEndian.Uint32(d.buf))
+	if sealed < d.aead.Overhead() || sealed > recordMaxSealed {
+		return false, ErrDecrypt
+	}
+	end := recordHeaderLen + sealed
+	if len(d.buf) < end {
+		return false, nil
+	}
+	plain, err := d.aead.Open(nil, d.buf[4:recordHeaderLen], d.buf[recordHeaderLen:end], recordAAD(d.id, d.count, sealed))
+	if err != nil {
+		return false, ErrDecrypt
+	}
+	d.count++
+	d.buf = d.buf[end:]
+	d.out = plain
+	return true, nil
+}
+
+// IsEncrypted reports whether r starts with an encrypted record, as every file written
+// under WithEncryption does; an empty r reports false. A reader of a set that was
+// encrypted part way through uses it to tell the older plaintext files apart.
+func IsEncrypted(r io.ReaderAt) (bool, error) {
+	var magic [len(recordMagic)]byte
+	n, err := r.ReadAt(magic[:], 0)
+	if n < len(magic) {
+		if err == nil || errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	return string(magic[:]) == recordMagic, nil
+}
+
+// isRecordFile is IsEncrypted for the file at path.
+func isRecordFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = f.Close() }()
+	return IsEncrypted(f)
+}
+
+// repairRecordTail cuts off a record torn at the end of the encrypted live file at path,
+// so the next one is appended on a record boundary, and returns the file's size. Only
+// headers are read; a file whose records stop making sense before the end is left alone.
+func repairRecordTail(path string) (uint64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = f.Close() }()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	size := fi.Size()
+	var end int64 // a header cut short leaves nothing.
+	if size >= int64(recordFileHead) {
+		if end, _, err = walkRecords(f, int64(recordFileHead), size); err != nil {
+			if errors.Is(err, errRecordStream) {
+				err = nil
+			}
+			return uint64(size), err
+		}
+	}
+	if err := truncateTo(f, size, end); err != nil {
+		return uint64(size), err
+	}
+	return uint64(end), nil
+}
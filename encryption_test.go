@@ -0,0 +1,225 @@
+package loginjector
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, 32)
+
+// decryptFileOrFail returns the plaintext of an encrypted file, failing the test on any
+// error, including a record that fails authentication.
+func decryptFileOrFail(t *testing.T, path string, key []byte) []byte {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+	r, err := NewDecryptingReader(f, key)
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return b
+}
+
+// sealedRecordsOrFail writes msgs to a new encrypted set in its own folder and returns the
+// live file's path, its header and its records.
+func sealedRecordsOrFail(t *testing.T, msgs ...string) (string, []byte, [][]byte) {
+	t.Helper()
+	dir := t.TempDir()
+	h := RotatingFileHandler(dir, "app", WithEncryption(testKey))
+	for _, m := range msgs {
+		writeRotating(t, h, m)
+	}
+	path := filepath.Join(dir, idxName("app", 1))
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var records [][]byte
+	for off := recordFileHead; off < len(raw); {
+		sealed := int(binary.BigEndian.Uint32(raw[off:]))
+		records = append(records, raw[off:off+recordHeaderLen+sealed])
+		off += recordHeaderLen + sealed
+	}
+	return path, raw[:recordFileHead], records
+}
+
+// decryptBytes returns what a decrypting reader makes of raw, and its error.
+func decryptBytes(t *testing.T, raw []byte) (string, error) {
+	t.Helper()
+	r, err := NewDecryptingReader(bytes.NewReader(raw), testKey)
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	return string(b), err
+}
+
+func TestWithEncryption(t *testing.T) {
+	t.Parallel()
+
+	t.Run("the live file decrypts to the messages and hides them on disk", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := RotatingFileHandler(dir, "app", WithEncryption(testKey))
+		n, err := h.Write([]byte("card 4111 declined "))
+		require.NoError(t, err)
+		assert.Equal(t, len("card 4111 declined\n"), n, "the caller is told the message length, not the disk bytes")
+		writeRotating(t, h, "retrying")
+
+		path := filepath.Join(dir, idxName("app", 1))
+		raw, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.NotContains(t, string(raw), "4111")
+		assert.Equal(t, "card 4111 declined\nretrying\n", string(decryptFileOrFail(t, path, testKey)))
+
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		defer func() { _ = f.Close() }()
+		ok, err := IsEncrypted(f)
+		require.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("a wrong key fails authentication", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := RotatingFileHandler(dir, "app", WithEncryption(testKey))
+		writeRotating(t, h, "secret")
+
+		f, err := os.Open(filepath.Join(dir, idxName("app", 1)))
+		require.NoError(t, err)
+		defer func() { _ = f.Close() }()
+		r, err := NewDecryptingReader(f, bytes.Repeat([]byte{0x24}, 32))
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		assert.ErrorIs(t, err, ErrDecrypt)
+	})
+
+	t.Run("a record torn by a crash is cut off on restart", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := RotatingFileHandler(dir, "app", WithEncryption(testKey))
+		writeRotating(t, h, "before the crash")
+		path := filepath.Join(dir, idxName("app", 1))
+		whole, err := os.ReadFile(path)
+		require.NoError(t, err)
+		writeRotating(t, h, "torn")
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		require.NoError(t, os.Truncate(path, fi.Size()-5))
+		assert.Equal(t, "before the crash\n", string(decryptFileOrFail(t, path, testKey)), "readers skip the torn record")
+
+		h = RotatingFileHandler(dir, "app", WithEncryption(testKey))
+		writeRotating(t, h, "after the restart")
+		raw, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(raw, whole))
+		assert.Equal(t, "before the crash\nafter the restart\n", string(decryptFileOrFail(t, path, testKey)))
+	})
+
+	t.Run("records dropped, reordered, moved or damaged fail to decrypt", func(t *testing.T) {
+		t.Parallel()
+
+		_, head, records := sealedRecordsOrFail(t, "one", "two", "three")
+		require.Len(t, records, 3)
+		_, otherHead, otherRecords := sealedRecordsOrFail(t, "one", "two", "three")
+		join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+		plain, err := decryptBytes(t, join(head, records[0], records[1], records[2]))
+		require.NoError(t, err)
+		assert.Equal(t, "one\ntwo\nthree\n", plain)
+
+		lengthened := append([]byte(nil), records[1]...)
+		binary.BigEndian.PutUint32(lengthened, binary.BigEndian.Uint32(lengthened)+uint32(recordHeaderLen))
+		badMagic := append([]byte(nil), head...)
+		badMagic[0] ^= 0xff
+		for name, raw := range map[string][]byte{
+			"a record dropped":                  join(head, records[0], records[2]),
+			"the first record dropped":          join(head, records[1], records[2]),
+			"records reordered":                 join(head, records[1], records[0], records[2]),
+			"a record from another file":        join(head, records[0], otherRecords[1], records[2]),
+			"records under another file's ID":   join(otherHead, records[0], records[1], records[2]),
+			"a length that misses the next one": join(head, records[0], lengthened, records[2]),
+			"a length out of range":             join(head, records[0], []byte{0xff, 0xff, 0xff, 0xff}, records[1][4:], records[2]),
+			"a damaged magic":                   join(badMagic, records[0], records[1], records[2]),
+			"garbage between records":           join(head, records[0], []byte("LJE1garbage"), records[1], records[2]),
+		} {
+			_, err := decryptBytes(t, raw)
+			assert.ErrorIs(t, err, ErrDecrypt, name)
+		}
+
+		plain, err = decryptBytes(t, join(head, records[0], records[1], records[2][:len(records[2])-3]))
+		require.NoError(t, err, "only a torn last record is tolerated")
+		assert.Equal(t, "one\ntwo\n", plain)
+	})
+
+	t.Run("a file another writer appended to is scanned before appending", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		a := RotatingFileHandler(dir, "app", WithEncryption(testKey))
+		b := RotatingFileHandler(dir, "app", WithEncryption(testKey))
+		writeRotating(t, a, "a1")
+		writeRotating(t, b, "b1")
+		writeRotating(t, a, "a2")
+		writeRotating(t, b, "b2")
+		assert.Equal(t, "a1\nb1\na2\nb2\n", string(decryptFileOrFail(t, filepath.Join(dir, idxName("app", 1)), testKey)))
+	})
+
+	t.Run("backups are compressed before they are encrypted", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(60), WithMaxFiles(50), WithCompress(), WithEncryption(testKey))
+		writeRotating(t, h, strings.Repeat("a", 20))
+		writeRotating(t, h, "b")
+
+		gzPath := filepath.Join(dir, idxName("app", 1)+"."+gzipExtension)
+		require.FileExists(t, gzPath)
+		require.NoFileExists(t, filepath.Join(dir, idxName("app", 1)))
+		zr, err := gzip.NewReader(bytes.NewReader(decryptFileOrFail(t, gzPath, testKey)))
+		require.NoError(t, err)
+		plain, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat("a", 20)+"\n", string(plain))
+		assert.Equal(t, "b\n", string(decryptFileOrFail(t, filepath.Join(dir, idxName("app", 2)), testKey)))
+	})
+
+	t.Run("an invalid key writes nothing", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := RotatingFileHandler(dir, "app", WithEncryption([]byte("short")))
+		for i := 0; i < 2; i++ {
+			_, err := h.Write([]byte("plaintext"))
+			assert.Error(t, err)
+		}
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("a plaintext live file is left behind as a backup", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		writeRotating(t, RotatingFileHandler(dir, "app", WithStableCurrentName()), "written in the clear")
+
+		h := RotatingFileHandler(dir, "app", WithStableCurrentName(), WithEncryption(testKey))
+		writeRotating(t, h, "sealed")
+
+		backup, err := os.ReadFile(filepath.Join(dir, idxName("app", 1)))
+		require.NoError(t, err)
+		assert.Equal(t, "written in the clear\n", string(backup))
+		assert.Equal(t, "sealed\n", string(decryptFileOrFail(t, filepath.Join(dir, "app."+defaultFileExtension), testKey)))
+	})
+}
@@ -0,0 +1,108 @@
+package loginjector
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// FailoverOption configures FailoverWithOptions.
+type FailoverOption func(*failoverConfig)
+
+type failoverConfig struct {
+	probe time.Duration    // WithProbeInterval: how often the primary is retried.
+	clock func() time.Time // withFailoverClock: test seam for the probe timer.
+}
+
+// WithProbeInterval sets how long Failover writes to a fallback before it tries the
+// primary again. The default is 30 seconds; a d of zero or less retries it on every Write.
+func WithProbeInterval(d time.Duration) FailoverOption {
+	return func(c *failoverConfig) { c.probe = d }
+}
+
+// withFailoverClock replaces the clock the probe interval is measured with.
+func withFailoverClock(fn func() time.Time) FailoverOption {
+	return func(c *failoverConfig) { c.clock = fn }
+}
+
+// Failover writes to primary and, while a Write to it fails, to the first of fallbacks
+// that accepts the message, so a full or read-only log folder or an unreachable API does
+// not lose lines — e.g. Failover(RotatingFileHandler(...), os.Stderr), or a TelegramHandler
+// falling back to a file. It is FailoverWithOptions with the default probe interval.
+func Failover(primary io.Writer, fallbacks ...io.Writer) io.Writer {
+	return FailoverWithOptions(primary, fallbacks)
+}
+
+// FailoverWithOptions is Failover with options. Sinks are tried in order, starting from the
+// one currently in use. When a Write moves to a later sink, a one-line notice naming the
+// error is written to that sink before the message, once per switch, so a reader of the
+// fallback knows why lines appear there. While on a fallback the primary is probed with
+// the message being written once every probe interval (WithProbeInterval); when it
+// accepts it, writing moves back to the primary and a notice saying so goes to the
+// fallback. Sinks later than the one in use are never probed.
+//
+// A sink has a message once its Write reports any byte written, even alongside an error,
+// which the Write then returns without failing over. A Write fails only when every sink
+// failed, with all their errors joined. The level of a message reaches every sink that
+// implements LevelWriter. Close closes every sink built by this package, never a
+// caller-supplied one.
+func FailoverWithOptions(primary io.Writer, fallbacks []io.Writer, opts ...FailoverOption) io.Writer {
+	cfg := failoverConfig{probe: 30 * time.Second, clock: time.Now}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	sinks := append([]io.Writer{primary}, fallbacks...)
+
+	active := 0
+	var lastProbe time.Time
+
+	// write delivers msg with send, the sink's Write or WriteLevel.
+	write := func(msg []byte, send func(w io.Writer, p []byte) (int, error)) (int, error) {
+		if active > 0 && cfg.clock().Sub(lastProbe) >= cfg.probe {
+			lastProbe = cfg.clock()
+			if n, err := send(sinks[0], msg); err == nil || n > 0 {
+				_, _ = fmt.Fprintf(sinks[active], "loginjector: failover back to sink 0: it accepts writes again\n")
+				active = 0
+				return n, err
+			}
+		}
+
+		var errs []error
+		for i := active; i < len(sinks); i++ {
+			if i != active {
+				// the notice is best effort: the message itself decides whether sink i is used.
+				_, _ = fmt.Fprintf(sinks[i], "loginjector: failover to sink %d: %v\n", i, errors.Join(errs...))
+			}
+			// a sink that took the message yet reports an error, such as a file handler's
+			// deferred one, has it: writing it to the next sink would duplicate it.
+			n, err := send(sinks[i], msg)
+			if err != nil && n == 0 {
+				errs = append(errs, err)
+				continue
+			}
+			if i != active {
+				active = i
+				lastProbe = cfg.clock()
+			}
+			return n, err
+		}
+		return 0, errors.Join(errs...)
+	}
+
+	return &writer{
+		h: func(msg []byte) (int, error) {
+			return write(msg, func(w io.Writer, p []byte) (int, error) { return w.Write(p) })
+		},
+		hl: func(level LogLevel, msg []byte) (int, error) {
+			return write(msg, func(w io.Writer, p []byte) (int, error) { return writeLevel(w, level, p) })
+		},
+		closer: func() error {
+			var err error
+			for _, s := range sinks {
+				err = errors.Join(err, closeHandler(s))
+			}
+			return err
+		},
+	}
+}
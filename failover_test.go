@@ -0,0 +1,112 @@
+package loginjector
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyWriter records what it is given while up and fails while down.
+type flakyWriter struct {
+	down bool
+	buf  bytes.Buffer
+}
+
+func (f *flakyWriter) Write(p []byte) (int, error) {
+	if f.down {
+		return 0, errors.New("sink down")
+	}
+	return f.buf.Write(p)
+}
+
+func TestFailover(t *testing.T) {
+	t.Parallel()
+
+	t.Run("a failing primary falls back with one notice, then is probed back", func(t *testing.T) {
+		t.Parallel()
+
+		now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+		primary, fallback := &flakyWriter{}, &flakyWriter{}
+		h := FailoverWithOptions(primary, []io.Writer{fallback}, WithProbeInterval(time.Minute), withFailoverClock(func() time.Time { return now }))
+
+		writeRotating(t, h, "one\n")
+		primary.down = true
+		writeRotating(t, h, "two\n")
+		writeRotating(t, h, "three\n")
+		primary.down = false
+		now = now.Add(30 * time.Second)
+		writeRotating(t, h, "four\n")
+		assert.Equal(t, "one\n", primary.buf.String(), "the primary is not probed before the interval")
+
+		now = now.Add(30 * time.Second)
+		writeRotating(t, h, "five\n")
+		writeRotating(t, h, "six\n")
+		assert.Equal(t, "one\nfive\nsix\n", primary.buf.String())
+		assert.Equal(t, strings.Join([]string{
+			"loginjector: failover to sink 1: sink down",
+			"two",
+			"three",
+			"four",
+			"loginjector: failover back to sink 0: it accepts writes again",
+		}, "\n")+"\n", fallback.buf.String())
+	})
+
+	t.Run("every sink failing fails the write with all errors", func(t *testing.T) {
+		t.Parallel()
+
+		h := Failover(&errWriter{err: errors.New("disk full")}, &errWriter{err: errors.New("stderr closed")})
+		_, err := h.Write([]byte("lost"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "disk full")
+		assert.Contains(t, err.Error(), "stderr closed")
+	})
+
+	t.Run("an unwritable folder falls back to the next sink", func(t *testing.T) {
+		t.Parallel()
+
+		// a folder path that is a file fails every open, even for root.
+		dir := filepath.Join(t.TempDir(), "logs")
+		require.NoError(t, os.WriteFile(dir, nil, 0o600))
+
+		var fallback bytes.Buffer
+		h := Failover(RotatingFileHandler(dir, "app"), &fallback)
+		writeRotating(t, h, "kept")
+		assert.True(t, strings.HasPrefix(fallback.String(), "loginjector: failover to sink 1: "), fallback.String())
+		assert.True(t, strings.HasSuffix(fallback.String(), "\nkept"), fallback.String())
+	})
+
+	t.Run("a message the primary took with a deferred error does not fail over", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		var fallback bytes.Buffer
+		h := Failover(RotatingFileHandler(dir, "app", WithCompressLevel(100)), &fallback)
+		n, err := h.Write([]byte("hello\n"))
+		require.ErrorContains(t, err, "compression level", "the handler's deferred error is reported")
+		assert.Equal(t, 6, n)
+		writeRotating(t, h, "second\n")
+
+		assert.Empty(t, fallback.String(), "the primary stays in use")
+		data, err := os.ReadFile(filepath.Join(dir, "app.00000001.log"))
+		require.NoError(t, err)
+		assert.Equal(t, "hello\nsecond\n", string(data))
+	})
+
+	t.Run("the level reaches the sinks", func(t *testing.T) {
+		t.Parallel()
+
+		var synced syncLog
+		file := RotatingFileHandler(t.TempDir(), "app", WithDurableMinLevel(4), withSyncObserver(synced.observe))
+		l := NewLogger(1, Failover(file, io.Discard))
+		l.Printf(4, "payment")
+		assert.NotEmpty(t, synced.take())
+	})
+}
@@ -0,0 +1,260 @@
+package loginjector
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileByFormatHandlerWithOptions is FileByFormatHandler with retention scoped to its own
+// files and the RotatingFileHandler options that make sense for generator-named files.
+// fileNameGenerator returns the file name without the .log extension, as for
+// FileByFormatHandler, and every name it returns must match pattern, a filepath.Match
+// glob such as "app-*" (a name that does not match is rejected and nothing is written).
+// Only files whose name, less .log or .log.gz, matches pattern are counted and pruned, so
+// handlers with disjoint patterns can share a folder without pruning each other's files.
+// Files are ordered by name, so the generator must produce names that sort in time order
+// (e.g. a 2006-01-02 layout).
+//
+// Supported options, with RotatingFileHandler's semantics: WithMaxFiles (the live file
+// included; default 7), WithMaxAge and WithMaxAgeDays, WithFileMode (default 0640),
+// WithDurableWrites and WithDurableMinLevel, and WithCompress with WithCompressLevel.
+// Under WithCompress, each time the generated name changes every earlier plaintext file
+// of the pattern is gzipped to name.log.gz — so a file left by a previous run is
+// compressed too — before the pruning pass. Compression
+// runs synchronously; WithAsyncCompress compresses the same way. A plaintext whose .gz
+// already exists — the generator repeated an old name, or a crash interrupted the
+// compression — is kept plaintext beside it, never overwriting or discarding either; the
+// pair counts and is pruned as one file. The remaining options do not apply and are
+// ignored.
+//
+// Retention runs on the first Write and whenever the generated name changes. A pattern
+// that is malformed or contains a path separator makes every Write fail.
+func FileByFormatHandlerWithOptions(folder, pattern string, fileNameGenerator func() string, opts ...RotatingFileOption) io.Writer {
+	cfg := rotatingFileConfig{
+		maxFiles:      7,
+		fileMode:      defaultFilePermissions,
+		compressLevel: gzip.DefaultCompression,
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	if _, err := filepath.Match(pattern, ""); err != nil || pattern == "" || filepath.Base(pattern) != pattern {
+		return &writer{
+			h: func([]byte) (int, error) {
+				return 0, fmt.Errorf("loginjector: file name pattern %q must be a valid glob without path separators", pattern)
+			},
+		}
+	}
+
+	var seedErr error
+	if cfg.compressLevel < gzip.HuffmanOnly || cfg.compressLevel > gzip.BestCompression {
+		seedErr = fmt.Errorf("loginjector: invalid gzip compression level %d", cfg.compressLevel)
+		cfg.compressLevel = gzip.DefaultCompression
+	}
+	if cfg.compress {
+		// clear the temps of a compression a previous run was interrupted in.
+		seedErr = errors.Join(seedErr, removePatternTemps(folder, pattern))
+	}
+
+	lastFileName := ""
+	folderPending := false
+	// write appends msg to the generated file; durable fsyncs it, and the folder when a file
+	// was created since its last fsync, before it returns.
+	write := func(msg []byte, durable bool) (int, error) {
+		err := seedErr
+		seedErr = nil
+
+		stem := fileNameGenerator()
+		if !matchPattern(pattern, stem) {
+			return 0, errors.Join(err, fmt.Errorf("loginjector: generated file name %q does not match pattern %q", stem, pattern))
+		}
+		fileName := stem + "." + defaultFileExtension
+
+		// a file a Write creates needs its folder entry made durable too, by this Write or
+		// the first durable one after it.
+		if cfg.anyDurable() {
+			if _, e := os.Stat(filepath.Join(folder, fileName)); os.IsNotExist(e) {
+				folderPending = true
+			}
+		}
+
+		f, openErr := os.OpenFile(filepath.Join(folder, fileName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, cfg.fileMode)
+		if openErr != nil {
+			return 0, errors.Join(err, openErr)
+		}
+
+		var l uint64 = 0
+
+		if n, e := f.Write(bytes.TrimSpace(msg)); e != nil {
+			err = errors.Join(err, e)
+		} else {
+			l += uint64(n)
+		}
+
+		if n, e := f.Write([]byte{'\n'}); e != nil {
+			err = errors.Join(err, e)
+		} else {
+			l += uint64(n)
+		}
+
+		if durable {
+			if e := cfg.fsync(f); e != nil {
+				err = errors.Join(err, e)
+			}
+		}
+
+		if e := f.Close(); e != nil {
+			err = errors.Join(err, e)
+		}
+		if durable && folderPending {
+			if e := cfg.fsyncFolder(folder); e != nil {
+				err = errors.Join(err, e)
+			} else {
+				folderPending = false
+			}
+		}
+
+		if lastFileName != fileName {
+			lastFileName = fileName
+			if cfg.compress {
+				err = errors.Join(err, compressPattern(folder, pattern, fileName, cfg))
+			}
+			err = errors.Join(err, prunePattern(folder, pattern, fileName, cfg, time.Now()))
+		}
+
+		return int(l), err
+	}
+
+	w := &writer{
+		h:  func(msg []byte) (int, error) { return write(msg, cfg.durable) },
+		hl: func(level LogLevel, msg []byte) (int, error) { return write(msg, cfg.durableFor(level)) },
+	}
+	return w
+}
+
+// matchPattern reports whether the generated name stem (no extension) is a plain file
+// name matching pattern.
+func matchPattern(pattern, stem string) bool {
+	if stem == "" || filepath.Base(stem) != stem {
+		return false
+	}
+	ok, err := filepath.Match(pattern, stem)
+	return err == nil && ok
+}
+
+// patternStem strips .log or .log.gz from base and reports whether the remaining stem
+// belongs to pattern.
+func patternStem(base, pattern string) (stem string, gz bool, ok bool) {
+	if strings.HasSuffix(base, "."+gzipExtension) {
+		gz = true
+		base = strings.TrimSuffix(base, "."+gzipExtension)
+	}
+	if !strings.HasSuffix(base, "."+defaultFileExtension) {
+		return "", gz, false
+	}
+	stem = strings.TrimSuffix(base, "."+defaultFileExtension)
+	return stem, gz, matchPattern(pattern, stem)
+}
+
+// listPattern groups pattern's files in folder by stem, so a .log/.log.gz pair counts as
+// one, skipping the live file (excludeBase). .gz files are included only when includeGz is
+// set.
+func listPattern(folder, pattern string, includeGz bool, excludeBase string) (map[string]*backupEntry, error) {
+	matches, err := globRotation(folder, includeGz)
+	if err != nil {
+		return nil, err
+	}
+	groups := make(map[string]*backupEntry)
+	for _, p := range matches {
+		base := filepath.Base(p)
+		if base == excludeBase {
+			continue
+		}
+		stem, _, ok := patternStem(base, pattern)
+		if !ok {
+			continue
+		}
+		fi, e := os.Stat(p)
+		if e != nil {
+			continue
+		}
+		g := groups[stem]
+		if g == nil {
+			g = &backupEntry{}
+			groups[stem] = g
+		}
+		g.paths = append(g.paths, p)
+		if fi.ModTime().After(g.mtime) {
+			g.mtime = fi.ModTime()
+		}
+	}
+	return groups, nil
+}
+
+// prunePattern enforces the WithMaxAge and WithMaxFiles bounds on pattern's files in
+// folder, oldest name first, never touching the live file liveBase.
+func prunePattern(folder, pattern, liveBase string, cfg rotatingFileConfig, now time.Time) error {
+	groups, err := listPattern(folder, pattern, cfg.compress, liveBase)
+	if err != nil {
+		return err
+	}
+	stems := make([]string, 0, len(groups))
+	for stem := range groups {
+		stems = append(stems, stem)
+	}
+	sort.Strings(stems)
+
+	ordered := make([]*backupEntry, 0, len(stems))
+	for _, stem := range stems {
+		ordered = append(ordered, groups[stem])
+	}
+	return pruneOrdered(ordered, cfg, now)
+}
+
+// compressPattern gzips every plaintext file of pattern in folder except the live file
+// liveBase, skipping any whose .gz already exists so a compressed file is never replaced.
+func compressPattern(folder, pattern, liveBase string, cfg rotatingFileConfig) error {
+	logs, err := globRotation(folder, false)
+	if err != nil {
+		return err
+	}
+	for _, p := range logs {
+		base := filepath.Base(p)
+		if _, _, ok := patternStem(base, pattern); !ok || base == liveBase {
+			continue
+		}
+		if _, e := os.Stat(p + "." + gzipExtension); e == nil {
+			continue
+		}
+		err = errors.Join(err, compressFileLevel(folder, base, cfg.fileMode, cfg.compressLevel))
+	}
+	if cfg.anyDurable() {
+		err = errors.Join(err, cfg.fsyncFolder(folder))
+	}
+	return err
+}
+
+// removePatternTemps removes .log.gz.tmp temps of pattern's files left by an interrupted
+// compression. Unlike reconcileCompressed it keeps a plaintext found beside its .gz: with
+// generated names that may be a repeated name's fresh data rather than a crash remnant.
+func removePatternTemps(folder, pattern string) error {
+	tmps, err := filepath.Glob(filepath.Join(folder, "*."+defaultFileExtension+"."+gzipExtension+"."+tmpExtension))
+	if err != nil {
+		return err
+	}
+	for _, p := range tmps {
+		if _, _, ok := patternStem(strings.TrimSuffix(filepath.Base(p), "."+tmpExtension), pattern); ok {
+			err = errors.Join(err, removeIfExists(p))
+		}
+	}
+	return err
+}
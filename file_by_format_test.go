@@ -0,0 +1,140 @@
+package loginjector
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// dayGenerator returns a generator of prefix-<date> names and a func that moves the date
+// on by one day.
+func dayGenerator(prefix string) (gen func() string, next func()) {
+	m := sync.Mutex{}
+	day := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	gen = func() string {
+		m.Lock()
+		defer m.Unlock()
+		return prefix + "-" + day.Format(time.DateOnly)
+	}
+	next = func() {
+		m.Lock()
+		defer m.Unlock()
+		day = day.AddDate(0, 0, 1)
+	}
+	return gen, next
+}
+
+func TestFileByFormatHandlerWithOptions(t *testing.T) {
+	t.Run("handlers sharing a folder prune only their own files", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		genA, nextA := dayGenerator("access")
+		genE, nextE := dayGenerator("errors")
+		access := FileByFormatHandlerWithOptions(dir, "access-*", genA, WithMaxFiles(2))
+		errs := FileByFormatHandlerWithOptions(dir, "errors-*", genE, WithMaxFiles(3))
+
+		for i := 0; i < 4; i++ {
+			writeRotating(t, access, "a")
+			writeRotating(t, errs, "e")
+			nextA()
+			nextE()
+		}
+
+		files, err := extractFilesOrFail(dir)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{
+			"access-2000-01-03.log": "a\n",
+			"access-2000-01-04.log": "a\n",
+			"errors-2000-01-02.log": "e\n",
+			"errors-2000-01-03.log": "e\n",
+			"errors-2000-01-04.log": "e\n",
+		}, files)
+	})
+
+	t.Run("a name outside the pattern is rejected", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := FileByFormatHandlerWithOptions(dir, "app-*", func() string { return "other-2000-01-01" })
+
+		_, err := h.Write([]byte("lost"))
+		require.ErrorContains(t, err, `generated file name "other-2000-01-01" does not match pattern "app-*"`)
+		require.NoFileExists(t, filepath.Join(dir, "other-2000-01-01.log"))
+	})
+
+	t.Run("a malformed pattern fails every Write", func(t *testing.T) {
+		t.Parallel()
+
+		for _, pattern := range []string{"", "app-[", "sub/app-*"} {
+			h := FileByFormatHandlerWithOptions(t.TempDir(), pattern, func() string { return "app-1" })
+			_, err := h.Write([]byte("x"))
+			require.ErrorContains(t, err, "file name pattern", pattern)
+		}
+	})
+
+	t.Run("the previous file is compressed when the name changes", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		// a plaintext left by an earlier run is compressed on the first Write too.
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "app-1999-12-31.log"), []byte("old\n"), defaultFilePermissions))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "app-1999-12-31.log.gz.tmp"), []byte("junk"), defaultFilePermissions))
+		gen, next := dayGenerator("app")
+		h := FileByFormatHandlerWithOptions(dir, "app-*", gen, WithMaxFiles(50), WithCompress(), WithFileMode(0o600))
+
+		writeRotating(t, h, "one")
+		next()
+		writeRotating(t, h, "two")
+
+		files := extractFilesWithGzOrFail(t, dir)
+		require.Equal(t, map[string]string{
+			"app-1999-12-31.log.gz": "old\n",
+			"app-2000-01-01.log.gz": "one\n",
+			"app-2000-01-02.log":    "two\n",
+		}, files)
+		fi, err := os.Stat(filepath.Join(dir, "app-2000-01-02.log"))
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	})
+
+	t.Run("a repeated name never overwrites its .gz", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		name := "app-2000-01-01"
+		h := FileByFormatHandlerWithOptions(dir, "app-*", func() string { return name }, WithMaxFiles(50), WithCompress())
+
+		writeRotating(t, h, "first")
+		name = "app-2000-01-02"
+		writeRotating(t, h, "second")
+		name = "app-2000-01-01" // the clock stepped back
+		writeRotating(t, h, "third")
+		name = "app-2000-01-03"
+		writeRotating(t, h, "fourth")
+
+		files := extractFilesWithGzOrFail(t, dir)
+		require.Equal(t, "first\n", files["app-2000-01-01.log.gz"])
+		require.Equal(t, "third\n", files["app-2000-01-01.log"])
+	})
+
+	t.Run("old files age out", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		touchOld(t, filepath.Join(dir, "app-1999-01-01.log"), "stale\n", 48*time.Hour)
+		touchOld(t, filepath.Join(dir, "other-1999-01-01.log"), "foreign\n", 48*time.Hour)
+		gen, _ := dayGenerator("app")
+		h := FileByFormatHandlerWithOptions(dir, "app-*", gen, WithMaxAge(24*time.Hour))
+
+		writeRotating(t, h, "fresh")
+
+		require.NoFileExists(t, filepath.Join(dir, "app-1999-01-01.log"))
+		require.FileExists(t, filepath.Join(dir, "other-1999-01-01.log"))
+		require.FileExists(t, filepath.Join(dir, "app-2000-01-01.log"))
+	})
+}
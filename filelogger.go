@@ -47,6 +47,18 @@ func WithFileCompression() FileLoggerOption {
 	return func(c *fileLoggerConfig) { c.compress = true }
 }
 
+// WithDurableLevel forwards RotatingFileHandler's WithDurableMinLevel to the rotating
+// handlers: a message at or above level is fsynced before the Printf that logged it
+// returns, so it survives a power cut, while lower levels stay buffered by the OS and
+// fast. The folder is fsynced after every rotation as well. It is OFF by default; pass the
+// lowest level, e.g. levels.Debug, to make every message durable.
+func WithDurableLevel(level LogLevel) FileLoggerOption {
+	return func(c *fileLoggerConfig) {
+		c.durableLevel = level
+		c.durableLevelSet = true
+	}
+}
+
 // WithTempDirFallback enables the temp-directory fallback: when the folder argument to
 // NewFileLogger is empty, logs are written under filepath.Join(os.TempDir(), "logs")
 // instead of returning an error. This option is OFF by default — an empty folder
@@ -103,6 +115,38 @@ func WithPrinterMinLevel(level LogLevel) FileLoggerOption {
 	}
 }
 
+// WithLevelSplit adds a second rotating set, prefix.name.<8 hex>.log in the same folder,
+// that receives only messages at or above min, in addition to the main file — e.g.
+// WithLevelSplit("errors", levels.Error) keeps an errors-only app.errors.*.log for on-call.
+// Its lines are timestamped like the main file's. The set inherits the main file's size,
+// count, age and compression limits; opts override them for this set alone, e.g.
+// WithMaxFiles(30) or WithMaxAge(90 * 24 * time.Hour). The Logger's own minLevel still
+// gates first, so a min below it never sees the lower levels.
+//
+// With a split configured, each set prunes only its own prefix's files, so the main
+// set's count bound never removes split files and vice versa. The option may be passed
+// more than once with different names; name must be a plain, non-empty file name
+// component, and NewFileLogger rejects a duplicate.
+func WithLevelSplit(name string, min LogLevel, opts ...RotatingFileOption) FileLoggerOption {
+	return func(c *fileLoggerConfig) {
+		c.splits = append(c.splits, levelSplit{name: name, min: min, opts: opts})
+	}
+}
+
+// levelSplit is one WithLevelSplit set.
+type levelSplit struct {
+	name string
+	min  LogLevel
+	opts []RotatingFileOption
+}
+
+// withScopedPrune makes a rotating handler prune only its own prefix's backups even when
+// no option would otherwise move it off the whole-folder verifyFiles, so sets sharing a
+// folder under WithLevelSplit never prune each other.
+func withScopedPrune() RotatingFileOption {
+	return func(c *rotatingFileConfig) { c.scopedPrune = true }
+}
+
 // NewFileLogger builds a Logger that writes to size-rotated files under folder and,
 // by default, mirrors every message at or above minLevel to a timestamped stdout
 // printer (TimestampedPrintHandler). The printer fires as a handler (level >= minLevel),
@@ -120,7 +164,8 @@ func WithPrinterMinLevel(level LogLevel) FileLoggerOption {
 // hidden dotfile log (prefix ".") is a footgun.
 //
 // Defaults: maxFileCapacity = 5 MiB, maxFilesInFolder = 7, printer ON, std-log
-// redirect OFF, temp fallback OFF.
+// redirect OFF, temp fallback OFF, no level-split sets (see WithLevelSplit), no fsync
+// (see WithDurableLevel).
 func NewFileLogger(folder, prefix string, minLevel LogLevel, opts ...FileLoggerOption) (*Logger, error) {
 	cfg := fileLoggerConfig{
 		maxFileCapacity:  5 << 20,
@@ -146,6 +191,17 @@ func NewFileLogger(folder, prefix string, minLevel LogLevel, opts ...FileLoggerO
 		return nil, fmt.Errorf("loginjector: file logger prefix %q must not contain path separators", prefix)
 	}
 
+	seen := make(map[string]bool, len(cfg.splits))
+	for _, sp := range cfg.splits {
+		if sp.name == "" || sp.name == "." || sp.name == ".." || filepath.Base(sp.name) != sp.name {
+			return nil, fmt.Errorf("loginjector: level split name %q must be a plain file name", sp.name)
+		}
+		if seen[sp.name] {
+			return nil, fmt.Errorf("loginjector: duplicate level split name %q", sp.name)
+		}
+		seen[sp.name] = true
+	}
+
 	if folder == "" {
 		if !cfg.tempFallback {
 			return nil, fmt.Errorf("loginjector: empty folder and temp fallback not enabled")
@@ -173,12 +229,26 @@ func NewFileLogger(folder, prefix string, minLevel LogLevel, opts ...FileLoggerO
 	if cfg.compress {
 		rotatingOpts = append(rotatingOpts, WithCompress())
 	}
+	if cfg.durableLevelSet {
+		rotatingOpts = append(rotatingOpts, WithDurableMinLevel(cfg.durableLevel))
+	}
+
+	// the sets share the folder, so none may keep the whole-folder prune that would count
+	// the others' files; without a split the main handler is left exactly as before.
+	if len(cfg.splits) > 0 {
+		rotatingOpts = append(rotatingOpts, withScopedPrune())
+	}
 
 	handlers := []io.Writer{
 		// stamp file lines sink-side (blessed emitters use Lmsgprefix only, so nothing
 		// else stamps the file); the RotatingFileHandler stays the underlying sink.
 		TimestampedHandler(RotatingFileHandler(folder, prefix, rotatingOpts...)),
 	}
+	for _, sp := range cfg.splits {
+		// the split set's own options come last so they override the inherited limits.
+		opts := append(append([]RotatingFileOption(nil), rotatingOpts...), sp.opts...)
+		handlers = append(handlers, WithMinLevel(sp.min, TimestampedHandler(RotatingFileHandler(folder, prefix+"."+sp.name, opts...))))
+	}
 	if cfg.printer {
 		p := TimestampedPrintHandler(cfg.printerOpts...)
 		if cfg.printerMinLevelSet {
@@ -203,6 +273,8 @@ type fileLoggerConfig struct {
 	maxFilesInFolder   int
 	maxAge             time.Duration // WithMaxFileAge; forwarded to RotatingFileHandler.
 	compress           bool          // WithFileCompression; forwarded to RotatingFileHandler.
+	durableLevel       LogLevel      // WithDurableLevel; forwarded as WithDurableMinLevel.
+	durableLevelSet    bool          // disambiguates explicit-zero from unset.
 	tempFallback       bool
 	printer            bool
 	printerOpts        []PrintOption
@@ -210,4 +282,5 @@ type fileLoggerConfig struct {
 	printerMinLevelSet bool // disambiguates explicit-zero from unset.
 	stdLogRedirect     bool
 	redirectLevel      LogLevel
+	splits             []levelSplit // WithLevelSplit sets, in option order.
 }
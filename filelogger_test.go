@@ -460,6 +460,23 @@ func TestNewFileLogger_RotationOptions(t *testing.T) {
 		require.ErrorIs(t, err, os.ErrNotExist, "WithMaxFileAge must prune the backdated backup")
 	})
 
+	t.Run("WithDurableLevel keeps writing every level", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		l, err := NewFileLogger(dir, "app", minLevel, WithoutPrinter(), WithDurableLevel(3))
+		require.NoError(t, err)
+
+		for _, lv := range []LogLevel{minLevel, 3} {
+			_, err = l.WriteLog(lv, []byte(fmt.Sprintf("level %d", lv)))
+			require.NoError(t, err)
+		}
+
+		files, err := extractFilesOrFail(dir)
+		require.NoError(t, err)
+		require.Regexp(t, `level 1\n.* level 3\n$`, files["app.00000001.log"])
+	})
+
 	t.Run("no new options leaves NewFileLogger behavior unchanged", func(t *testing.T) {
 		t.Parallel()
 
@@ -478,3 +495,75 @@ func TestNewFileLogger_RotationOptions(t *testing.T) {
 		require.Empty(t, gzs, "without WithFileCompression no .gz must be produced")
 	})
 }
+
+func TestNewFileLogger_WithLevelSplit(t *testing.T) {
+	const (
+		debug LogLevel = 1
+		info  LogLevel = 2
+		erro  LogLevel = 5
+	)
+
+	t.Run("the split set receives only the high levels", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		l, err := NewFileLogger(dir, "app", debug, WithoutPrinter(), WithLevelSplit("errors", erro))
+		require.NoError(t, err)
+
+		for _, m := range []struct {
+			level LogLevel
+			text  string
+		}{{debug, "debug line"}, {info, "info line"}, {erro, "error line"}} {
+			_, err = l.WriteLog(m.level, []byte(m.text))
+			require.NoError(t, err)
+		}
+
+		files, err := extractFilesOrFail(dir)
+		require.NoError(t, err)
+		main, split := files["app.00000001.log"], files["app.errors.00000001.log"]
+		require.Contains(t, main, "debug line")
+		require.Contains(t, main, "error line")
+		require.NotContains(t, split, "info line")
+		require.Regexp(t, `^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} error line\n$`, split)
+	})
+
+	t.Run("the sets rotate and prune independently", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		l, err := NewFileLogger(dir, "app", debug, WithoutPrinter(),
+			WithMaxFileCapacity(20), WithMaxFilesInFolder(2),
+			WithLevelSplit("errors", erro, WithMaxFileSize(1000), WithMaxFiles(5)))
+		require.NoError(t, err)
+
+		_, err = l.WriteLog(erro, []byte("the one error"))
+		require.NoError(t, err)
+		// every stamped line exceeds 20 bytes, so each write rotates the main set.
+		for i := 0; i < 6; i++ {
+			_, err = l.WriteLog(debug, []byte(fmt.Sprintf("noise-%d-padding", i)))
+			require.NoError(t, err)
+		}
+
+		files, err := extractFilesOrFail(dir)
+		require.NoError(t, err)
+		require.Contains(t, files["app.errors.00000001.log"], "the one error",
+			"the main set's count bound must not prune the split set")
+		mains, err := filepath.Glob(filepath.Join(dir, "app.0*.log"))
+		require.NoError(t, err)
+		require.Len(t, mains, 1, "the main set keeps its own bound (one backup; the live file is not yet written)")
+	})
+
+	t.Run("invalid and duplicate names are rejected", func(t *testing.T) {
+		t.Parallel()
+
+		for _, opts := range [][]FileLoggerOption{
+			{WithLevelSplit("", erro)},
+			{WithLevelSplit("a/b", erro)},
+			{WithLevelSplit("..", erro)},
+			{WithLevelSplit("errors", erro), WithLevelSplit("errors", info)},
+		} {
+			_, err := NewFileLogger(t.TempDir(), "app", debug, append(opts, WithoutPrinter())...)
+			require.ErrorContains(t, err, "level split name")
+		}
+	})
+}
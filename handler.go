@@ -3,98 +3,20 @@ package loginjector
 import (
 	"bytes"
 	"compress/gzip"
-	"encoding/json"
+	"crypto/cipher"
 	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
-	"net/http"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func TelegramHandler(botToken, chatID, fileName string, labels ...string) io.Writer {
-	const telegramAPI = "https://api.telegram.org/bot"
-	url := fmt.Sprintf("%s%s/sendDocument", telegramAPI, botToken)
-	// redactToken masks botToken in an error string before it is surfaced: a failed
-	// request build or client.Do returns a *url.Error whose text embeds the full URL,
-	// token included, which would otherwise land in logs and log files.
-	redactToken := func(s string) string {
-		// guard: an empty or very short token would over-match unrelated substrings, and
-		// no real Telegram token is that short, so skip redaction below the threshold.
-		if len(botToken) < 8 {
-			return s
-		}
-		return strings.ReplaceAll(s, botToken, "***")
-	}
-	w := &writer{
-		h: func(msg []byte) (int, error) {
-			payload := &bytes.Buffer{}
-			parts := multipart.NewWriter(payload)
-
-			if filePart, err := parts.CreateFormFile("document", fileName); err != nil {
-				return 0, fmt.Errorf("could not create request form file, details: %s", err)
-			} else if _, err = filePart.Write(msg); err != nil {
-				return 0, fmt.Errorf("could not write file part to request, details: %s", err)
-			}
-
-			if err := parts.WriteField("chat_id", chatID); err != nil {
-				return 0, fmt.Errorf("could not write chat_id field to request: %s", err)
-			}
-
-			caption := strings.Join(labels, "\n")
-			caption = fmt.Sprintf("%s %s", time.Now().UTC().Format("2006-01-02 15:04:05"), caption)
-			caption = strings.TrimSpace(caption)
-			if err := parts.WriteField("caption", caption); err != nil {
-				return 0, fmt.Errorf("could not write caption field to request: %s", err)
-			}
-
-			if err := parts.WriteField("parse_mode", "HTML"); err != nil {
-				return 0, fmt.Errorf("could not write parse_mode field to request: %s", err)
-			}
-
-			contentType := parts.FormDataContentType()
-
-			if err := parts.Close(); err != nil {
-				return 0, fmt.Errorf("could not close request: %s", err)
-			}
-
-			request, err := http.NewRequest("POST", url, payload)
-			if err != nil {
-				return 0, fmt.Errorf("could not create HTTP request: %v", redactToken(err.Error()))
-			}
-			request.Header.Set("Content-Type", contentType)
-
-			r, err := (&http.Client{Timeout: time.Second * 20}).Do(request)
-			if err != nil {
-				return 0, fmt.Errorf("could not send HTTP request: %v", redactToken(err.Error()))
-			}
-			defer CloseOrLog(r.Body)
-
-			if r.StatusCode != http.StatusOK {
-				return 0, fmt.Errorf("could not to deliver message, status code: %v", r.StatusCode)
-			}
-
-			var response struct {
-				Ok bool `json:"ok"`
-			}
-			rawResponse := bytes.NewBufferString("")
-			err = json.NewDecoder(io.TeeReader(r.Body, rawResponse)).Decode(&response)
-			if err != nil || !response.Ok {
-				return 0, fmt.Errorf("could not decode response: %v\n%s", err, rawResponse.String())
-			}
-
-			return len(msg), nil
-		},
-	}
-	return w
-}
-
 // RotatingFileOption configures RotatingFileHandler.
 type RotatingFileOption func(*rotatingFileConfig)
 
@@ -142,9 +64,9 @@ func WithFreshStart() RotatingFileOption {
 // unsupported without WithFreshStart or a clean folder; a stale prefix.log left by a
 // prior stable run must be drained manually when switching back to the indexed scheme.
 //
-// Single-writer invariant: exactly one process may own a (folder, prefix) pair. Two
-// processes renaming prefix.log concurrently race and lose backups; this is not defended
-// in code (lumberjack has the same limitation).
+// Single-writer invariant: exactly one process may own a (folder, prefix) pair unless
+// every writer passes WithProcessLock. Without it, two processes renaming prefix.log
+// concurrently race and lose backups (lumberjack has the same limitation).
 func WithStableCurrentName() RotatingFileOption {
 	return func(c *rotatingFileConfig) { c.stableName = true }
 }
@@ -198,13 +120,45 @@ func WithFileMode(mode os.FileMode) RotatingFileOption {
 // index, the .gz is authoritative and the plaintext is discarded on the next
 // construction. A .log/.log.gz pair counts as a single file for the WithMaxFiles bound.
 //
-// Because it runs under the handler mutex, compression adds a bounded latency spike to
-// the one Write per rotation; the async background variant is deferred. The handler never
-// appends into a .gz file.
+// Because it runs under the handler mutex, compression adds a latency spike to the one
+// Write per rotation that grows with the file size; WithAsyncCompress moves it to a
+// background goroutine. The handler never appends into a .gz file.
 func WithCompress() RotatingFileOption {
 	return func(c *rotatingFileConfig) { c.compress = true }
 }
 
+// WithAsyncCompress is WithCompress with the gzip pass moved off the write path: the Write
+// that triggers a rotation only renames or switches the live file, and a single background
+// goroutine per handler compresses the backup, then delivers its WithOnRotate event and
+// runs the pruning that would otherwise happen inline, in rotation order. A large backup
+// therefore no longer stalls every logging goroutine behind the handler mutex.
+//
+// Crash safety is unchanged: the gzip still goes through the temp + fsync + rename
+// sequence, and on the next construction reconcileCompressed clears interrupted .tmp files
+// while any plaintext backup still waiting for compression is queued again. A plaintext
+// backup on disk cannot be told apart from one whose compression was interrupted, so
+// this queues every plaintext backup of prefix in folder — including those a previous
+// run left without compression enabled: turning WithAsyncCompress on for an existing set
+// compresses its whole backlog in the background, oldest first. Move the old backups
+// aside, or start a new prefix, to keep them as they are. A background
+// failure is reported on the next Write's return value (or by Close). Call Close on the
+// returned writer (or Logger.Close) at shutdown to wait for pending compressions.
+func WithAsyncCompress() RotatingFileOption {
+	return func(c *rotatingFileConfig) {
+		c.compress = true
+		c.asyncCompress = true
+	}
+}
+
+// WithCompressLevel sets the gzip level used by WithCompress and WithAsyncCompress, from
+// gzip.HuffmanOnly (-2) to gzip.BestCompression (9); the default is
+// gzip.DefaultCompression. gzip.BestSpeed keeps rotation cheap on busy hosts. An
+// out-of-range level falls back to the default and is reported on the first Write. The
+// option does not enable compression by itself.
+func WithCompressLevel(level int) RotatingFileOption {
+	return func(c *rotatingFileConfig) { c.compressLevel = level }
+}
+
 // RotatingFileHandler saves messages to files by number. The file name is
 // generated from prefix and an incrementing index (e.g. prefix.00000001.log).
 // When a file exceeds the maximum size the handler moves to the next index and
@@ -231,19 +185,32 @@ func WithCompress() RotatingFileOption {
 // target name is not known until the first write).
 //
 // The returned writer is mutex-guarded; the logger never calls its Write concurrently,
-// and compression (when enabled) runs synchronously inside that lock. A single process
+// and compression (when enabled) runs synchronously inside that lock unless
+// WithAsyncCompress moves it to a background goroutine. It also implements io.Closer:
+// Close (or Logger.Close) waits for pending background compressions and queued
+// WithOnRotate callbacks. A single process
 // must own a given (folder, prefix) pair; concurrent writers from multiple processes are
-// unsupported under WithStableCurrentName and WithCompress.
+// unsupported under WithStableCurrentName and WithCompress unless every one of them passes
+// WithProcessLock.
 func RotatingFileHandler(folder, prefix string, opts ...RotatingFileOption) io.Writer {
 	cfg := rotatingFileConfig{
-		maxFileSize: 5 << 20,
-		maxFiles:    7,
-		fileMode:    defaultFilePermissions, // zero-value os.FileMode is 0 (invalid); seed it
+		maxFileSize:   5 << 20,
+		maxFiles:      7,
+		fileMode:      defaultFilePermissions,  // zero-value os.FileMode is 0 (invalid); seed it
+		compressLevel: gzip.DefaultCompression, // zero is gzip.NoCompression; seed it
 	}
 	for _, o := range opts {
 		o(&cfg)
 	}
 
+	// an out-of-range gzip level would fail every compression and strand plaintext
+	// backups; fall back to the default and report it on the first Write.
+	var levelErr error
+	if cfg.compressLevel < gzip.HuffmanOnly || cfg.compressLevel > gzip.BestCompression {
+		levelErr = fmt.Errorf("loginjector: invalid gzip compression level %d", cfg.compressLevel)
+		cfg.compressLevel = gzip.DefaultCompression
+	}
+
 	// reject a prefix that contains path separators — it would escape the folder.
 	if filepath.Base(prefix) != prefix {
 		return &writer{
@@ -253,9 +220,40 @@ func RotatingFileHandler(folder, prefix string, opts ...RotatingFileOption) io.W
 		}
 	}
 
+	// an unusable key must never degrade to plaintext: refuse every Write instead.
+	var aead cipher.AEAD
+	var appender *recordAppender
+	if cfg.key != nil {
+		var err error
+		if aead, err = newRecordCipher(cfg.key); err != nil {
+			return &writer{
+				h: func([]byte) (int, error) { return 0, err },
+			}
+		}
+		appender = &recordAppender{recordSealer: recordSealer{aead: aead}}
+	}
+
 	index := 1
 	var fileSize uint64
 	var seedErr error
+
+	// under WithProcessLock the resume scan, the fresh-start wipe and crash reconciliation
+	// run under the cross-process lock, so a peer is never caught mid-rotation.
+	var plock *processLock
+	var lockErr error
+	if cfg.processLock {
+		plock = newProcessLock(folder, prefix, cfg.fileMode)
+		if lockErr = plock.lock(); lockErr != nil {
+			plock = nil
+		}
+	}
+
+	if cfg.naming.timestamp && !cfg.freshStart {
+		// upgrade an indexed set in place before anything scans it, so legacy backups keep
+		// their order and retention under the timestamp scheme instead of being orphaned.
+		seedErr = errors.Join(seedErr, migrateIndexedBackups(folder, prefix, cfg.naming))
+	}
+
 	if cfg.freshStart {
 		// overwrite-on-start forces a clean start regardless of what is on disk, so the
 		// resume scan is skipped and every existing prefix.<8 hex>.log[.gz] file (plus the
@@ -264,13 +262,15 @@ func RotatingFileHandler(folder, prefix string, opts ...RotatingFileOption) io.W
 		// behind would let pruning remove the fresh files ahead of them and let a later
 		// rotation append onto old content. Index/size stay 1/0; a missing file or folder
 		// is a no-op. Any remove error is surfaced on first Write.
-		seedErr = resetRotation(folder, prefix, cfg)
+		seedErr = errors.Join(seedErr, resetRotation(folder, prefix, cfg))
 	} else {
 		// resume at the highest existing index so the handler keeps appending to the
 		// newest file across a process restart, instead of restarting at index 1 —
 		// which is the lexicographically-oldest file that pruning removes first,
 		// destroying the newest data.
-		index, fileSize, seedErr = resumeRotation(folder, prefix, cfg)
+		var resumeErr error
+		index, fileSize, resumeErr = resumeRotation(folder, prefix, cfg)
+		seedErr = errors.Join(seedErr, resumeErr)
 	}
 
 	// with compression on, reconcile a crash-interrupted gzip: a stale plaintext left
@@ -279,27 +279,200 @@ func RotatingFileHandler(folder, prefix string, opts ...RotatingFileOption) io.W
 	// is order-independent cleanup; it is skipped entirely with compression off, keeping
 	// seedErr byte-identical to the plain handler.
 	if cfg.compress && !cfg.freshStart {
-		seedErr = errors.Join(reconcileCompressed(folder, prefix), seedErr)
+		seedErr = errors.Join(reconcileCompressed(folder, prefix, cfg.naming), seedErr)
+	}
+	if levelErr != nil {
+		seedErr = errors.Join(seedErr, levelErr)
+	}
+	if cfg.symlink != "" {
+		if e := checkSymlinkName(cfg.symlink, prefix, cfg.naming); e != nil {
+			seedErr = errors.Join(seedErr, e)
+			cfg.symlink = ""
+		}
+	}
+
+	// stableBackupBase picks the name the stable live file is renamed to on rotation,
+	// never clobbering a backup left by a crash or an earlier run.
+	stableBackupBase := func() string {
+		if cfg.naming.timestamp {
+			base, _ := cfg.naming.nextBackupName(folder, prefix, time.Now())
+			return base
+		}
+		for backupExists(folder, prefix, index, cfg.compress) {
+			index++
+		}
+		return rotatingFileName(prefix, index)
+	}
+
+	if aead != nil && !cfg.freshStart {
+		// records are only ever appended to a file that holds records: a plaintext live
+		// file from before encryption is left behind as a backup, and a record torn by a
+		// crash is cut off so the next one starts on a boundary.
+		live := filepath.Join(folder, liveFileName(prefix, index, cfg.stableName))
+		sealed, e := isRecordFile(live)
+		fi, statErr := os.Stat(live)
+		switch {
+		case e != nil && !os.IsNotExist(e):
+			seedErr = errors.Join(seedErr, e)
+		case statErr != nil:
+		case sealed:
+			fileSize, e = repairRecordTail(live)
+			seedErr = errors.Join(seedErr, e)
+		case fi.Size() > 0 && cfg.stableName:
+			seedErr = errors.Join(seedErr, os.Rename(live, filepath.Join(folder, stableBackupBase())))
+			index++
+			fileSize = 0
+		case fi.Size() > 0:
+			index++
+			fileSize = 0
+		}
+	}
+
+	if plock != nil {
+		// a peer may have rotated to an index that holds no file yet, which the disk scan
+		// cannot see; the index recorded in the lock file wins when it is further along.
+		if idx, ok, e := plock.readIndex(); e != nil {
+			seedErr = errors.Join(seedErr, e)
+		} else if ok && idx > index && !cfg.freshStart {
+			index = idx
+			if !cfg.stableName {
+				fileSize = 0
+				if fi, e := os.Stat(filepath.Join(folder, rotatingFileName(prefix, index))); e == nil {
+					fileSize = uint64(fi.Size())
+				}
+			}
+		}
+		seedErr = errors.Join(seedErr, plock.writeIndex(index))
+		plock.unlock()
+	} else if cfg.processLock {
+		// the lock could not be taken now (e.g. folder not created yet); every Write retries.
+		seedErr = errors.Join(seedErr, lockErr)
+		plock = newProcessLock(folder, prefix, cfg.fileMode)
 	}
 
 	fileName := liveFileName(prefix, index, cfg.stableName)
 
+	// linked is the live file the WithCurrentSymlink link last pointed at; relink retargets
+	// the link whenever fileName moves on, and is a no-op without the option.
+	linked := ""
+	relink := func() error {
+		if cfg.symlink == "" || linked == fileName {
+			return nil
+		}
+		if e := replaceSymlink(folder, cfg.symlink, fileName); e != nil {
+			return e
+		}
+		linked = fileName
+		return nil
+	}
+	// a folder that does not exist yet is not an error here; the first Write links it.
+	if e := relink(); e != nil && !os.IsNotExist(e) {
+		seedErr = errors.Join(seedErr, e)
+	}
+
+	// opened is when the handler began writing the current live file; a resumed file has
+	// no better record than the construction time. events and hold back WithOnRotate and
+	// WithDeferredPrune, compressor and asyncErr back WithAsyncCompress; all stay idle
+	// without their options.
+	opened := time.Now()
+	var events, compressor backgroundQueue
+	var hold rotationHold
+	var asyncErr errorSlot
+	// liveIndex mirrors index for the compressor goroutine, which prunes after the write
+	// path may already have rotated again and must never count the live file as a backup.
+	var liveIndex atomic.Int64
+	liveIndex.Store(int64(index))
+
+	// compress gzips a finished plaintext backup and returns the backup's final base name;
+	// when compression fails the plaintext stays and remains the backup.
+	compress := func(base string) (string, error) {
+		if e := compressFileWith(folder, base, cfg.fileMode, cfg.compressLevel, aead); e != nil {
+			return base, e
+		}
+		if cfg.anyDurable() {
+			// the .gz replaced the plaintext by rename and remove; make both stick.
+			return base + "." + gzipExtension, cfg.fsyncFolder(folder)
+		}
+		return base + "." + gzipExtension, nil
+	}
+
+	// held exempts a backup from pruning while WithDeferredPrune holds it and, in indexed
+	// mode, protects the live index and anything newer from a prune queued before the
+	// latest rotation.
+	held := func(base string) bool {
+		if hold.isHeld(base) {
+			return true
+		}
+		idx, _, ok := parseRotationIndex(base, prefix)
+		return !cfg.stableName && ok && idx >= int(liveIndex.Load())
+	}
+
+	// settle finishes a rotated backup once it is final on disk: it announces it to
+	// WithOnRotate (holding it first under WithDeferredPrune, so the prune below already
+	// sees the hold) and prunes. It runs inline under the handler mutex, or on the
+	// compressor goroutine under WithAsyncCompress.
+	settle := func(backupBase string, start, end time.Time) error {
+		if cfg.onRotate != nil && backupBase != "" {
+			if ev, ok := newRotationEvent(folder, backupBase, start, end); ok {
+				if cfg.deferPrune {
+					ev.done = hold.hold(backupBase)
+				}
+				events.push(func() { cfg.onRotate(ev) })
+			}
+		}
+
+		// zero-option handlers keep the original whole-folder verifyFiles pruning
+		// byte-for-byte; any opt-in (age, compression, stable name, rotation callback, current
+		// symlink) uses the richer prune that understands .gz pairs, the age cutoff, held
+		// backups, and the live-file exclusion, and never counts the link as a backup.
+		if cfg.plainPrune() {
+			return verifyFiles(folder, cfg.maxFiles)
+		}
+		live := liveFileName(prefix, int(liveIndex.Load()), cfg.stableName)
+		return pruneRotationExcept(folder, prefix, cfg, live, time.Now(), held)
+	}
+
+	// with background compression, plaintext backups left by a run that stopped before
+	// its compressions finished are queued again; the live file is never touched. Nothing
+	// on disk marks a backup as pending, so a backup from a run without compression is
+	// queued too (documented on WithAsyncCompress).
+	if cfg.asyncCompress && !cfg.freshStart {
+		pending, e := listBackups(folder, prefix, cfg.naming, false, fileName)
+		seedErr = errors.Join(seedErr, e)
+		for _, g := range pending {
+			base := filepath.Base(g.paths[0])
+			compressor.push(func() {
+				if plock != nil {
+					if e := plock.lock(); e != nil {
+						asyncErr.add(e)
+						return
+					}
+					defer plock.unlock()
+				}
+				_, e := compress(base)
+				asyncErr.add(e)
+			})
+		}
+	}
+
 	// rotate advances the ring one step and prunes; it mutates index/fileName/fileSize in
 	// place under the handler mutex. Split out of the Write closure only for readability.
 	rotate := func() error {
 		var err error
+		// plainBase is the rotated backup's plaintext base name; it stays empty when the
+		// rotation left no backup behind.
+		plainBase := ""
 		if cfg.stableName {
-			// never clobber a pre-existing backup left by a crash or an earlier run.
-			for backupExists(folder, prefix, index, cfg.compress) {
-				index++
-			}
-			backupBase := rotatingFileName(prefix, index)
+			base := stableBackupBase()
 			src := filepath.Join(folder, fileName)
 			if _, e := os.Stat(src); e == nil {
-				if e := os.Rename(src, filepath.Join(folder, backupBase)); e != nil {
+				if e := os.Rename(src, filepath.Join(folder, base)); e != nil {
 					err = errors.Join(err, e)
-				} else if cfg.compress {
-					err = errors.Join(err, compressFile(folder, backupBase, cfg.fileMode))
+				} else {
+					plainBase = base
+					if cfg.anyDurable() {
+						err = errors.Join(err, cfg.fsyncFolder(folder))
+					}
 				}
 			} else if !os.IsNotExist(e) {
 				err = errors.Join(err, e)
@@ -308,11 +481,8 @@ func RotatingFileHandler(folder, prefix string, opts ...RotatingFileOption) io.W
 			fileSize = 0
 			// fileName stays the fixed prefix.log live path.
 		} else {
-			oldIndex := index
+			plainBase = rotatingFileName(prefix, index)
 			index++
-			if cfg.compress {
-				err = errors.Join(err, compressFile(folder, rotatingFileName(prefix, oldIndex), cfg.fileMode))
-			}
 			fileName = rotatingFileName(prefix, index)
 			// seed the new target's size from disk rather than assuming 0: when the
 			// handler rotates into a pre-existing higher-index file (from a prior run) an
@@ -325,32 +495,121 @@ func RotatingFileHandler(folder, prefix string, opts ...RotatingFileOption) io.W
 				err = errors.Join(err, e)
 			}
 		}
+		liveIndex.Store(int64(index))
+
+		start, end := opened, time.Now()
+		opened = end
+
+		if cfg.asyncCompress {
+			// compression, the event and the prune move off the write path. The single
+			// compressor goroutine runs jobs in order, so backups settle in rotation order.
+			compressor.push(func() {
+				if plock != nil {
+					if e := plock.lock(); e != nil {
+						asyncErr.add(e)
+						return
+					}
+					defer plock.unlock()
+				}
+				backupBase := plainBase
+				if plainBase != "" {
+					var e error
+					backupBase, e = compress(plainBase)
+					asyncErr.add(e)
+				}
+				asyncErr.add(settle(backupBase, start, end))
+			})
+			return err
+		}
 
-		// zero-option handlers keep the original whole-folder verifyFiles pruning
-		// byte-for-byte; any opt-in (age, compression, stable name) uses the richer prune
-		// that understands .gz pairs, the age cutoff, and the live-file exclusion.
-		if !cfg.stableName && cfg.maxAge <= 0 && !cfg.compress {
-			err = errors.Join(err, verifyFiles(folder, cfg.maxFiles))
-		} else {
-			err = errors.Join(err, pruneRotation(folder, prefix, cfg, filepath.Base(fileName), time.Now()))
+		backupBase := plainBase
+		if cfg.compress && plainBase != "" {
+			var e error
+			backupBase, e = compress(plainBase)
+			err = errors.Join(err, e)
 		}
-		return err
+		return errors.Join(err, settle(backupBase, start, end))
 	}
 
-	w := &writer{
-		h: func(msg []byte) (int, error) {
-			// surface any construction-time stat/truncate error on the first Write,
-			// then clear it.
-			err := seedErr
-			seedErr = nil
-
-			f, openErr := os.OpenFile(filepath.Join(folder, fileName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, cfg.fileMode)
-			if openErr != nil {
-				return 0, errors.Join(err, openErr)
+	var fileHeader func(fresh bool) []byte
+	folderPending := false
+	// write appends msg to the live file and rotates when it is full; durable fsyncs the
+	// file, and the folder when a file was created since its last fsync, before it returns.
+	write := func(msg []byte, durable bool) (int, error) {
+		// surface any construction-time stat/truncate error on the first Write,
+		// then clear it.
+		err := seedErr
+		seedErr = nil
+		// a background compression or prune that failed since the last Write reports
+		// here, the first place a caller can see it.
+		if e := asyncErr.take(); e != nil {
+			err = errors.Join(err, e)
+		}
+
+		if plock != nil {
+			if e := plock.lock(); e != nil {
+				return 0, errors.Join(err, e)
+			}
+			defer plock.unlock()
+			// re-read the shared index: another process may have rotated since this one
+			// last wrote, and this Write must follow it instead of reviving an old index.
+			if idx, ok, e := plock.readIndex(); e != nil {
+				err = errors.Join(err, e)
+			} else if ok && idx != index {
+				index = idx
+				fileName = liveFileName(prefix, index, cfg.stableName)
+				liveIndex.Store(int64(index))
+			}
+		}
+		if e := relink(); e != nil {
+			err = errors.Join(err, e)
+		}
+
+		// AuditHandler opens every file with a header carrying its chain on.
+		if fileHeader != nil {
+			if hdr := fileHeader(fileSize == 0); hdr != nil {
+				msg = append(append(hdr, '\n'), msg...)
 			}
+		}
 
-			var l uint64 = 0
+		// a file a Write creates needs its folder entry made durable too, by this Write or
+		// the first durable one after it.
+		if cfg.anyDurable() {
+			if _, e := os.Stat(filepath.Join(folder, fileName)); os.IsNotExist(e) {
+				folderPending = true
+			}
+		}
 
+		// under encryption the live file is read too, to learn where its records stand.
+		flags := os.O_WRONLY
+		if appender != nil {
+			flags = os.O_RDWR
+		}
+		f, openErr := os.OpenFile(filepath.Join(folder, fileName), flags|os.O_CREATE|os.O_APPEND, cfg.fileMode)
+		if openErr != nil {
+			return 0, errors.Join(err, openErr)
+		}
+
+		var l uint64 = 0
+		// reported is what the caller is told was written: the message bytes, which
+		// differ from the bytes on disk only under encryption.
+		reported := -1
+
+		if appender != nil {
+			// the message goes out as records in a single write, so a crash tears at
+			// most the last record.
+			line := append(append([]byte(nil), bytes.TrimSpace(msg)...), '\n')
+			if sealed, e := appender.seal(f, line); e != nil {
+				err = errors.Join(err, e)
+			} else if n, e := f.Write(sealed); e != nil {
+				err = errors.Join(err, e)
+				appender.lost()
+				l += uint64(n)
+			} else {
+				l += uint64(n)
+				reported = len(line)
+			}
+		} else {
 			if n, e := f.Write(bytes.TrimSpace(msg)); e != nil {
 				err = errors.Join(err, e)
 			} else {
@@ -362,20 +621,62 @@ func RotatingFileHandler(folder, prefix string, opts ...RotatingFileOption) io.W
 			} else {
 				l += uint64(n)
 			}
+		}
+		if reported < 0 {
+			reported = int(l)
+		}
 
-			// close the live file before any rotation: a stable-name rename and the gzip
-			// pass both need the descriptor released first.
-			if e := f.Close(); e != nil {
+		// other processes append to the same file under WithProcessLock, so a local
+		// counter undercounts; the descriptor's size is the shared truth.
+		if plock != nil {
+			if fi, e := f.Stat(); e == nil {
+				fileSize = uint64(fi.Size()) - l
+			}
+		}
+
+		if durable {
+			if e := cfg.fsync(f); e != nil {
+				err = errors.Join(err, e)
+			}
+		}
+
+		// close the live file before any rotation: a stable-name rename and the gzip
+		// pass both need the descriptor released first.
+		if e := f.Close(); e != nil {
+			err = errors.Join(err, e)
+		}
+		if durable && folderPending {
+			if e := cfg.fsyncFolder(folder); e != nil {
 				err = errors.Join(err, e)
+			} else {
+				folderPending = false
 			}
+		}
 
-			fileSize += l
+		fileSize += l
 
-			if fileSize > uint64(cfg.maxFileSize) {
-				err = errors.Join(err, rotate())
+		if fileSize > uint64(cfg.maxFileSize) {
+			err = errors.Join(err, rotate())
+			err = errors.Join(err, relink())
+			if plock != nil {
+				err = errors.Join(err, plock.writeIndex(index))
 			}
+		}
 
-			return int(l), err
+		return reported, err
+	}
+
+	w := &writer{
+		audit: &auditHost{folder: folder, prefix: prefix, naming: cfg.naming, aead: aead, header: &fileHeader},
+		h:     func(msg []byte) (int, error) { return write(msg, cfg.durable) },
+		hl:    func(level LogLevel, msg []byte) (int, error) { return write(msg, cfg.durableFor(level)) },
+		// Close waits for pending background compressions, then for the WithOnRotate
+		// callbacks they queue, and reports any background error not yet surfaced by a
+		// Write. The handler stays usable after.
+		closer: func() error {
+			compressor.wait()
+			events.wait()
+			return asyncErr.take()
 		},
 	}
 	return w
@@ -388,7 +689,9 @@ func RotatingFileHandler(folder, prefix string, opts ...RotatingFileOption) io.W
 // No fresh-start variant exists for this handler; see RotatingFileHandler's
 // WithFreshStart for an analogous feature on the index-based handler (there the
 // construction-time target is known, whereas here the name is generated per-write
-// by fileNameGenerator).
+// by fileNameGenerator). Retention counts every *.log in folder, so two handlers
+// sharing a folder prune each other's files; FileByFormatHandlerWithOptions scopes it to
+// a name pattern and adds the file mode, compression and age options.
 func FileByFormatHandler(folder string, maxFilesInFolder int, fileNameGenerator func() string) io.Writer {
 	lastFileName := ""
 	w := &writer{
@@ -526,39 +829,52 @@ func newTimestampedWriter(cfg printConfig) io.Writer {
 	const sep = " "
 	indent := strings.Repeat(" ", len(time.Time{}.Format(cfg.layout))+len(sep))
 
-	return &writer{
-		h: func(msg []byte) (int, error) {
-			ts := cfg.clock().Format(cfg.layout)
+	render := func(msg []byte) string {
+		ts := cfg.clock().Format(cfg.layout)
 
-			trimmed := bytes.TrimSpace(msg)
+		trimmed := bytes.TrimSpace(msg)
 
-			var b strings.Builder
+		var b strings.Builder
 
-			// fast-path: single-line message (no '\n' in trimmed body).
-			if bytes.IndexByte(trimmed, '\n') < 0 {
-				b.WriteString(ts)
-				if len(trimmed) > 0 {
-					b.WriteString(sep)
-					b.Write(trimmed)
-				}
-				b.WriteByte('\n')
-			} else {
-				body := string(trimmed)
-				lines := strings.Split(body, "\n")
-				b.WriteString(ts)
-				if lines[0] != "" {
-					b.WriteString(sep)
-					b.WriteString(lines[0])
-				}
+		// fast-path: single-line message (no '\n' in trimmed body).
+		if bytes.IndexByte(trimmed, '\n') < 0 {
+			b.WriteString(ts)
+			if len(trimmed) > 0 {
+				b.WriteString(sep)
+				b.Write(trimmed)
+			}
+			b.WriteByte('\n')
+		} else {
+			body := string(trimmed)
+			lines := strings.Split(body, "\n")
+			b.WriteString(ts)
+			if lines[0] != "" {
+				b.WriteString(sep)
+				b.WriteString(lines[0])
+			}
+			b.WriteByte('\n')
+			for _, ln := range lines[1:] {
+				b.WriteString(indent)
+				b.WriteString(ln)
 				b.WriteByte('\n')
-				for _, ln := range lines[1:] {
-					b.WriteString(indent)
-					b.WriteString(ln)
-					b.WriteByte('\n')
-				}
 			}
+		}
+		return b.String()
+	}
 
-			if _, err := io.WriteString(cfg.out, b.String()); err != nil {
+	return &writer{
+		stamped: true,
+		// a wrapped handler of this package (e.g. a RotatingFileHandler) may have background
+		// work of its own; closing the wrapper closes it.
+		closer: func() error { return closeHandler(cfg.out) },
+		h: func(msg []byte) (int, error) {
+			if _, err := io.WriteString(cfg.out, render(msg)); err != nil {
+				return 0, err
+			}
+			return len(msg), nil
+		},
+		hl: func(level LogLevel, msg []byte) (int, error) {
+			if _, err := writeLevel(cfg.out, level, []byte(render(msg))); err != nil {
 				return 0, err
 			}
 			return len(msg), nil
@@ -585,13 +901,32 @@ func PrintHandler() io.Writer {
 
 // rotatingFileConfig holds the resolved configuration for RotatingFileHandler.
 type rotatingFileConfig struct {
-	maxFileSize uint32
-	maxFiles    int
-	freshStart  bool
-	stableName  bool          // WithStableCurrentName: live file is the fixed prefix.log.
-	maxAge      time.Duration // WithMaxAge: prune backups older than this; <= 0 disables.
-	compress    bool          // WithCompress: gzip rotated backups to prefix.<idx>.log.gz.
-	fileMode    os.FileMode   // WithFileMode: perms for files this handler CREATES; seeded to defaultFilePermissions.
+	maxFileSize   uint32
+	maxFiles      int
+	freshStart    bool
+	stableName    bool                // WithStableCurrentName: live file is the fixed prefix.log.
+	maxAge        time.Duration       // WithMaxAge: prune backups older than this; <= 0 disables.
+	compress      bool                // WithCompress: gzip rotated backups to prefix.<idx>.log.gz.
+	fileMode      os.FileMode         // WithFileMode: perms for files this handler CREATES; seeded to defaultFilePermissions.
+	onRotate      func(RotationEvent) // WithOnRotate: per-backup callback, run off the write path.
+	deferPrune    bool                // WithDeferredPrune: hold reported backups until RotationEvent.Done.
+	asyncCompress bool                // WithAsyncCompress: gzip on a background goroutine instead of under the mutex.
+	compressLevel int                 // WithCompressLevel: gzip level; seeded to gzip.DefaultCompression.
+	processLock   bool                // WithProcessLock: flock prefix.lock around writes and rotations.
+	naming        BackupNaming        // WithBackupNaming: backup name scheme; the zero value is IndexedBackups.
+	symlink       string              // WithCurrentSymlink: name of the link kept pointing at the live file; "" disables.
+	scopedPrune   bool                // withScopedPrune: prune only prefix's backups even with no other option set.
+	key           []byte              // WithEncryption: AES key the files are sealed with; nil writes plaintext.
+	durable       bool                // WithDurableWrites: fsync every Write, and the folder on rotation.
+	durableMin    LogLevel            // WithDurableMinLevel: lowest level fsynced.
+	durableMinSet bool                // disambiguates an explicit zero durableMin from unset.
+	onSync        func(path string)   // withSyncObserver: test seam seeing every fsync.
+}
+
+// plainPrune reports whether the handler runs with none of the opt-in options that need
+// the prefix-aware pruneRotation, so it keeps the historical whole-folder verifyFiles.
+func (c rotatingFileConfig) plainPrune() bool {
+	return !c.stableName && c.maxAge <= 0 && !c.compress && c.onRotate == nil && c.symlink == "" && !c.scopedPrune
 }
 
 // rotatingFileName renders the on-disk name for a given rotation index, e.g.
@@ -691,7 +1026,7 @@ func resetRotation(folder, prefix string, cfg rotatingFileConfig) error {
 		return err
 	}
 	for _, p := range matches {
-		if _, _, ok := parseRotationIndex(filepath.Base(p), prefix); !ok {
+		if _, _, ok := cfg.naming.parseBackup(filepath.Base(p), prefix); !ok {
 			continue
 		}
 		err = errors.Join(err, removeIfExists(p))
@@ -701,7 +1036,7 @@ func resetRotation(folder, prefix string, cfg rotatingFileConfig) error {
 		err = errors.Join(err, removeIfExists(filepath.Join(folder, stableLiveName(prefix))))
 	}
 	if cfg.compress {
-		err = errors.Join(err, removeCompressTemps(folder, prefix))
+		err = errors.Join(err, removeCompressTemps(folder, prefix, cfg.naming))
 	}
 	return err
 }
@@ -808,10 +1143,22 @@ func backupExists(folder, prefix string, index int, includeGz bool) bool {
 // the newest modification time among them, used by the age prune so a pair is aged as a
 // unit. The index is the map key in listBackups, so it is not repeated here.
 type backupEntry struct {
+	key   int    // the scheme's ordering key: the index, or the Unix second of a timestamp.
+	name  string // the plaintext base name, shared by a .gz sibling.
 	paths []string
 	mtime time.Time
 }
 
+// heldBy reports whether held claims any of the entry's files by base name.
+func (b *backupEntry) heldBy(held func(base string) bool) bool {
+	for _, p := range b.paths {
+		if held(filepath.Base(p)) {
+			return true
+		}
+	}
+	return false
+}
+
 // pruneRotation enforces the retention bounds on prefix's rotated backups in folder: first
 // age (when cfg.maxAge > 0, any backup whose mtime precedes now-maxAge is removed), then
 // count (keep only the newest cfg.maxFiles-1 backups, since the live file is the +1). The
@@ -820,36 +1167,53 @@ type backupEntry struct {
 // as a single backup and is removed together. .gz files are considered only when
 // cfg.compress is set.
 func pruneRotation(folder, prefix string, cfg rotatingFileConfig, liveBase string, now time.Time) error {
-	groups, err := listBackups(folder, prefix, cfg.compress, liveBase)
+	return pruneRotationExcept(folder, prefix, cfg, liveBase, now, nil)
+}
+
+// pruneRotationExcept is pruneRotation with a held filter: a backup any of whose files
+// held reports true for (by base name) is neither removed nor counted toward the bounds.
+// A nil held holds nothing. It backs WithDeferredPrune.
+func pruneRotationExcept(folder, prefix string, cfg rotatingFileConfig, liveBase string, now time.Time, held func(base string) bool) error {
+	groups, err := listBackups(folder, prefix, cfg.naming, cfg.compress, liveBase)
 	if err != nil {
 		return err
 	}
 
-	indices := make([]int, 0, len(groups))
-	for i := range groups {
-		indices = append(indices, i)
+	ordered := make([]*backupEntry, 0, len(groups)) // oldest data first.
+	for _, g := range groups {
+		if held != nil && g.heldBy(held) {
+			continue
+		}
+		ordered = append(ordered, g)
 	}
-	sort.Ints(indices) // ascending index == oldest data first.
+	return pruneOrdered(ordered, cfg, now)
+}
 
-	remove := func(idx int) {
-		for _, p := range groups[idx].paths {
+// pruneOrdered applies the retention bounds to backups ordered oldest first: first age
+// (when cfg.maxAge > 0, any backup whose mtime precedes now-maxAge is removed), then count
+// (keep only the newest cfg.maxFiles-1, since the live file is the +1). Every file of a
+// removed backup goes together.
+func pruneOrdered(backups []*backupEntry, cfg rotatingFileConfig, now time.Time) error {
+	var err error
+	remove := func(b *backupEntry) {
+		for _, p := range b.paths {
 			err = errors.Join(err, removeIfExists(p))
 		}
 	}
 
 	// age phase: drop everything older than the cutoff, keeping the survivors in order.
-	survivors := make([]int, 0, len(indices))
+	survivors := make([]*backupEntry, 0, len(backups))
 	if cfg.maxAge > 0 {
 		cutoff := now.Add(-cfg.maxAge)
-		for _, i := range indices {
-			if groups[i].mtime.Before(cutoff) {
-				remove(i)
+		for _, b := range backups {
+			if b.mtime.Before(cutoff) {
+				remove(b)
 				continue
 			}
-			survivors = append(survivors, i)
+			survivors = append(survivors, b)
 		}
 	} else {
-		survivors = append(survivors, indices...)
+		survivors = append(survivors, backups...)
 	}
 
 	// count phase: keep the newest maxFiles-1 backups (the live file is the +1).
@@ -863,22 +1227,25 @@ func pruneRotation(folder, prefix string, cfg rotatingFileConfig, liveBase strin
 	return err
 }
 
-// listBackups groups prefix's rotated backup files in folder by rotation index, skipping
-// the live file (excludeBase) and any non-matching name. .gz siblings are included only
-// when includeGz is set, preserving the byte-identical zero-option contract. Files that
-// vanish between the glob and the stat are ignored (nothing left to prune).
-func listBackups(folder, prefix string, includeGz bool, excludeBase string) (map[int]*backupEntry, error) {
+// listBackups groups prefix's rotated backup files in folder by backup, skipping the live
+// file (excludeBase) and any non-matching name, and returns them oldest first: by the
+// scheme's ordering key, then by name. A backup is its plaintext name with any .gz sibling,
+// so backups sharing a key — TimestampBackups WithPID names of two processes rotating in
+// the same second — stay apart. .gz siblings are included only when includeGz is set,
+// preserving the byte-identical zero-option contract. Files that vanish between the glob
+// and the stat are ignored (nothing left to prune).
+func listBackups(folder, prefix string, naming BackupNaming, includeGz bool, excludeBase string) ([]*backupEntry, error) {
 	matches, err := globRotation(folder, includeGz)
 	if err != nil {
 		return nil, err
 	}
-	groups := make(map[int]*backupEntry)
+	groups := make(map[string]*backupEntry)
 	for _, p := range matches {
 		base := filepath.Base(p)
 		if base == excludeBase {
 			continue
 		}
-		idx, _, ok := parseRotationIndex(base, prefix)
+		key, _, ok := naming.parseBackup(base, prefix)
 		if !ok {
 			continue
 		}
@@ -886,17 +1253,29 @@ func listBackups(folder, prefix string, includeGz bool, excludeBase string) (map
 		if e != nil {
 			continue
 		}
-		g := groups[idx]
+		name := strings.TrimSuffix(base, "."+gzipExtension)
+		g := groups[name]
 		if g == nil {
-			g = &backupEntry{}
-			groups[idx] = g
+			g = &backupEntry{key: key, name: name}
+			groups[name] = g
 		}
 		g.paths = append(g.paths, p)
 		if fi.ModTime().After(g.mtime) {
 			g.mtime = fi.ModTime()
 		}
 	}
-	return groups, nil
+
+	ordered := make([]*backupEntry, 0, len(groups))
+	for _, g := range groups {
+		ordered = append(ordered, g)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].key != ordered[j].key {
+			return ordered[i].key < ordered[j].key
+		}
+		return ordered[i].name < ordered[j].name
+	})
+	return ordered, nil
 }
 
 // compressFile gzips folder/base (a rotated plaintext prefix.<idx>.log) to
@@ -905,9 +1284,23 @@ func listBackups(folder, prefix string, includeGz bool, excludeBase string) (map
 // pre-planted symlink), flushes and fsyncs, then renames the temp into place before
 // removing the plaintext — so a reader of *.log.gz never sees a partial file. mode is the
 // handler's configured file mode (default 0640, overridable via WithFileMode); the process
-// umask still masks it. The .gz keeps the source file's mtime (os.Chtimes) so age pruning
-// stays honest. A missing source is a no-op.
+// umask still masks it. The .gz keeps the source file's mtime (os.Chtimes, applied before
+// the rename) so age pruning stays honest. A missing source is a no-op.
 func compressFile(folder, base string, mode os.FileMode) error {
+	return compressFileLevel(folder, base, mode, gzip.DefaultCompression)
+}
+
+// compressFileLevel is compressFile at an explicit gzip level (WithCompressLevel); the
+// level must already be valid for gzip.NewWriterLevel.
+func compressFileLevel(folder, base string, mode os.FileMode, level int) error {
+	return compressFileWith(folder, base, mode, level, nil)
+}
+
+// compressFileWith is compressFileLevel for a handler under WithEncryption when aead is
+// not nil: the backup's records are decrypted, gzipped, and the gzip stream is sealed
+// into records again, so compression still works on the plaintext. A backup that holds no
+// records is gzipped as plaintext.
+func compressFileWith(folder, base string, mode os.FileMode, level int, aead cipher.AEAD) error {
 	src := filepath.Join(folder, base)
 	fi, err := os.Stat(src)
 	if err != nil {
@@ -930,8 +1323,31 @@ func compressFile(folder, base string, mode os.FileMode) error {
 		return err
 	}
 
-	zw := gzip.NewWriter(out)
-	if _, e := io.Copy(zw, in); e != nil {
+	if aead != nil && fi.Size() > 0 {
+		// a plaintext backup from before encryption was enabled is compressed as it is;
+		// decrypting it would yield nothing and lose it.
+		if sealed, e := isRecordFile(src); e != nil {
+			_ = out.Close()
+			return errors.Join(e, removeIfExists(tmp))
+		} else if !sealed {
+			aead = nil
+		}
+	}
+	var plain io.Reader = in
+	var sink io.Writer = out
+	var seal *sealWriter
+	if aead != nil {
+		plain = &decryptingReader{src: in, aead: aead}
+		seal = &sealWriter{w: out, sealer: recordSealer{aead: aead}}
+		sink = seal
+	}
+
+	zw, err := gzip.NewWriterLevel(sink, level)
+	if err != nil {
+		_ = out.Close()
+		return errors.Join(err, removeIfExists(tmp))
+	}
+	if _, e := io.Copy(zw, plain); e != nil {
 		_ = zw.Close()
 		_ = out.Close()
 		return errors.Join(e, removeIfExists(tmp))
@@ -940,6 +1356,12 @@ func compressFile(folder, base string, mode os.FileMode) error {
 		_ = out.Close()
 		return errors.Join(e, removeIfExists(tmp))
 	}
+	if seal != nil {
+		if e := seal.Close(); e != nil {
+			_ = out.Close()
+			return errors.Join(e, removeIfExists(tmp))
+		}
+	}
 	if e := out.Sync(); e != nil {
 		_ = out.Close()
 		return errors.Join(e, removeIfExists(tmp))
@@ -947,23 +1369,25 @@ func compressFile(folder, base string, mode os.FileMode) error {
 	if e := out.Close(); e != nil {
 		return errors.Join(e, removeIfExists(tmp))
 	}
+	// preserve the source mtime on the .gz so WithMaxAge still ages it out correctly; it
+	// is set before the rename so the .gz never shows up with any other mtime.
+	err = os.Chtimes(tmp, time.Now(), fi.ModTime())
 	if e := os.Rename(tmp, dst); e != nil {
-		return errors.Join(e, removeIfExists(tmp))
+		return errors.Join(err, e, removeIfExists(tmp))
 	}
 
-	// preserve the source mtime on the .gz so WithMaxAge still ages it out correctly,
-	// then drop the plaintext now that the compressed copy is durable.
-	err = errors.Join(err, os.Chtimes(dst, time.Now(), fi.ModTime()))
+	// drop the plaintext now that the compressed copy is durable.
 	err = errors.Join(err, removeIfExists(src))
 	return err
 }
 
 // reconcileCompressed heals a crash-interrupted compression at construction: it removes
-// orphaned prefix.*.log.gz.tmp temps and, wherever both prefix.<idx>.log and its .gz exist,
-// discards the plaintext (the durable .gz is authoritative). It only runs under
-// WithCompress, so a plain handler never touches .gz files.
-func reconcileCompressed(folder, prefix string) error {
-	err := removeCompressTemps(folder, prefix)
+// orphaned prefix.*.log.gz.tmp temps and, wherever both a backup's plaintext and its .gz
+// exist, discards the plaintext (the durable .gz is authoritative). Backups are recognised
+// under naming, so timestamped backups heal the same way. It only runs under WithCompress,
+// so a plain handler never touches .gz files.
+func reconcileCompressed(folder, prefix string, naming BackupNaming) error {
+	err := removeCompressTemps(folder, prefix, naming)
 
 	// a leftover plaintext beside a complete .gz is a crash remnant; the .gz wins.
 	logs, e := globRotation(folder, false)
@@ -971,12 +1395,10 @@ func reconcileCompressed(folder, prefix string) error {
 		return errors.Join(err, e)
 	}
 	for _, p := range logs {
-		idx, gz, ok := parseRotationIndex(filepath.Base(p), prefix)
-		if !ok || gz {
+		if _, gz, ok := naming.parseBackup(filepath.Base(p), prefix); !ok || gz {
 			continue
 		}
-		gzPath := filepath.Join(folder, rotatingFileName(prefix, idx)+"."+gzipExtension)
-		if _, statErr := os.Stat(gzPath); statErr == nil {
+		if _, statErr := os.Stat(p + "." + gzipExtension); statErr == nil {
 			err = errors.Join(err, removeIfExists(p))
 		}
 	}
@@ -984,15 +1406,17 @@ func reconcileCompressed(folder, prefix string) error {
 }
 
 // removeCompressTemps deletes stale prefix.*.log.gz.tmp files left by an interrupted
-// compression. It globs the literal temp pattern and filters by prefix, so metacharacters
-// in prefix cannot corrupt the match set.
-func removeCompressTemps(folder, prefix string) error {
+// compression, plus the temps of backups named under naming. It globs the literal temp
+// pattern and filters by prefix, so metacharacters in prefix cannot corrupt the match set.
+func removeCompressTemps(folder, prefix string, naming BackupNaming) error {
 	tmps, err := filepath.Glob(filepath.Join(folder, "*."+defaultFileExtension+"."+gzipExtension+"."+tmpExtension))
 	if err != nil {
 		return err
 	}
 	for _, p := range tmps {
-		if !strings.HasPrefix(filepath.Base(p), prefix+".") {
+		base := filepath.Base(p)
+		backup := strings.TrimSuffix(base, "."+tmpExtension)
+		if _, _, ok := naming.parseBackup(backup, prefix); !ok && !strings.HasPrefix(base, prefix+".") {
 			continue
 		}
 		err = errors.Join(err, removeIfExists(p))
@@ -1034,6 +1458,12 @@ type leveledWriter struct {
 
 func (lw *leveledWriter) Write(p []byte) (int, error) { return lw.inner.Write(p) }
 func (lw *leveledWriter) MinLevel() LogLevel          { return lw.level }
+func (lw *leveledWriter) WriteLevel(level LogLevel, p []byte) (int, error) {
+	return writeLevel(lw.inner, level, p)
+}
+func (lw *leveledWriter) WriteRecord(r Record) (int, error) {
+	return writeRecord(lw.inner, r)
+}
 
 var _ LeveledHandler = (*leveledWriter)(nil)
 
@@ -1041,7 +1471,15 @@ var _ LeveledHandler = (*leveledWriter)(nil)
 type writer struct {
 	m        sync.Mutex
 	h        func(msg []byte) (n int, err error)
-	original io.Writer // the unwrapped sink; set by ensureThreadSafe for unwrapLeveled.
+	original io.Writer    // the unwrapped sink; set by ensureThreadSafe for unwrapLeveled.
+	closer   func() error // releases background work; nil for handlers that have none.
+	audit    *auditHost   // set by RotatingFileHandler for AuditHandler; nil otherwise.
+	stamped  bool         // set by TimestampedHandler, which adds to every message.
+	// hl serves WriteLevel for handlers that use or pass on the level; nil falls back to h.
+	hl func(level LogLevel, msg []byte) (n int, err error)
+	// hr serves WriteRecord for handlers that use the fields of a Record; nil falls back to
+	// WriteLevel with the Record's text.
+	hr func(r Record) (n int, err error)
 }
 
 // Write writes the message to the handler
@@ -1050,3 +1488,33 @@ func (w *writer) Write(p []byte) (n int, err error) {
 	defer w.m.Unlock()
 	return w.h(p)
 }
+
+// WriteLevel writes the message to the handler, passing its level on to handlers that
+// use it.
+func (w *writer) WriteLevel(level LogLevel, p []byte) (n int, err error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.hl != nil {
+		return w.hl(level, p)
+	}
+	return w.h(p)
+}
+
+// WriteRecord writes the record to the handler, as its text at its level unless the
+// handler uses the record's fields.
+func (w *writer) WriteRecord(r Record) (n int, err error) {
+	if w.hr == nil {
+		return w.WriteLevel(r.Level, r.Text())
+	}
+	w.m.Lock()
+	defer w.m.Unlock()
+	return w.hr(r)
+}
+
+// Close waits for the handler's background work (e.g. WithOnRotate callbacks) to finish.
+// It never closes a caller-supplied sink such as os.Stdout or a user io.Writer, and it is
+// a no-op for handlers that run nothing in the background. Close does not take the write
+// mutex, so a background job that logs through the same handler cannot deadlock it.
+func (w *writer) Close() error {
+	return closeHandler(w)
+}
@@ -0,0 +1,175 @@
+package loginjector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// JournaldOption configures JournaldHandler.
+type JournaldOption func(*journaldConfig)
+
+type journaldConfig struct {
+	socket     string
+	priorities map[LogLevel]int
+	fields     []Attr // WithJournaldField: static fields, in option order.
+}
+
+// WithJournaldSocket sets the journal's native socket. The default is
+// /run/systemd/journal/socket.
+func WithJournaldSocket(path string) JournaldOption {
+	return func(c *journaldConfig) { c.socket = path }
+}
+
+// WithJournaldPriorities replaces the table mapping a LogLevel to the PRIORITY field, 0
+// (emergency) to 7 (debug). The default is SyslogHandler's: Debug to 7, Info to 6,
+// Warning to 4, Error to 3, Severe to 2 and Critical to 1.
+func WithJournaldPriorities(table map[LogLevel]int) JournaldOption {
+	return func(c *journaldConfig) { c.priorities = table }
+}
+
+// WithJournaldField adds a field to every entry, e.g. WithJournaldField("SERVICE",
+// "billing"). The name is normalised as attribute keys are. A SYSLOG_IDENTIFIER field
+// replaces the default one, the base name of the executable.
+func WithJournaldField(name, value string) JournaldOption {
+	return func(c *journaldConfig) { c.fields = append(c.fields, Attr{Key: name, Value: value}) }
+}
+
+// JournaldHandler writes every message to the systemd journal over its native datagram
+// protocol, as an entry with MESSAGE, PRIORITY and SYSLOG_IDENTIFIER fields. The level
+// picks PRIORITY through WithJournaldPriorities; a plain Write, and a level missing from
+// the table, is informational (6). A Record from Logger.Log adds its attributes as fields
+// and, when the caller is known, CODE_FILE, CODE_LINE and CODE_FUNC. Attribute keys are
+// upper-cased with every character other than A-Z, 0-9 and _ replaced by _, and a key
+// that would start with _ or a digit is prefixed with X, as journald requires.
+//
+// An entry too large for one datagram is written to a sealed memfd, or an unlinked temp
+// file where memfd is unavailable, whose descriptor is passed to journald instead, as
+// sd_journal_send does. A trailing newline of the message is dropped. JournaldHandler is
+// only available on Linux; elsewhere every Write fails.
+func JournaldHandler(opts ...JournaldOption) io.Writer {
+	cfg := journaldConfig{
+		socket:     "/run/systemd/journal/socket",
+		priorities: defaultSyslogSeverities,
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	addr := &net.UnixAddr{Name: cfg.socket, Net: "unixgram"}
+	var mu sync.Mutex
+	var conn *net.UnixConn
+
+	send := func(r Record, priority int) (int, error) {
+		entry := cfg.entry(r, priority)
+
+		mu.Lock()
+		defer mu.Unlock()
+		var err error
+		// a second try on a fresh socket rides out a journald restart.
+		for attempt := 0; attempt < 2; attempt++ {
+			if conn == nil {
+				if conn, err = net.DialUnix("unixgram", nil, addr); err != nil {
+					conn = nil
+					continue
+				}
+			}
+			_, err = conn.Write(entry)
+			if errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, syscall.ENOBUFS) {
+				err = journalSendFD(conn, entry)
+			}
+			if err == nil {
+				return len(r.Message), nil
+			}
+			_ = conn.Close()
+			conn = nil
+		}
+		return 0, fmt.Errorf("loginjector: journald: %w", err)
+	}
+	priority := func(level LogLevel) int {
+		if p, ok := cfg.priorities[level]; ok {
+			return p
+		}
+		return 6
+	}
+
+	return &writer{
+		h: func(msg []byte) (int, error) { return send(Record{Message: string(msg)}, 6) },
+		hl: func(level LogLevel, msg []byte) (int, error) {
+			return send(Record{Level: level, Message: string(msg)}, priority(level))
+		},
+		hr: func(r Record) (int, error) { return send(r, priority(r.Level)) },
+		closer: func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			if conn == nil {
+				return nil
+			}
+			err := conn.Close()
+			conn = nil
+			return err
+		},
+	}
+}
+
+// entry encodes r as a native protocol datagram.
+func (c *journaldConfig) entry(r Record, priority int) []byte {
+	var b bytes.Buffer
+	appendJournalField(&b, "MESSAGE", strings.TrimSuffix(r.Message, "\n"))
+	appendJournalField(&b, "PRIORITY", strconv.Itoa(min(max(priority, 0), 7)))
+	identifier := true
+	for _, f := range c.fields {
+		name := journalFieldName(f.Key)
+		identifier = identifier && name != "SYSLOG_IDENTIFIER"
+		appendJournalField(&b, name, fmt.Sprint(f.Value))
+	}
+	if identifier {
+		appendJournalField(&b, "SYSLOG_IDENTIFIER", filepath.Base(os.Args[0]))
+	}
+	if r.File != "" {
+		appendJournalField(&b, "CODE_FILE", r.File)
+		appendJournalField(&b, "CODE_LINE", strconv.Itoa(r.Line))
+	}
+	if r.Function != "" {
+		appendJournalField(&b, "CODE_FUNC", r.Function)
+	}
+	for _, a := range r.Attrs {
+		appendJournalField(&b, journalFieldName(a.Key), fmt.Sprint(a.Value))
+	}
+	return b.Bytes()
+}
+
+// appendJournalField encodes one field: NAME=value, or for a value holding a newline the
+// name, a newline, the value's length as a little-endian uint64, and the value.
+func appendJournalField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(name + "=" + value + "\n")
+		return
+	}
+	b.WriteString(name + "\n")
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// journalFieldName turns key into a valid journal field name.
+func journalFieldName(key string) string {
+	name := []byte(strings.ToUpper(key))
+	for i, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || name[0] == '_' || (name[0] >= '0' && name[0] <= '9') {
+		name = append([]byte("X"), name...)
+	}
+	return string(name)
+}
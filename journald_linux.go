@@ -0,0 +1,100 @@
+//go:build linux
+
+package loginjector
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// memfdCreate is the memfd_create system call number by GOARCH; the syscall package does
+// not define it everywhere. An architecture missing here uses the temp-file fallback.
+var memfdCreate = map[string]uintptr{
+	"386": 356, "amd64": 319, "arm": 385, "arm64": 279, "loong64": 279, "riscv64": 279,
+	"mips": 4354, "mipsle": 4354, "mips64": 5314, "mips64le": 5314,
+	"ppc64": 360, "ppc64le": 360, "s390x": 350,
+}
+
+const (
+	mfdCloexec       = 0x1
+	mfdAllowSealing  = 0x2
+	fAddSeals        = 1033
+	sealAll          = 0x1 | 0x2 | 0x4 | 0x8 // F_SEAL_SEAL, _SHRINK, _GROW and _WRITE.
+	journalMemfdName = "journal-message"
+)
+
+// journalSendFD passes entry to journald as a file descriptor: a sealed memfd when the
+// kernel offers one, else an unlinked file in /dev/shm or the temp folder.
+func journalSendFD(conn *net.UnixConn, entry []byte) error {
+	f, err := journalMemfd(entry)
+	if err != nil {
+		if f, err = journalTempFile(entry); err != nil {
+			return err
+		}
+	}
+	defer CloseOrLog(f)
+
+	// the socket is connected, which WriteMsgUnix refuses, so sendmsg is called directly.
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	rights := syscall.UnixRights(int(f.Fd()))
+	var sendErr error
+	err = rc.Write(func(fd uintptr) bool {
+		sendErr = syscall.Sendmsg(int(fd), nil, rights, nil, 0)
+		return sendErr != syscall.EAGAIN
+	})
+	if err != nil {
+		return err
+	}
+	return sendErr
+}
+
+// journalMemfd returns a sealed memfd holding entry.
+func journalMemfd(entry []byte) (*os.File, error) {
+	trap, ok := memfdCreate[runtime.GOARCH]
+	if !ok {
+		return nil, syscall.ENOSYS
+	}
+	name, err := syscall.BytePtrFromString(journalMemfdName)
+	if err != nil {
+		return nil, err
+	}
+	fd, _, errno := syscall.Syscall(trap, uintptr(unsafe.Pointer(name)), mfdCloexec|mfdAllowSealing, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	f := os.NewFile(fd, journalMemfdName)
+	if _, err := f.Write(entry); err != nil {
+		CloseOrLog(f)
+		return nil, err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, fAddSeals, sealAll); errno != 0 {
+		CloseOrLog(f)
+		return nil, errno
+	}
+	return f, nil
+}
+
+// journalTempFile returns an unlinked temp file holding entry.
+func journalTempFile(entry []byte) (*os.File, error) {
+	f, err := os.CreateTemp("/dev/shm", journalMemfdName+"-*")
+	if err != nil {
+		if f, err = os.CreateTemp("", journalMemfdName+"-*"); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.Remove(f.Name()); err != nil {
+		CloseOrLog(f)
+		return nil, err
+	}
+	if _, err := f.Write(entry); err != nil {
+		CloseOrLog(f)
+		return nil, err
+	}
+	return f, nil
+}
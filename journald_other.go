@@ -0,0 +1,13 @@
+//go:build !linux
+
+package loginjector
+
+import (
+	"errors"
+	"net"
+)
+
+// journalSendFD fails: passing an entry by file descriptor needs Linux, as journald does.
+func journalSendFD(*net.UnixConn, []byte) error {
+	return errors.New("loginjector: journald is only available on linux")
+}
@@ -0,0 +1,204 @@
+//go:build linux
+
+package loginjector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// journalStandIn is a unixgram socket standing in for journald's.
+type journalStandIn struct {
+	path string
+	conn *net.UnixConn
+}
+
+func newJournalStandIn(t *testing.T) *journalStandIn {
+	// a short path: unix socket paths are limited to about 100 bytes.
+	dir, err := os.MkdirTemp("", "jd")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return &journalStandIn{path: path, conn: conn}
+}
+
+// receive reads one entry, from the datagram or the descriptor passed with it, and
+// reports the passed file's /proc link target ("" for a plain datagram).
+func (j *journalStandIn) receive(t *testing.T) (fields map[string]string, passed string) {
+	t.Helper()
+	require.NoError(t, j.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf, oob := make([]byte, 1<<16), make([]byte, 1024)
+	n, oobn, _, _, err := j.conn.ReadMsgUnix(buf, oob)
+	require.NoError(t, err)
+	entry := buf[:n]
+	if oobn > 0 {
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		require.NoError(t, err)
+		fds, err := syscall.ParseUnixRights(&msgs[0])
+		require.NoError(t, err)
+		f := os.NewFile(uintptr(fds[0]), "passed")
+		defer f.Close()
+		passed, _ = os.Readlink("/proc/self/fd/" + strconv.Itoa(fds[0]))
+		_, err = f.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		entry, err = io.ReadAll(f)
+		require.NoError(t, err)
+	}
+	return parseJournalEntry(t, entry), passed
+}
+
+// parseJournalEntry decodes the native protocol fields of entry.
+func parseJournalEntry(t *testing.T, entry []byte) map[string]string {
+	t.Helper()
+	fields := make(map[string]string)
+	for len(entry) > 0 {
+		nl := bytes.IndexByte(entry, '\n')
+		require.GreaterOrEqual(t, nl, 0)
+		line := string(entry[:nl])
+		entry = entry[nl+1:]
+		if name, value, ok := strings.Cut(line, "="); ok {
+			fields[name] = value
+			continue
+		}
+		size := binary.LittleEndian.Uint64(entry[:8])
+		fields[line] = string(entry[8 : 8+size])
+		entry = entry[8+size+1:]
+	}
+	return fields
+}
+
+func TestJournaldHandler(t *testing.T) {
+	t.Parallel()
+
+	t.Run("a Record becomes an entry with code location and attributes", func(t *testing.T) {
+		t.Parallel()
+
+		j := newJournalStandIn(t)
+		h := JournaldHandler(WithJournaldSocket(j.path), WithJournaldField("service", "billing"))
+		t.Cleanup(func() { _ = closeHandler(h) })
+
+		l := NewLogger(1, WithMinLevel(1, h))
+		l.Log(4, "card declined", Attr{"order_id", 42}, Attr{"reason", "insufficient\nfunds"}, Attr{"_private", true})
+
+		fields, passed := j.receive(t)
+		assert.Empty(t, passed)
+		assert.Equal(t, "card declined", fields["MESSAGE"])
+		assert.Equal(t, "3", fields["PRIORITY"])
+		assert.Equal(t, "billing", fields["SERVICE"])
+		assert.Equal(t, filepath.Base(os.Args[0]), fields["SYSLOG_IDENTIFIER"])
+		assert.Equal(t, "42", fields["ORDER_ID"])
+		assert.Equal(t, "insufficient\nfunds", fields["REASON"])
+		assert.Equal(t, "true", fields["X_PRIVATE"])
+		assert.Equal(t, "journald_test.go", filepath.Base(fields["CODE_FILE"]))
+		assert.NotEmpty(t, fields["CODE_LINE"])
+		assert.Contains(t, fields["CODE_FUNC"], "TestJournaldHandler")
+	})
+
+	t.Run("a plain message is informational without code location", func(t *testing.T) {
+		t.Parallel()
+
+		j := newJournalStandIn(t)
+		h := JournaldHandler(WithJournaldSocket(j.path), WithJournaldField("SYSLOG_IDENTIFIER", "billing"))
+		t.Cleanup(func() { _ = closeHandler(h) })
+
+		writeRotating(t, h, "started\n")
+		fields, _ := j.receive(t)
+		assert.Equal(t, map[string]string{"MESSAGE": "started", "PRIORITY": "6", "SYSLOG_IDENTIFIER": "billing"}, fields)
+	})
+
+	t.Run("a level maps to PRIORITY through the table", func(t *testing.T) {
+		t.Parallel()
+
+		j := newJournalStandIn(t)
+		h := JournaldHandler(WithJournaldSocket(j.path), WithJournaldPriorities(map[LogLevel]int{9: 2}))
+		t.Cleanup(func() { _ = closeHandler(h) })
+
+		l := NewLogger(1, h)
+		l.Printf(9, "disk failing")
+		fields, _ := j.receive(t)
+		assert.Equal(t, "2", fields["PRIORITY"])
+		assert.Equal(t, "disk failing", fields["MESSAGE"])
+	})
+
+	t.Run("an entry too large for a datagram is passed as a sealed memfd", func(t *testing.T) {
+		t.Parallel()
+
+		j := newJournalStandIn(t)
+		h := JournaldHandler(WithJournaldSocket(j.path))
+		t.Cleanup(func() { _ = closeHandler(h) })
+
+		big := strings.Repeat("x", 4<<20)
+		writeRotating(t, h, big)
+		fields, passed := j.receive(t)
+		assert.Equal(t, big, fields["MESSAGE"])
+		assert.True(t, strings.HasPrefix(passed, "/memfd:"+journalMemfdName), passed)
+	})
+
+	t.Run("a missing socket fails the write", func(t *testing.T) {
+		t.Parallel()
+
+		h := JournaldHandler(WithJournaldSocket(filepath.Join(t.TempDir(), "none")))
+		n, err := h.Write([]byte("lost"))
+		require.ErrorContains(t, err, "loginjector: journald:")
+		assert.Zero(t, n, "nothing was written")
+		n, err = h.(LevelWriter).WriteLevel(4, []byte("lost"))
+		require.Error(t, err)
+		assert.Zero(t, n)
+	})
+
+	t.Run("Close is safe alongside writes", func(t *testing.T) {
+		t.Parallel()
+
+		j := newJournalStandIn(t)
+		go func() {
+			// drain the socket: a unixgram peer blocks once a few datagrams are queued.
+			buf := make([]byte, 1<<16)
+			for {
+				if _, err := j.conn.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+		h := JournaldHandler(WithJournaldSocket(j.path))
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 50; i++ {
+				_, _ = h.Write([]byte("racing"))
+			}
+		}()
+		for i := 0; i < 50; i++ {
+			require.NoError(t, closeHandler(h))
+		}
+		<-done
+	})
+}
+
+func TestJournalFieldName(t *testing.T) {
+	t.Parallel()
+
+	for key, want := range map[string]string{
+		"order_id":    "ORDER_ID",
+		"http.status": "HTTP_STATUS",
+		"_secret":     "X_SECRET",
+		"9lives":      "X9LIVES",
+		"":            "X",
+	} {
+		assert.Equal(t, want, journalFieldName(key), key)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"runtime"
 	"sync"
+	"time"
 )
 
 // LeveledHandler is an optional interface a handler may implement to declare a
@@ -22,6 +24,25 @@ type LeveledHandler interface {
 	MinLevel() LogLevel
 }
 
+// LevelWriter is an optional interface a handler may implement to learn each message's
+// level. Logger.WriteLog calls WriteLevel instead of Write on a handler or hook that
+// implements it, so a handler can treat levels differently — RotatingFileHandler's
+// WithDurableMinLevel fsyncs only the higher ones. Every handler of this package
+// implements it and passes the level on to the handler it wraps; a plain Write reaches
+// a handler with no level.
+type LevelWriter interface {
+	io.Writer
+	WriteLevel(level LogLevel, p []byte) (int, error)
+}
+
+// writeLevel writes p to w at level, through WriteLevel when w implements LevelWriter.
+func writeLevel(w io.Writer, level LogLevel, p []byte) (int, error) {
+	if lw, ok := w.(LevelWriter); ok {
+		return lw.WriteLevel(level, p)
+	}
+	return w.Write(p)
+}
+
 // NewLogger creates a new logger with the given minimum log level and handlers.
 // If no handlers are provided, a default TimestampedPrintHandler (timestamped
 // stdout) is added.
@@ -54,7 +75,11 @@ func ensureThreadSafe(w io.Writer) io.Writer {
 	if _, ok := w.(*writer); ok {
 		return w
 	}
-	return &writer{original: w, h: w.Write}
+	tw := &writer{original: w, h: w.Write, hl: func(level LogLevel, p []byte) (int, error) { return writeLevel(w, level, p) }}
+	if rw, ok := w.(RecordWriter); ok {
+		tw.hr = rw.WriteRecord
+	}
+	return tw
 }
 
 // unwrapLeveled returns the LeveledHandler view of h, peeling the ensureThreadSafe
@@ -174,11 +199,18 @@ func (l *Logger) Unhook(id HookID) {
 // WriteLog holds the read lock for the entire duration so it is safe to call
 // concurrently with Hook/Unhook/SetMinLevel.
 func (l *Logger) WriteLog(level LogLevel, message []byte) (int, error) {
+	return l.emit(level, len(message), func(w io.Writer) error {
+		_, err := writeLevel(w, level, message)
+		return err
+	})
+}
+
+// emit delivers a message of n bytes at level to every matching sink with send, under
+// WriteLog's rules.
+func (l *Logger) emit(level LogLevel, n int, send func(w io.Writer) error) (int, error) {
 	l.m.RLock()
 	defer l.m.RUnlock()
 
-	n := len(message)
-
 	// collect matching hooks first (fire regardless of minimumLogLevel).
 	sinks := make([]io.Writer, 0, len(l.hooks)+len(l.handlers))
 	for _, h := range l.hooks {
@@ -208,7 +240,7 @@ func (l *Logger) WriteLog(level LogLevel, message []byte) (int, error) {
 	switch len(sinks) {
 	case 1:
 		// fast path: single sink, write inline without goroutine or WaitGroup.
-		_, err := sinks[0].Write(message)
+		err := send(sinks[0])
 		if !anyHandlerActive {
 			// sole sink was a hook below the minimum level; return 0 per contract.
 			return 0, err
@@ -229,7 +261,7 @@ func (l *Logger) WriteLog(level LogLevel, message []byte) (int, error) {
 		// since the sinks run concurrently.
 		write := func(w io.Writer) {
 			defer wg.Done()
-			if _, e := w.Write(message); e != nil {
+			if e := send(w); e != nil {
 				mu.Lock()
 				errs = append(errs, e)
 				mu.Unlock()
@@ -251,6 +283,39 @@ func (l *Logger) WriteLog(level LogLevel, message []byte) (int, error) {
 	}
 }
 
+// Close waits for the background work of every handler and hook built by this package —
+// for example RotatingFileHandler's WithOnRotate callbacks — and joins their errors. It
+// never closes a caller-supplied io.Writer (os.Stdout, a user file): the logger does not
+// own those. The logger remains usable after Close; a later write may start background
+// work again, so call Close once logging has stopped.
+//
+// The sinks are collected under the read lock and closed after it is released, so a
+// background job that logs through this logger cannot deadlock Close.
+func (l *Logger) Close() error {
+	l.m.RLock()
+	sinks := make([]io.Writer, 0, len(l.handlers)+len(l.hooks))
+	seen := make(map[io.Writer]struct{}, cap(sinks))
+	for _, h := range l.handlers {
+		if _, ok := seen[h]; !ok {
+			seen[h] = struct{}{}
+			sinks = append(sinks, h)
+		}
+	}
+	for _, h := range l.hooks {
+		if _, ok := seen[h.Writer]; !ok {
+			seen[h.Writer] = struct{}{}
+			sinks = append(sinks, h.Writer)
+		}
+	}
+	l.m.RUnlock()
+
+	var err error
+	for _, s := range sinks {
+		err = errors.Join(err, closeHandler(s))
+	}
+	return err
+}
+
 // Printf writes a formatted log message
 func (l *Logger) Printf(level LogLevel, format string, args ...any) {
 	m := fmt.Sprintf(format, args...)
@@ -260,6 +325,26 @@ func (l *Logger) Printf(level LogLevel, format string, args ...any) {
 	}
 }
 
+// Log writes a structured message at the given level: a handler implementing
+// RecordWriter receives the Record, with attrs and the caller's file, line and function,
+// and every other sink receives Record.Text. Sinks are chosen as by WriteLog.
+func (l *Logger) Log(level LogLevel, msg string, attrs ...Attr) {
+	r := Record{Time: time.Now(), Level: level, Message: msg, Attrs: attrs}
+	if pc, file, line, ok := runtime.Caller(1); ok {
+		r.File, r.Line = file, line
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			r.Function = fn.Name()
+		}
+	}
+	_, err := l.emit(level, len(r.Text()), func(w io.Writer) error {
+		_, err := writeRecord(w, r)
+		return err
+	})
+	if err != nil {
+		println(err.Error())
+	}
+}
+
 // Print writes a log message
 func (l *Logger) Print(level LogLevel, args ...any) {
 	m := fmt.Sprint(args...)
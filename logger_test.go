@@ -195,6 +195,42 @@ func TestLogger_WriteLog(t *testing.T) {
 	require.Equal(t, m, b.String())
 }
 
+// recordSink keeps the Records it is given.
+type recordSink struct {
+	bytes.Buffer
+	records []Record
+}
+
+func (r *recordSink) WriteRecord(rec Record) (int, error) {
+	r.records = append(r.records, rec)
+	return len(rec.Message), nil
+}
+
+func TestLogger_Log(t *testing.T) {
+	text := bytes.NewBufferString("")
+	records := &recordSink{}
+	l := NewLogger(logLevelInfo, text, WithMinLevel(logLevelInfo, records))
+
+	l.Log(logLevelSevere, "order failed", Attr{"order_id", 42}, Attr{"reason", "card declined"}, Attr{"note", ""})
+	require.Equal(t, "order failed order_id=42 reason=\"card declined\" note=\"\"\n", text.String())
+
+	require.Len(t, records.records, 1)
+	r := records.records[0]
+	require.Equal(t, logLevelSevere, r.Level)
+	require.Equal(t, "order failed", r.Message)
+	require.Equal(t, []Attr{{"order_id", 42}, {"reason", "card declined"}, {"note", ""}}, r.Attrs)
+	require.True(t, strings.HasSuffix(r.File, "logger_test.go"), r.File)
+	require.NotZero(t, r.Line)
+	require.True(t, strings.HasSuffix(r.Function, "TestLogger_Log"), r.Function)
+	require.False(t, r.Time.IsZero())
+	require.Empty(t, records.String(), "a RecordWriter gets the Record, not the text")
+
+	text.Reset()
+	l.Log(logLevelDebug, "below the minimum")
+	require.Empty(t, text.String())
+	require.Len(t, records.records, 1)
+}
+
 func TestLogger_Write(t *testing.T) {
 	m := uniqueToken()
 	b := bytes.NewBufferString("")
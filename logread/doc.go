@@ -0,0 +1,33 @@
+// Package logread reads back the rotating log sets written by
+// github.com/prorochestvo/loginjector. It walks a set's rotated backups, gzipped or
+// plain, and then its live file, in the order the data was written, and yields the lines
+// one at a time. The naming rules come from loginjector.RotatedFiles, so every layout
+// RotatingFileHandler writes — index-named, WithStableCurrentName and TimestampBackups —
+// is read without configuration.
+//
+// Lines stamped by loginjector.TimestampedHandler (the layout NewFileLogger writes) carry
+// their time, and the indented continuation lines of a multi-line message inherit it, so
+// WithTimeRange selects whole messages and skips backups that end before the range
+// starts. WithLevel filters by a level read from the message text, since the files
+// themselves record no level.
+//
+// Follow tails a set instead: it starts at the live file's end and keeps reading
+// across rotations until its context is done, and Tail returns a set's last lines
+// without following it. All three read a set written under
+// loginjector.WithEncryption when given its key with WithKey.
+//
+// Usage example:
+//
+//	r, err := logread.Open("./logs", "app",
+//	    logread.WithTimeRange(time.Now().Add(-time.Hour), time.Time{}),
+//	    logread.WithLevel(levels.Error, logread.LevelWord),
+//	)
+//	if err != nil {
+//	    return err
+//	}
+//	defer r.Close()
+//	for r.Next() {
+//	    fmt.Println(r.Line().Text)
+//	}
+//	return r.Err()
+package logread
@@ -0,0 +1,675 @@
+package logread
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prorochestvo/loginjector"
+)
+
+// WithPollInterval sets how often Follow checks the live file for new lines and the
+// folder for a rotation once it has read everything; the default is 200ms. Open ignores
+// it.
+func WithPollInterval(d time.Duration) Option {
+	return func(c *config) {
+		if d > 0 {
+			c.poll = d
+		}
+	}
+}
+
+// WithFromStart makes Follow read the whole set, backups first, before following the
+// live file, instead of starting at the live file's current end. Open ignores it.
+func WithFromStart() Option {
+	return func(c *config) { c.fromStart = true }
+}
+
+// WithTail makes Follow start with the last n lines already in the set, like tail -n,
+// instead of at the live file's current end; with filters set they are the last n lines
+// that pass them. Backups are read, newest first, only as far back as n lines reach.
+// WithFromStart takes precedence. Open ignores it.
+func WithTail(n int) Option {
+	return func(c *config) {
+		if n > 0 {
+			c.tail = n
+		}
+	}
+}
+
+// Follower streams the lines appended to a log set, across rotations. It is not safe for
+// concurrent use.
+type Follower struct {
+	ctx    context.Context
+	folder string
+	prefix string
+	ann    *annotator
+
+	// seen holds the stems (base name less .gz) of the backups already read or being
+	// read; the stable live file prefix.log is tracked by identity instead, as its name
+	// is reused on every rotation.
+	seen map[string]bool
+	// backlog reads finished files in full — intermediate backups a rotation left
+	// behind — before the next live file is opened.
+	backlog *Reader
+	next    string
+
+	cur     *os.File
+	src     io.Reader // what cur's lines are read from: cur, or its decrypted records.
+	retired *os.File  // the file a rotation just finished, open until its backup is matched.
+	curPath string
+	curInfo os.FileInfo
+	partial []byte
+	skip    bool // discard up to the first newline: Follow started mid-line.
+	pending []Line
+
+	buf    []byte
+	line   Line
+	err    error
+	closed bool
+}
+
+// Follow returns a Follower over prefix's set in folder that starts at the live file's
+// current end, like tail -f (WithFromStart reads the existing lines first, WithTail the
+// last few), and yields each
+// line appended after that. It only emits complete lines, polling for more when it has
+// read everything.
+//
+// Rotation is followed transparently in every layout RotatingFileHandler writes: under
+// index naming it moves on once the next index appears, and under WithStableCurrentName or
+// TimestampBackups it notices that prefix.log was renamed away and re-created. The old
+// file stays open until it is read to its end, so no line written before the rotation
+// is skipped, and backups produced by several rotations between two polls are read in
+// full in order, gzipped or not. A line is never yielded twice.
+//
+// Next blocks until a line is available or ctx is done. The filtering options of Open
+// apply as well.
+func Follow(ctx context.Context, folder, prefix string, opts ...Option) (*Follower, error) {
+	cfg := defaultConfig()
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if err := cfg.checkKey(); err != nil {
+		return nil, err
+	}
+	f := &Follower{
+		ctx:    ctx,
+		folder: folder,
+		prefix: prefix,
+		ann:    newAnnotator(cfg),
+		seen:   make(map[string]bool),
+	}
+
+	files, err := loginjector.RotatedFiles(folder, prefix, cfg.naming...)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return f, nil
+	}
+	last := files[len(files)-1]
+	for _, p := range files {
+		f.markSeen(p)
+	}
+	if cfg.fromStart {
+		if strings.HasSuffix(last, ".gz") {
+			f.queue(files, "")
+		} else {
+			f.queue(files[:len(files)-1], last)
+		}
+		return f, nil
+	}
+	if cfg.tail > 0 {
+		if err := f.tail(files, cfg.tail); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+	if strings.HasSuffix(last, ".gz") {
+		return f, nil // the next live file is not created yet.
+	}
+	if err := f.open(last, true); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Tail returns the last n lines of prefix's set in folder, like tail -n without -f; with
+// filters set they are the last n lines that pass them. Backups are read, newest first,
+// only as far back as n lines reach, and a line still being written is left out. The
+// filtering options of Open apply; WithFromStart, WithTail and WithPollInterval do not.
+func Tail(folder, prefix string, n int, opts ...Option) ([]Line, error) {
+	cfg := defaultConfig()
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if err := cfg.checkKey(); err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+	files, err := loginjector.RotatedFiles(folder, prefix, cfg.naming...)
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	f := &Follower{
+		ctx:    context.Background(),
+		folder: folder,
+		prefix: prefix,
+		ann:    newAnnotator(cfg),
+		seen:   make(map[string]bool),
+	}
+	err = f.tail(files, n)
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f.pending, nil
+}
+
+// tail queues the last n lines of files that pass the filters. The live file is read
+// from its start through the descriptor Follow goes on following, so no line falls
+// between the tail and what follows it; backups, newest first, are read only while
+// fewer than n lines are found.
+func (f *Follower) tail(files []string, n int) error {
+	var lines []Line
+	backups := files
+	if last := files[len(files)-1]; !strings.HasSuffix(last, ".gz") {
+		if err := f.open(last, false); err != nil {
+			return err
+		}
+		if _, err := f.read(); err != nil {
+			return err
+		}
+		for _, l := range f.pending {
+			if f.ann.keep(l) {
+				lines = append(lines, l)
+			}
+		}
+		f.pending = nil
+		backups = files[:len(files)-1]
+	}
+	for i := len(backups) - 1; i >= 0 && len(lines) < n; i-- {
+		r := &Reader{ann: newAnnotator(f.ann.cfg), files: backups[i : i+1]}
+		var older []Line
+		for r.Next() {
+			older = append(older, r.Line())
+		}
+		if err := r.Err(); err != nil {
+			return err
+		}
+		lines = append(older, lines...)
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	f.pending = lines
+	return nil
+}
+
+// Next advances to the next line and reports whether there is one. It blocks while the
+// set is idle and returns false once the Follower's context is done or an error stopped
+// it; see Err.
+func (f *Follower) Next() bool {
+	for f.err == nil && !f.closed {
+		if len(f.pending) > 0 {
+			l := f.pending[0]
+			f.pending = f.pending[1:]
+			if f.ann.keep(l) {
+				f.line = l
+				return true
+			}
+			continue
+		}
+
+		if f.backlog != nil {
+			if f.backlog.Next() {
+				f.line = f.backlog.Line()
+				return true
+			}
+			f.err = f.backlog.Err()
+			f.backlog = nil
+			continue
+		}
+		if f.next != "" {
+			path := f.next
+			f.next = ""
+			err := f.open(path, false)
+			if errors.Is(err, os.ErrNotExist) && !f.isStableLive(path) {
+				// a backup compressed before it could be opened is finished: read it in
+				// full from its .gz.
+				f.queue([]string{path}, "")
+				continue
+			}
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				f.err = err
+			}
+			if err == nil && f.isStableLive(path) {
+				f.err = f.settle()
+			}
+			continue
+		}
+
+		if f.cur != nil {
+			n, err := f.read()
+			if err != nil {
+				f.err = err
+				return false
+			}
+			if n > 0 {
+				continue
+			}
+		}
+
+		rotated, err := f.checkRotation()
+		if err != nil {
+			f.err = err
+			return false
+		}
+		if rotated {
+			continue
+		}
+
+		t := time.NewTimer(f.ann.cfg.poll)
+		select {
+		case <-f.ctx.Done():
+			t.Stop()
+			return false
+		case <-t.C:
+		}
+	}
+	return false
+}
+
+// open makes path the followed file, at its end when atEnd is set.
+func (f *Follower) open(path string, atEnd bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	src, sealed, err := f.ann.cfg.plaintext(file)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.skip = false
+	switch {
+	case atEnd && sealed:
+		// records are bound to the file's header and their position in it, so the reader
+		// decodes its way to the end rather than seeking there; every record ends a
+		// message, so nothing is skipped after it.
+		if _, err := io.Copy(io.Discard, src); err != nil {
+			_ = file.Close()
+			return err
+		}
+	case atEnd && fi.Size() > 0:
+		if _, err := file.Seek(0, io.SeekEnd); err != nil {
+			_ = file.Close()
+			return err
+		}
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, fi.Size()-1); err == nil && last[0] != '\n' {
+			f.skip = true
+		}
+	}
+	f.cur, f.src, f.curPath, f.curInfo = file, src, path, fi
+	f.partial = f.partial[:0]
+	f.ann.reset()
+	return nil
+}
+
+// read appends what the followed file has gained since the last read to the pending
+// lines, keeping an unterminated tail back until its newline arrives. It returns the
+// number of bytes read.
+func (f *Follower) read() (int, error) {
+	if f.buf == nil {
+		f.buf = make([]byte, 32<<10)
+	}
+	total := 0
+	for {
+		n, err := f.src.Read(f.buf)
+		total += n
+		f.partial = append(f.partial, f.buf[:n]...)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return total, err
+		}
+		if n == 0 || errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	for {
+		i := bytes.IndexByte(f.partial, '\n')
+		if i < 0 {
+			break
+		}
+		text := string(f.partial[:i])
+		f.partial = f.partial[i+1:]
+		if f.skip {
+			f.skip = false
+			continue
+		}
+		f.pending = append(f.pending, f.ann.annotate(text, f.curPath))
+	}
+	return total, nil
+}
+
+// checkRotation lists the set and, when the followed file is no longer the live one,
+// drains and retires it and queues what came after it. It reports whether it did.
+func (f *Follower) checkRotation() (bool, error) {
+	// a stable followed file moved between the listing and the check would leave the backup
+	// it became out of the listing, so list again until both sides of it agree.
+	var files []string
+	moved := f.moved()
+	for {
+		var err error
+		if files, err = f.list(); err != nil {
+			return false, err
+		}
+		again := f.moved()
+		if again == moved {
+			break
+		}
+		moved = again
+	}
+
+	var fresh []string
+	for _, p := range files {
+		if f.isStableLive(p) {
+			fi, e := os.Stat(p)
+			if e == nil && (f.cur == nil || !os.SameFile(fi, f.curInfo)) {
+				fresh = append(fresh, p)
+			}
+			continue
+		}
+		if !f.seen[stem(p)] {
+			fresh = append(fresh, p)
+		}
+	}
+	// the followed file is finished once a later one exists: under index naming any newer
+	// index, under the stable name a prefix.log renamed away, whether or not the next one
+	// has been created yet.
+	retire := false
+	if f.cur != nil {
+		if f.isStableLive(f.curPath) {
+			retire = moved
+		} else {
+			retire = len(fresh) > 0
+		}
+	}
+	if len(fresh) == 0 && !retire {
+		return false, nil
+	}
+	if retire {
+		if err := f.retire(); err != nil {
+			return false, err
+		}
+		// the retired descriptor stays open until its backup is matched below, so its
+		// inode cannot be reused by a file created since.
+		defer func() {
+			_ = f.retired.Close()
+			f.retired, f.curInfo = nil, nil
+		}()
+	}
+
+	// with nothing left to follow, the newest fresh file becomes the followed one unless it
+	// is a finished .gz backup. The rest were completed between two polls and are read in
+	// full first, except the backup the retired file became, which was just drained
+	// through its descriptor.
+	var next string
+	if n := len(fresh); f.cur == nil && n > 0 && fresh[n-1] == files[len(files)-1] &&
+		!strings.HasSuffix(fresh[n-1], ".gz") && !f.wasRetired(fresh[n-1]) {
+		next = fresh[n-1]
+		fresh = fresh[:n-1]
+	}
+	backlog := make([]string, 0, len(fresh))
+	for _, p := range fresh {
+		f.markSeen(p)
+		if !f.wasRetired(p) {
+			backlog = append(backlog, p)
+		}
+	}
+	if next != "" {
+		f.markSeen(next)
+	}
+	f.queue(backlog, next)
+	return true, nil
+}
+
+// moved reports whether the followed file is a stable prefix.log that has since been
+// renamed away.
+func (f *Follower) moved() bool {
+	if f.cur == nil || !f.isStableLive(f.curPath) {
+		return false
+	}
+	fi, err := os.Stat(f.curPath)
+	return err != nil || !os.SameFile(fi, f.curInfo)
+}
+
+// settle makes sure nothing rotated between listing prefix.log and opening it. While
+// the opened file is still the live one, every backup not yet seen predates it and is
+// read first; if it was already rotated away, it is dropped unread and the next
+// checkRotation starts over from the listing.
+func (f *Follower) settle() error {
+	files, err := f.list()
+	if err != nil {
+		return err
+	}
+	var older []string
+	for _, p := range files {
+		if !f.isStableLive(p) && !f.seen[stem(p)] {
+			older = append(older, p)
+		}
+	}
+	if len(older) == 0 {
+		return nil
+	}
+	if fi, e := os.Stat(f.curPath); e == nil && os.SameFile(fi, f.curInfo) {
+		for _, p := range older {
+			f.markSeen(p)
+		}
+		f.queue(older, "")
+		return nil
+	}
+	err = f.cur.Close()
+	f.cur, f.curInfo = nil, nil
+	return err
+}
+
+// list lists the set until two listings in a row agree. A directory read that races a
+// rename can miss the renamed entry, and a backup missed here would be read out of order
+// on a later poll.
+func (f *Follower) list() ([]string, error) {
+	prev, err := loginjector.RotatedFiles(f.folder, f.prefix, f.ann.cfg.naming...)
+	for i := 0; err == nil && i < 8; i++ {
+		var files []string
+		if files, err = loginjector.RotatedFiles(f.folder, f.prefix, f.ann.cfg.naming...); err != nil {
+			break
+		}
+		if slices.Equal(files, prev) {
+			return files, nil
+		}
+		prev = files
+	}
+	return prev, err
+}
+
+// retire reads the followed file to its end — a rotated file is final, so an
+// unterminated tail is emitted as a line too — and moves it to retired, refreshing curInfo
+// with its final mtime, which a compressed copy keeps.
+func (f *Follower) retire() error {
+	if _, err := f.read(); err != nil {
+		return err
+	}
+	if len(f.partial) > 0 && !f.skip {
+		f.pending = append(f.pending, f.ann.annotate(string(f.partial), f.curPath))
+	}
+	f.partial = f.partial[:0]
+	if fi, err := f.cur.Stat(); err == nil {
+		f.curInfo = fi
+	}
+	f.retired, f.cur = f.cur, nil
+	return nil
+}
+
+// wasRetired reports whether backup p is the file just retired: the same inode, or its
+// gzipped copy. A copy is recognised by the mtime compression preserves together with
+// the uncompressed size recorded in the gzip trailer, as mtimes alone are too coarse to
+// tell backups rotated in quick succession apart.
+func (f *Follower) wasRetired(p string) bool {
+	// an index-named file never changes name, so only a stable prefix.log becomes a backup.
+	if f.retired == nil || !f.isStableLive(f.curPath) {
+		return false
+	}
+	fi, err := os.Stat(p)
+	if errors.Is(err, os.ErrNotExist) && !strings.HasSuffix(p, ".gz") {
+		p += ".gz" // compressed since it was listed.
+		fi, err = os.Stat(p)
+	}
+	if err != nil {
+		return false
+	}
+	if os.SameFile(fi, f.curInfo) {
+		return true
+	}
+	if !strings.HasSuffix(p, ".gz") || !fi.ModTime().Equal(f.curInfo.ModTime()) {
+		return false
+	}
+	size, ok := gzipSize(p, fi.Size(), f.ann.cfg)
+	want, known := f.retiredSize()
+	return ok && known && size == want
+}
+
+// retiredSize returns the plaintext size of the retired file, modulo 2^32. An encrypted
+// file is decrypted through the still-open descriptor to count it.
+func (f *Follower) retiredSize() (uint32, bool) {
+	sealed := false
+	if f.ann.cfg.key != nil {
+		var err error
+		if sealed, err = loginjector.IsEncrypted(f.retired); err != nil {
+			return 0, false
+		}
+	}
+	if !sealed {
+		return uint32(f.curInfo.Size()), true
+	}
+	r, err := loginjector.NewDecryptingReader(io.NewSectionReader(f.retired, 0, f.curInfo.Size()), f.ann.cfg.key)
+	if err != nil {
+		return 0, false
+	}
+	n, err := io.Copy(io.Discard, r)
+	return uint32(n), err == nil
+}
+
+// gzipSize returns the uncompressed size, modulo 2^32, recorded in the trailer of the
+// gzip file at path, which is size bytes long. An encrypted backup is decrypted to reach
+// the trailer.
+func gzipSize(path string, size int64, cfg config) (uint32, bool) {
+	if size < 4 {
+		return 0, false
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer func() { _ = file.Close() }()
+	src, sealed, err := cfg.plaintext(file)
+	if err != nil {
+		return 0, false
+	}
+	var trailer [4]byte
+	if sealed {
+		tail := &lastBytes{}
+		if _, err := io.Copy(tail, src); err != nil || tail.n < len(trailer) {
+			return 0, false
+		}
+		trailer = tail.b
+	} else if _, err := file.ReadAt(trailer[:], size-4); err != nil {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(trailer[:]), true
+}
+
+// lastBytes keeps the last four bytes written to it.
+type lastBytes struct {
+	b [4]byte
+	n int
+}
+
+func (t *lastBytes) Write(p []byte) (int, error) {
+	for _, c := range p {
+		copy(t.b[:], t.b[1:])
+		t.b[3] = c
+	}
+	t.n += len(p)
+	return len(p), nil
+}
+
+// queue schedules finished files to be read in full, then next to be followed.
+func (f *Follower) queue(finished []string, next string) {
+	if len(finished) > 0 {
+		f.backlog = &Reader{ann: f.ann, files: finished}
+	}
+	f.next = next
+}
+
+// markSeen records a backup as accounted for; the stable live name is tracked by
+// identity and never recorded.
+func (f *Follower) markSeen(p string) {
+	if !f.isStableLive(p) {
+		f.seen[stem(p)] = true
+	}
+}
+
+// isStableLive reports whether p is the fixed prefix.log live file.
+func (f *Follower) isStableLive(p string) bool {
+	return filepath.Base(p) == f.prefix+".log"
+}
+
+// stem is p's base name less any .gz suffix, so a backup and its compressed copy match.
+func stem(p string) string {
+	return strings.TrimSuffix(filepath.Base(p), ".gz")
+}
+
+// Line returns the line Next advanced to.
+func (f *Follower) Line() Line {
+	return f.line
+}
+
+// Err returns the error that stopped Next, or nil when it stopped because the context
+// was done.
+func (f *Follower) Err() error {
+	return f.err
+}
+
+// Close releases the files the Follower has open; Next returns false after it. It is safe
+// to call more than once.
+func (f *Follower) Close() error {
+	var err error
+	if f.backlog != nil {
+		err = f.backlog.Close()
+		f.backlog = nil
+	}
+	if f.cur != nil {
+		err = errors.Join(err, f.cur.Close())
+		f.cur, f.src = nil, nil
+	}
+	f.next = ""
+	f.closed = true
+	return err
+}
@@ -0,0 +1,197 @@
+package logread
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prorochestvo/loginjector"
+	"github.com/stretchr/testify/require"
+)
+
+// collect reads n lines from f, failing if they do not arrive before the context ends.
+func collect(t *testing.T, f *Follower, n int) []string {
+	t.Helper()
+	out := make([]string, 0, n)
+	for len(out) < n && f.Next() {
+		out = append(out, f.Line().Text)
+	}
+	require.NoError(t, f.Err())
+	require.Len(t, out, n, "lines did not arrive in time")
+	return out
+}
+
+func TestFollow(t *testing.T) {
+	layouts := map[string][]loginjector.RotatingFileOption{
+		"indexed":            {},
+		"indexed compressed": {loginjector.WithCompress()},
+		"stable compressed":  {loginjector.WithStableCurrentName(), loginjector.WithCompress()},
+		"timestamp async":    {loginjector.WithBackupNaming(loginjector.TimestampBackups()), loginjector.WithAsyncCompress()},
+		"encrypted async":    {loginjector.WithAsyncCompress(), loginjector.WithEncryption(testKey)},
+	}
+	for name, opts := range layouts {
+		opts := opts
+		t.Run(name+": every line once across rotations", func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			h := loginjector.RotatingFileHandler(dir, "app", append(opts, loginjector.WithMaxFileSize(40), loginjector.WithMaxFiles(1000))...)
+			_, err := h.Write([]byte("written before Follow"))
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			f, err := Follow(ctx, dir, "app", WithPollInterval(5*time.Millisecond), WithKey(testKey))
+			require.NoError(t, err)
+			defer func() { _ = f.Close() }()
+
+			want := make([]string, 0, 200)
+			for i := 0; i < 200; i++ {
+				want = append(want, fmt.Sprintf("line %03d", i))
+			}
+			written := make(chan struct{})
+			go func() {
+				defer close(written)
+				for i, m := range want {
+					_, _ = h.Write([]byte(m))
+					if i%17 == 0 {
+						time.Sleep(3 * time.Millisecond) // let some polls land mid-stream.
+					}
+				}
+			}()
+
+			require.Equal(t, want, collect(t, f, len(want)))
+			<-written
+			// wait for background compression before the folder is removed.
+			require.NoError(t, h.(io.Closer).Close())
+		})
+	}
+
+	t.Run("from start reads the existing set first", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := loginjector.RotatingFileHandler(dir, "app", loginjector.WithMaxFileSize(5), loginjector.WithCompress())
+		for _, m := range []string{"one", "two-2", "three"} {
+			_, err := h.Write([]byte(m))
+			require.NoError(t, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		f, err := Follow(ctx, dir, "app", WithFromStart(), WithPollInterval(5*time.Millisecond))
+		require.NoError(t, err)
+		require.Equal(t, []string{"one", "two-2", "three"}, collect(t, f, 3))
+
+		_, err = h.Write([]byte("four"))
+		require.NoError(t, err)
+		require.Equal(t, []string{"four"}, collect(t, f, 1))
+	})
+
+	t.Run("an encrypted live file is followed from its end", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := loginjector.RotatingFileHandler(dir, "app", loginjector.WithEncryption(testKey))
+		_, err := h.Write([]byte("written before Follow"))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		f, err := Follow(ctx, dir, "app", WithKey(testKey), WithPollInterval(5*time.Millisecond))
+		require.NoError(t, err)
+		defer func() { _ = f.Close() }()
+
+		_, err = h.Write([]byte("written after"))
+		require.NoError(t, err)
+		require.Equal(t, []string{"written after"}, collect(t, f, 1))
+	})
+
+	t.Run("tail starts with the last lines of the set", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := loginjector.RotatingFileHandler(dir, "app", loginjector.WithMaxFileSize(5), loginjector.WithCompress())
+		for _, m := range []string{"one", "two-2", "three", "four"} {
+			_, err := h.Write([]byte(m))
+			require.NoError(t, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		f, err := Follow(ctx, dir, "app", WithTail(3), WithPollInterval(5*time.Millisecond))
+		require.NoError(t, err)
+		defer func() { _ = f.Close() }()
+		require.Equal(t, []string{"two-2", "three", "four"}, collect(t, f, 3), "the tail reaches back into the backups")
+
+		_, err = h.Write([]byte("five"))
+		require.NoError(t, err)
+		require.Equal(t, []string{"five"}, collect(t, f, 1))
+	})
+
+	t.Run("only complete lines are emitted", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.00000001.log")
+		require.NoError(t, os.WriteFile(path, []byte("old\npartial-old"), 0o640))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		f, err := Follow(ctx, dir, "app", WithPollInterval(5*time.Millisecond))
+		require.NoError(t, err)
+
+		out, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+		defer func() { _ = out.Close() }()
+		_, err = out.WriteString("\nhalf")
+		require.NoError(t, err)
+
+		done := make(chan []string)
+		go func() {
+			var got []string
+			for f.Next() {
+				got = append(got, f.Line().Text)
+			}
+			done <- got
+		}()
+		time.Sleep(50 * time.Millisecond)
+		_, err = out.WriteString(" and whole\n")
+		require.NoError(t, err)
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+
+		require.Equal(t, []string{"half and whole"}, <-done, "the line Follow started inside is skipped")
+		require.NoError(t, f.Err(), "a cancelled context is a clean stop")
+	})
+}
+
+func TestTail(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	h := loginjector.RotatingFileHandler(dir, "app", loginjector.WithMaxFileSize(5), loginjector.WithCompress())
+	for _, m := range []string{"one", "two-2", "three", "four"} {
+		_, err := h.Write([]byte(m))
+		require.NoError(t, err)
+	}
+
+	lines, err := Tail(dir, "app", 3)
+	require.NoError(t, err)
+	texts := make([]string, 0, len(lines))
+	for _, l := range lines {
+		texts = append(texts, l.Text)
+	}
+	require.Equal(t, []string{"two-2", "three", "four"}, texts, "the tail reaches back into the backups")
+
+	lines, err = Tail(dir, "app", 0)
+	require.NoError(t, err)
+	require.Empty(t, lines)
+
+	lines, err = Tail(t.TempDir(), "app", 3)
+	require.NoError(t, err)
+	require.Empty(t, lines, "an empty folder has no tail")
+}
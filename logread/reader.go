@@ -0,0 +1,411 @@
+package logread
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prorochestvo/loginjector"
+	"github.com/prorochestvo/loginjector/levels"
+)
+
+// Line is one line of a log set.
+type Line struct {
+	// Text is the line as written, without its trailing newline.
+	Text string
+	// Time is the timestamp of the message the line belongs to: its own stamp, or the
+	// stamp of the message it continues. It is zero when no stamp precedes the line.
+	Time time.Time
+	// Level is the message's level as reported by the WithLevel classifier; zero when no
+	// classifier is set or it did not recognise the message.
+	Level loginjector.LogLevel
+	// File is the path of the file the line was read from.
+	File string
+}
+
+// Classifier reports the level of a message, given the text after its timestamp, or false
+// when the message carries no recognisable level.
+type Classifier func(message string) (loginjector.LogLevel, bool)
+
+// LevelWord is the stock Classifier: it recognises a level name from the levels package
+// as the message's first word, in any case and optionally wrapped in brackets or
+// followed by a colon — "ERROR ...", "[warn] ...", "critical: ..." — including the
+// aliases levels.Parse accepts.
+func LevelWord(message string) (loginjector.LogLevel, bool) {
+	fields := strings.Fields(message)
+	if len(fields) == 0 {
+		return 0, false
+	}
+	word := strings.ToLower(strings.Trim(fields[0], "[]():"))
+	// levels.Parse falls back to Info for anything it does not know, so only a literal
+	// "info" may map to Info.
+	l := levels.Parse(word)
+	if l == levels.Info && word != "info" {
+		return 0, false
+	}
+	return l, true
+}
+
+// Option configures Open and Follow.
+type Option func(*config)
+
+// config holds the resolved options.
+type config struct {
+	from, to time.Time
+	layout   string
+	loc      *time.Location
+	minLevel loginjector.LogLevel
+	classify Classifier
+	naming   []loginjector.RotatingFileOption
+	key      []byte
+
+	poll      time.Duration // WithPollInterval; Follow only.
+	fromStart bool          // WithFromStart; Follow only.
+	tail      int           // WithTail; Follow only.
+}
+
+func defaultConfig() config {
+	return config{layout: "2006/01/02 15:04:05", loc: time.Local, poll: 200 * time.Millisecond}
+}
+
+// WithTimeRange keeps only the lines of messages stamped at or after from and before to;
+// a zero from or to leaves that end open. Lines that no stamp precedes are dropped.
+// Backups whose modification time is before from are skipped without being read.
+func WithTimeRange(from, to time.Time) Option {
+	return func(c *config) { c.from, c.to = from, to }
+}
+
+// WithTimeLayout overrides the timestamp layout lines are parsed with, for sets written
+// through TimestampedHandler with WithTimeLayout; loc is the zone the stamps were written
+// in (nil means time.Local). The default is "2006/01/02 15:04:05" in time.Local, matching
+// TimestampedHandler. The layout must format to a fixed width.
+func WithTimeLayout(layout string, loc *time.Location) Option {
+	return func(c *config) {
+		c.layout = layout
+		if loc == nil {
+			loc = time.Local
+		}
+		c.loc = loc
+	}
+}
+
+// WithLevel keeps only messages whose level, as classify reports it, is at or above min,
+// and sets Line.Level; messages classify does not recognise are dropped. A nil classify
+// is LevelWord. Continuation lines share their message's level.
+func WithLevel(min loginjector.LogLevel, classify Classifier) Option {
+	return func(c *config) {
+		if classify == nil {
+			classify = LevelWord
+		}
+		c.minLevel, c.classify = min, classify
+	}
+}
+
+// WithClassifier sets Line.Level with classify without filtering by level.
+func WithClassifier(classify Classifier) Option {
+	return func(c *config) { c.classify = classify }
+}
+
+// WithBackupNaming names the handler's backup scheme when it uses
+// loginjector.TimestampBackups with WithHostname or WithPID; see loginjector.RotatedFiles.
+func WithBackupNaming(n loginjector.BackupNaming) Option {
+	return func(c *config) { c.naming = []loginjector.RotatingFileOption{loginjector.WithBackupNaming(n)} }
+}
+
+// WithKey decrypts files written under loginjector.WithEncryption with key. Files of the
+// set that hold no encrypted records — written before encryption was enabled — are read
+// as they are. Open and Follow reject a key of invalid length.
+func WithKey(key []byte) Option {
+	k := append([]byte(nil), key...)
+	return func(c *config) { c.key = k }
+}
+
+// checkKey reports an unusable WithKey key before any file is read.
+func (c config) checkKey() error {
+	if c.key == nil {
+		return nil
+	}
+	_, err := loginjector.NewDecryptingReader(nil, c.key)
+	return err
+}
+
+// plaintext returns the reader of f's lines: f itself, or its decrypted records when a
+// key is set and f holds records. The second result reports the latter. For a still empty
+// file the choice waits for its first bytes, made by a sniffReader.
+func (c config) plaintext(f *os.File) (io.Reader, bool, error) {
+	if c.key == nil {
+		return f, false, nil
+	}
+	sealed, err := loginjector.IsEncrypted(f)
+	if err != nil {
+		return nil, false, err
+	}
+	if !sealed {
+		fi, err := f.Stat()
+		if err != nil || fi.Size() > 0 {
+			return f, false, err
+		}
+		return &sniffReader{src: f, key: c.key}, false, nil
+	}
+	r, err := loginjector.NewDecryptingReader(f, c.key)
+	return r, true, err
+}
+
+// sniffReader reads a file that was empty when opened: as records once its first bytes
+// are the record magic, as plaintext as soon as they cannot be.
+type sniffReader struct {
+	src  io.Reader
+	key  []byte
+	head []byte    // first bytes read, not yet handed on.
+	r    io.Reader // the chosen reader, once known.
+}
+
+func (s *sniffReader) Read(p []byte) (int, error) {
+	for s.r == nil {
+		var b [len(recordMagic)]byte
+		n, err := s.src.Read(b[:len(b)-len(s.head)])
+		s.head = append(s.head, b[:n]...)
+		switch {
+		case !strings.HasPrefix(recordMagic, string(s.head)):
+			s.r = &headReader{head: s.head, src: s.src}
+		case len(s.head) == len(recordMagic):
+			r, e := loginjector.NewDecryptingReader(&headReader{head: s.head, src: s.src}, s.key)
+			if e != nil {
+				return 0, e
+			}
+			s.r = r
+		case n == 0:
+			if err == nil {
+				err = io.EOF
+			}
+			return 0, err
+		}
+	}
+	return s.r.Read(p)
+}
+
+// recordMagic is how every encrypted file starts; see loginjector.IsEncrypted.
+const recordMagic = "LJE1"
+
+// headReader returns head, then the rest of src; src may grow after an io.EOF.
+type headReader struct {
+	head []byte
+	src  io.Reader
+}
+
+func (h *headReader) Read(p []byte) (int, error) {
+	if len(h.head) > 0 {
+		n := copy(p, h.head)
+		h.head = h.head[n:]
+		return n, nil
+	}
+	return h.src.Read(p)
+}
+
+// annotator tracks the message a line belongs to, so continuation lines inherit its
+// stamp and level.
+type annotator struct {
+	cfg   config
+	width int
+	time  time.Time
+	level loginjector.LogLevel
+	known bool
+}
+
+func newAnnotator(cfg config) *annotator {
+	ref := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	return &annotator{cfg: cfg, width: len(ref.Format(cfg.layout))}
+}
+
+// reset forgets the current message; a message never spans two files.
+func (a *annotator) reset() {
+	a.time, a.level, a.known = time.Time{}, 0, false
+}
+
+// annotate builds the Line for text read from file, starting a new message when text
+// begins with a stamp.
+func (a *annotator) annotate(text, file string) Line {
+	if len(text) >= a.width && (len(text) == a.width || text[a.width] == ' ') {
+		if t, err := time.ParseInLocation(a.cfg.layout, text[:a.width], a.cfg.loc); err == nil {
+			a.time, a.level, a.known = t, 0, false
+			if a.cfg.classify != nil {
+				a.level, a.known = a.cfg.classify(strings.TrimPrefix(text[a.width:], " "))
+				if !a.known {
+					a.level = 0
+				}
+			}
+		}
+	}
+	return Line{Text: text, Time: a.time, Level: a.level, File: file}
+}
+
+// keep reports whether the line passes the time and level filters.
+func (a *annotator) keep(l Line) bool {
+	if !a.cfg.from.IsZero() || !a.cfg.to.IsZero() {
+		if l.Time.IsZero() || l.Time.Before(a.cfg.from) || (!a.cfg.to.IsZero() && !l.Time.Before(a.cfg.to)) {
+			return false
+		}
+	}
+	if a.cfg.minLevel != 0 && (!a.known || l.Level < a.cfg.minLevel) {
+		return false
+	}
+	return true
+}
+
+// Reader iterates the lines of a log set, oldest first. It is not safe for concurrent use.
+type Reader struct {
+	ann   *annotator
+	files []string
+	next  int
+
+	file string
+	f    *os.File
+	gz   *gzip.Reader
+	br   *bufio.Reader
+
+	line Line
+	err  error
+}
+
+// Open lists prefix's set in folder and returns a Reader positioned before its first
+// line. The set is listed once: files rotated in after Open are not read, while the live
+// file is read up to whatever end it has when the Reader reaches it; Follow keeps reading
+// across rotations. Backups pruned between Open and the Reader reaching them are skipped;
+// those compressed in the meantime are read from their .gz.
+func Open(folder, prefix string, opts ...Option) (*Reader, error) {
+	cfg := defaultConfig()
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if err := cfg.checkKey(); err != nil {
+		return nil, err
+	}
+	files, err := loginjector.RotatedFiles(folder, prefix, cfg.naming...)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{ann: newAnnotator(cfg), files: files}, nil
+}
+
+// Next advances to the next line that passes the filters and reports whether there is
+// one. It returns false at the end of the set or on an error; see Err.
+func (r *Reader) Next() bool {
+	for r.err == nil {
+		if r.br == nil {
+			if !r.openNext() {
+				return false
+			}
+			continue
+		}
+		text, err := r.br.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			r.err = err
+			return false
+		}
+		if text == "" && err != nil {
+			r.closeFile()
+			continue
+		}
+		l := r.ann.annotate(strings.TrimSuffix(text, "\n"), r.file)
+		if err != nil {
+			// the final, unterminated line of the file.
+			r.closeFile()
+		}
+		if r.ann.keep(l) {
+			r.line = l
+			return true
+		}
+	}
+	return false
+}
+
+// openNext opens the next file of the set that may hold lines in range. It returns false
+// when the set is exhausted or an error stopped it.
+func (r *Reader) openNext() bool {
+	for r.next < len(r.files) {
+		path := r.files[r.next]
+		r.next++
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) && !strings.HasSuffix(path, ".gz") {
+			// compressed since Open: the handler removes the plaintext only once its .gz
+			// is complete.
+			path += ".gz"
+			f, err = os.Open(path)
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			continue // pruned since Open.
+		}
+		if err != nil {
+			r.err = err
+			return false
+		}
+		if from := r.ann.cfg.from; !from.IsZero() {
+			// every line of a file was written no later than its mtime, which compression
+			// preserves, so a file last modified before the range holds nothing in it.
+			if fi, e := f.Stat(); e == nil && fi.ModTime().Before(from) {
+				_ = f.Close()
+				continue
+			}
+		}
+		r.file, r.f = path, f
+		src, _, err := r.ann.cfg.plaintext(f)
+		if err != nil {
+			_ = f.Close()
+			r.f = nil
+			r.err = err
+			return false
+		}
+		if strings.HasSuffix(path, ".gz") {
+			gz, e := gzip.NewReader(src)
+			if e != nil {
+				_ = f.Close()
+				r.f = nil
+				r.err = e
+				return false
+			}
+			r.gz, src = gz, gz
+		}
+		r.br = bufio.NewReader(src)
+		r.ann.reset()
+		return true
+	}
+	return false
+}
+
+// closeFile releases the current file so Next moves on to the following one.
+func (r *Reader) closeFile() {
+	if r.gz != nil {
+		if e := r.gz.Close(); e != nil && r.err == nil {
+			r.err = e
+		}
+	}
+	if r.f != nil {
+		_ = r.f.Close()
+	}
+	r.f, r.gz, r.br = nil, nil, nil
+}
+
+// Line returns the line Next advanced to.
+func (r *Reader) Line() Line {
+	return r.line
+}
+
+// Err returns the first error that stopped Next, or nil at a clean end of the set.
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Close releases the file the Reader has open. It is safe to call more than once.
+func (r *Reader) Close() error {
+	var err error
+	if r.f != nil {
+		err = r.f.Close()
+	}
+	r.f, r.gz, r.br = nil, nil, nil
+	r.next = len(r.files)
+	return err
+}
@@ -0,0 +1,202 @@
+package logread
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prorochestvo/loginjector"
+	"github.com/prorochestvo/loginjector/levels"
+	"github.com/stretchr/testify/require"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, 32)
+
+// writeFile writes content to dir/name, gzipped when name ends in .gz, and sets its mtime.
+func writeFile(t *testing.T, dir, name, content string, mtime time.Time) {
+	t.Helper()
+	data := []byte(content)
+	if filepath.Ext(name) == ".gz" {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		data = buf.Bytes()
+	}
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, data, 0o640))
+	require.NoError(t, os.Chtimes(path, mtime, mtime))
+}
+
+// readAll drains r and returns the texts of its lines.
+func readAll(t *testing.T, r *Reader) []string {
+	t.Helper()
+	var out []string
+	for r.Next() {
+		out = append(out, r.Line().Text)
+	}
+	require.NoError(t, r.Err())
+	require.NoError(t, r.Close())
+	return out
+}
+
+// stamp renders t in the TimestampedHandler layout.
+func stamp(t time.Time) string {
+	return t.Format("2006/01/02 15:04:05")
+}
+
+func TestOpen(t *testing.T) {
+	base := time.Date(2026, 10, 16, 9, 0, 0, 0, time.Local)
+
+	t.Run("backups then live file, gzipped or not", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		writeFile(t, dir, "app.00000001.log.gz", "one\ntwo\n", base)
+		writeFile(t, dir, "app.00000002.log", "three\n", base)
+		writeFile(t, dir, "app.00000003.log", "four\nfive", base) // unterminated tail
+		writeFile(t, dir, "app.errors.00000001.log", "foreign\n", base)
+
+		r, err := Open(dir, "app")
+		require.NoError(t, err)
+		require.Equal(t, []string{"one", "two", "three", "four", "five"}, readAll(t, r))
+	})
+
+	t.Run("sets written by the handlers read back in order", func(t *testing.T) {
+		t.Parallel()
+
+		for name, opts := range map[string][]loginjector.RotatingFileOption{
+			"indexed":   {loginjector.WithMaxFileSize(5), loginjector.WithMaxFiles(50), loginjector.WithCompress()},
+			"stable":    {loginjector.WithMaxFileSize(5), loginjector.WithMaxFiles(50), loginjector.WithStableCurrentName()},
+			"timestamp": {loginjector.WithMaxFileSize(5), loginjector.WithMaxFiles(50), loginjector.WithBackupNaming(loginjector.TimestampBackups())},
+		} {
+			dir := t.TempDir()
+			h := loginjector.RotatingFileHandler(dir, "app", opts...)
+			want := []string{"alpha", "bravo", "charlie", "d"}
+			for _, m := range want {
+				_, err := h.Write([]byte(m))
+				require.NoError(t, err, name)
+			}
+
+			r, err := Open(dir, "app")
+			require.NoError(t, err, name)
+			require.Equal(t, want, readAll(t, r), name)
+		}
+	})
+
+	t.Run("time range selects whole messages and skips old backups", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		// the old backup is not valid gzip: reading it would fail, so it must be skipped.
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "app.00000001.log.gz"), []byte("not gzip"), 0o640))
+		old := base.Add(-time.Hour)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, "app.00000001.log.gz"), old, old))
+		writeFile(t, dir, "app.00000002.log",
+			stamp(base)+" before\n"+
+				stamp(base.Add(time.Minute))+" inside\n"+
+				"                    continued\n"+
+				stamp(base.Add(2*time.Minute))+" at the end bound\n",
+			base.Add(2*time.Minute))
+
+		r, err := Open(dir, "app", WithTimeRange(base.Add(time.Second), base.Add(2*time.Minute)))
+		require.NoError(t, err)
+		var lines []Line
+		for r.Next() {
+			lines = append(lines, r.Line())
+		}
+		require.NoError(t, r.Err())
+		require.Len(t, lines, 2)
+		require.Equal(t, stamp(base.Add(time.Minute))+" inside", lines[0].Text)
+		require.Equal(t, "                    continued", lines[1].Text)
+		require.True(t, lines[1].Time.Equal(base.Add(time.Minute)), "a continuation line inherits the stamp")
+		require.Equal(t, filepath.Join(dir, "app.00000002.log"), lines[1].File)
+	})
+
+	t.Run("level filter uses the classifier", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		writeFile(t, dir, "app.00000001.log",
+			stamp(base)+" [INFO] started\n"+
+				stamp(base)+" ERROR: disk full\n"+
+				"                    on /var\n"+
+				stamp(base)+" no level here\n"+
+				stamp(base)+" critical meltdown\n",
+			base)
+
+		r, err := Open(dir, "app", WithLevel(levels.Error, nil))
+		require.NoError(t, err)
+		var got []Line
+		for r.Next() {
+			got = append(got, r.Line())
+		}
+		require.NoError(t, r.Err())
+		require.Len(t, got, 3)
+		require.Equal(t, levels.Error, got[0].Level)
+		require.Equal(t, "                    on /var", got[1].Text)
+		require.Equal(t, levels.Error, got[1].Level)
+		require.Equal(t, levels.Critical, got[2].Level)
+	})
+
+	t.Run("encrypted files read back with the key", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		_, err := loginjector.RotatingFileHandler(dir, "app").Write([]byte("before encryption"))
+		require.NoError(t, err)
+		h := loginjector.RotatingFileHandler(dir, "app", loginjector.WithMaxFileSize(40), loginjector.WithMaxFiles(50),
+			loginjector.WithCompress(), loginjector.WithEncryption(testKey))
+		for _, m := range []string{"alpha", "bravo", "charlie"} {
+			_, err := h.Write([]byte(m))
+			require.NoError(t, err)
+		}
+
+		r, err := Open(dir, "app", WithKey(testKey))
+		require.NoError(t, err)
+		require.Equal(t, []string{"before encryption", "alpha", "bravo", "charlie"}, readAll(t, r), "older plaintext files are read as they are")
+
+		r, err = Open(dir, "app", WithKey(bytes.Repeat([]byte{0x24}, 32)))
+		require.NoError(t, err)
+		for r.Next() {
+		}
+		require.ErrorIs(t, r.Err(), loginjector.ErrDecrypt)
+		require.NoError(t, r.Close())
+
+		_, err = Open(dir, "app", WithKey([]byte("short")))
+		require.Error(t, err)
+	})
+
+	t.Run("an empty or missing folder reads nothing", func(t *testing.T) {
+		t.Parallel()
+
+		r, err := Open(filepath.Join(t.TempDir(), "absent"), "app")
+		require.NoError(t, err)
+		require.Empty(t, readAll(t, r))
+	})
+}
+
+func TestLevelWord(t *testing.T) {
+	t.Parallel()
+
+	for msg, want := range map[string]loginjector.LogLevel{
+		"ERROR disk full":   levels.Error,
+		"[warn] slow query": levels.Warning,
+		"critical: down":    levels.Critical,
+		"(debug) tick":      levels.Debug,
+		"info ready":        levels.Info,
+		"  severe x":        levels.Severe,
+	} {
+		got, ok := LevelWord(msg)
+		require.True(t, ok, msg)
+		require.Equal(t, want, got, msg)
+	}
+	for _, msg := range []string{"", "user signed in", "information overload"} {
+		_, ok := LevelWord(msg)
+		require.False(t, ok, msg)
+	}
+}
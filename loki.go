@@ -0,0 +1,354 @@
+package loginjector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lokiPushPath is the path of Loki's push API.
+const lokiPushPath = "/loki/api/v1/push"
+
+// LokiOption configures LokiHandler.
+type LokiOption func(*lokiConfig)
+
+type lokiConfig struct {
+	batchEntries int
+	batchWait    time.Duration
+	gzip         bool
+	levelLabel   string
+	levels       map[LogLevel]string
+	recordLabels map[string]bool
+	tenant       string
+	client       *http.Client
+	retry        RetryPolicy
+	onError      func(error)
+	afterFunc    func(d time.Duration, f func()) (stop func()) // withLokiTimer: test seam.
+}
+
+// WithLokiBatch sets when a batch is pushed: once it holds maxEntries entries, or maxWait
+// after its first entry, whichever comes first. The defaults are 1000 entries and 1
+// second; zero or less keeps a default.
+func WithLokiBatch(maxEntries int, maxWait time.Duration) LokiOption {
+	return func(c *lokiConfig) {
+		if maxEntries > 0 {
+			c.batchEntries = maxEntries
+		}
+		if maxWait > 0 {
+			c.batchWait = maxWait
+		}
+	}
+}
+
+// WithLokiGzip compresses every push with gzip.
+func WithLokiGzip() LokiOption {
+	return func(c *lokiConfig) { c.gzip = true }
+}
+
+// WithLokiLevels replaces the table naming the value of the level label of a LogLevel, and
+// the label's name, "level" by default. The default table names the levels package
+// ladder: debug, info, warning, error, severe and critical; a level missing from the
+// table is labelled with its number.
+func WithLokiLevels(label string, table map[LogLevel]string) LokiOption {
+	return func(c *lokiConfig) {
+		c.levelLabel = label
+		c.levels = table
+	}
+}
+
+// WithLokiRecordLabels makes the attributes of a Logger.Log record with the given keys
+// stream labels instead of part of the line. Keep them to values with few distinct
+// values, such as a tenant or a component: every combination is a stream to Loki.
+func WithLokiRecordLabels(keys ...string) LokiOption {
+	return func(c *lokiConfig) {
+		for _, k := range keys {
+			c.recordLabels[k] = true
+		}
+	}
+}
+
+// WithLokiTenant sets the X-Scope-OrgID header of a multi-tenant Loki.
+func WithLokiTenant(id string) LokiOption {
+	return func(c *lokiConfig) { c.tenant = id }
+}
+
+// WithLokiClient sets the HTTP client pushes are sent with. The default is a client with a
+// 20 second timeout.
+func WithLokiClient(client *http.Client) LokiOption {
+	return func(c *lokiConfig) { c.client = client }
+}
+
+// WithLokiRetry sets how a failed push is retried; see Retry. A policy without Retryable
+// retries a *LokiError of status 429 or 5xx, and a failure to reach Loki at all. The
+// default is RetryPolicy's defaults with that classifier.
+func WithLokiRetry(policy RetryPolicy) LokiOption {
+	return func(c *lokiConfig) { c.retry = policy }
+}
+
+// WithLokiOnError registers fn to be called with the error of a batch pushed in the
+// background, when its wait ends. fn runs on the timer's goroutine. Without it those
+// errors are kept and returned by Close.
+func WithLokiOnError(fn func(error)) LokiOption {
+	return func(c *lokiConfig) { c.onError = fn }
+}
+
+// withLokiTimer replaces the timer that ends a batch's wait.
+func withLokiTimer(fn func(d time.Duration, f func()) (stop func())) LokiOption {
+	return func(c *lokiConfig) { c.afterFunc = fn }
+}
+
+// defaultLokiLevels names the levels package ladder, Debug..Critical = 1..6.
+var defaultLokiLevels = map[LogLevel]string{1: "debug", 2: "info", 3: "warning", 4: "error", 5: "severe", 6: "critical"}
+
+// LokiError is the error LokiHandler returns when Loki answers a push with a status
+// outside 2xx.
+type LokiError struct {
+	StatusCode int
+	Body       string        // the start of the response body.
+	RetryAfter time.Duration // the Retry-After header in seconds, zero when absent.
+}
+
+func (e *LokiError) Error() string {
+	s := fmt.Sprintf("loginjector: Loki answered with status code %d", e.StatusCode)
+	if e.Body != "" {
+		s += ": " + e.Body
+	}
+	return s
+}
+
+// retryAfter reports the RetryAfter hint to Retry.
+func (e *LokiError) retryAfter() time.Duration { return e.RetryAfter }
+
+// lokiRetryable is the default classifier of WithLokiRetry: a 429, a 5xx, and any error
+// other than a *LokiError are retried.
+func lokiRetryable(err error) bool {
+	var le *LokiError
+	if errors.As(err, &le) {
+		return le.StatusCode == http.StatusTooManyRequests || le.StatusCode >= 500
+	}
+	return true
+}
+
+// LokiHandler pushes messages to the Grafana Loki at baseURL, e.g. "http://loki:3100", as
+// JSON to its /loki/api/v1/push API; a baseURL already ending in that path is used as is.
+// Every entry carries the static labels, the level label of its level (see
+// WithLokiLevels; a plain Write has none) and, for a Logger.Log record, the attributes
+// named by WithLokiRecordLabels; the other attributes stay in the line as key=value.
+//
+// Entries are batched (WithLokiBatch): a batch that reaches its size is pushed by the
+// Write that fills it, and one that reaches its wait in the background, its error going
+// to WithLokiOnError or else to Close, never to a later Write. A failed push is retried
+// through Retry (WithLokiRetry), on 429 and 5xx by default, and then dropped. Close
+// pushes what is batched and returns its error.
+func LokiHandler(baseURL string, labels map[string]string, opts ...LokiOption) io.Writer {
+	cfg := lokiConfig{
+		batchEntries: 1000,
+		batchWait:    time.Second,
+		levelLabel:   "level",
+		levels:       defaultLokiLevels,
+		recordLabels: map[string]bool{},
+		client:       &http.Client{Timeout: 20 * time.Second},
+		afterFunc:    afterFunc,
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if cfg.retry.Retryable == nil {
+		cfg.retry.Retryable = lokiRetryable
+	}
+	pushURL := strings.TrimSuffix(baseURL, "/")
+	if !strings.HasSuffix(pushURL, lokiPushPath) {
+		pushURL += lokiPushPath
+	}
+
+	l := &loki{cfg: cfg, labels: labels}
+	l.batch = &batcher[lokiEntry]{
+		size:      cfg.batchEntries,
+		wait:      cfg.batchWait,
+		afterFunc: cfg.afterFunc,
+		encode:    l.encode,
+		push:      Retry(&writer{h: func(body []byte) (int, error) { return l.post(pushURL, body) }}, cfg.retry),
+		onError:   cfg.onError,
+	}
+
+	return &writer{
+		h: func(msg []byte) (int, error) {
+			return l.add(nil, time.Now(), msg)
+		},
+		hl: func(level LogLevel, msg []byte) (int, error) {
+			return l.add(l.levelLabels(level, nil), time.Now(), msg)
+		},
+		hr: func(r Record) (int, error) {
+			var own map[string]string
+			var attrs []Attr
+			for _, a := range r.Attrs {
+				if cfg.recordLabels[a.Key] {
+					if own == nil {
+						own = map[string]string{}
+					}
+					own[lokiLabelName(a.Key)] = fmt.Sprint(a.Value)
+					continue
+				}
+				attrs = append(attrs, a)
+			}
+			r.Attrs = attrs
+			t := r.Time
+			if t.IsZero() {
+				t = time.Now()
+			}
+			return l.add(l.levelLabels(r.Level, own), t, r.Text())
+		},
+		closer: l.batch.close,
+	}
+}
+
+// lokiStream is one stream of a push: its labels and its entries, each a timestamp in
+// Unix nanoseconds and a line.
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// lokiEntry is one batched entry: its label set, identified by key, and its value.
+type lokiEntry struct {
+	key    string
+	labels map[string]string
+	value  [2]string
+}
+
+// loki is the state of a LokiHandler.
+type loki struct {
+	cfg    lokiConfig
+	labels map[string]string
+	batch  *batcher[lokiEntry]
+}
+
+// levelLabels returns the labels own gains for level, adding the level label.
+func (l *loki) levelLabels(level LogLevel, own map[string]string) map[string]string {
+	if own == nil {
+		own = map[string]string{}
+	}
+	name, ok := l.cfg.levels[level]
+	if !ok {
+		name = strconv.Itoa(int(level))
+	}
+	own[l.cfg.levelLabel] = name
+	return own
+}
+
+// add batches the entry msg under the static labels and own.
+func (l *loki) add(own map[string]string, t time.Time, msg []byte) (int, error) {
+	set := make(map[string]string, len(l.labels)+len(own))
+	for k, v := range l.labels {
+		set[k] = v
+	}
+	for k, v := range own {
+		set[k] = v
+	}
+	err := l.batch.add(lokiEntry{
+		key:    lokiStreamKey(set),
+		labels: set,
+		value:  [2]string{strconv.FormatInt(t.UnixNano(), 10), strings.TrimRight(string(msg), "\n")},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(msg), nil
+}
+
+// encode renders a batch as a push body: one stream per label set, first seen first.
+func (l *loki) encode(entries []lokiEntry) ([]byte, error) {
+	batch := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	streams := map[string]*lokiStream{}
+	for _, e := range entries {
+		s, ok := streams[e.key]
+		if !ok {
+			s = &lokiStream{Stream: e.labels}
+			streams[e.key] = s
+			batch.Streams = append(batch.Streams, s)
+		}
+		s.Values = append(s.Values, e.value)
+	}
+
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return nil, fmt.Errorf("loginjector: could not encode Loki push: %w", err)
+	}
+	if l.cfg.gzip {
+		if body, err = gzipBody(body); err != nil {
+			return nil, fmt.Errorf("loginjector: could not compress Loki push: %w", err)
+		}
+	}
+	return body, nil
+}
+
+// post sends one push request.
+func (l *loki) post(pushURL string, body []byte) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, pushURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("loginjector: could not create Loki request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if l.cfg.gzip {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+	if l.cfg.tenant != "" {
+		request.Header.Set("X-Scope-OrgID", l.cfg.tenant)
+	}
+
+	response, err := l.cfg.client.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("loginjector: could not push to Loki: %w", err)
+	}
+	defer CloseOrLog(response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		head, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		e := &LokiError{StatusCode: response.StatusCode, Body: strings.TrimSpace(string(head))}
+		if s, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && s > 0 {
+			e.RetryAfter = time.Duration(s) * time.Second
+		}
+		return 0, e
+	}
+	// drain the body so the connection is reused.
+	_, _ = io.Copy(io.Discard, response.Body)
+	return len(body), nil
+}
+
+// lokiStreamKey identifies a label set: its pairs sorted by name.
+func lokiStreamKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + "\x00" + labels[name] + "\x00")
+	}
+	return b.String()
+}
+
+// lokiLabelName maps an attribute key to a valid Loki label name, replacing the
+// characters outside [a-zA-Z0-9_] with _ and prefixing one that starts with a digit.
+func lokiLabelName(key string) string {
+	b := []byte(key)
+	for i, c := range b {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || b[0] >= '0' && b[0] <= '9' {
+		b = append([]byte{'_'}, b...)
+	}
+	return string(b)
+}
@@ -0,0 +1,191 @@
+package loginjector
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lokiStreams decodes the streams of a push the stand-in received.
+func lokiStreams(t *testing.T, push httpRequest) []lokiStream {
+	t.Helper()
+	var body struct {
+		Streams []lokiStream `json:"streams"`
+	}
+	require.NoError(t, json.Unmarshal(push.body, &body))
+	return body.Streams
+}
+
+func TestLokiHandler(t *testing.T) {
+	t.Parallel()
+
+	t.Run("a full batch is pushed as streams by label set", func(t *testing.T) {
+		t.Parallel()
+
+		loki, url := newHTTPStandIn(t)
+		var timer manualTimer
+		l := NewLogger(1, LokiHandler(url, map[string]string{"service": "billing"},
+			WithLokiBatch(3, time.Minute), WithLokiTenant("team-a"), withLokiTimer(timer.afterFunc)))
+		l.Printf(4, "payment failed")
+		l.Printf(2, "retrying")
+		assert.Empty(t, loki.received())
+		l.Printf(4, "payment failed again")
+
+		pushes := loki.received()
+		require.Len(t, pushes, 1)
+		assert.Equal(t, "/loki/api/v1/push", pushes[0].path)
+		assert.Equal(t, "team-a", pushes[0].header.Get("X-Scope-OrgID"))
+		require.Len(t, lokiStreams(t, pushes[0]), 2)
+		errs := lokiStreams(t, pushes[0])[0]
+		assert.Equal(t, map[string]string{"service": "billing", "level": "error"}, errs.Stream)
+		require.Len(t, errs.Values, 2)
+		assert.Contains(t, errs.Values[0][1], "payment failed")
+		assert.Contains(t, errs.Values[1][1], "payment failed again")
+		ts, err := strconv.ParseInt(errs.Values[0][0], 10, 64)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), time.Unix(0, ts), time.Minute)
+		assert.Equal(t, map[string]string{"service": "billing", "level": "info"}, lokiStreams(t, pushes[0])[1].Stream)
+		assert.Nil(t, timer.fn, "the wait timer is stopped")
+	})
+
+	t.Run("a batch is pushed when its wait ends", func(t *testing.T) {
+		t.Parallel()
+
+		loki, url := newHTTPStandIn(t)
+		var timer manualTimer
+		h := LokiHandler(url+"/loki/api/v1/push", nil, WithLokiGzip(), WithLokiBatch(0, 5*time.Second), withLokiTimer(timer.afterFunc))
+		writeRotating(t, h, "plain\n")
+		assert.Equal(t, []time.Duration{5 * time.Second}, timer.delays)
+
+		timer.fire(t)
+		pushes := loki.received()
+		require.Len(t, pushes, 1)
+		assert.Equal(t, "gzip", pushes[0].header.Get("Content-Encoding"))
+		assert.Equal(t, "/loki/api/v1/push", pushes[0].path)
+		require.Len(t, lokiStreams(t, pushes[0]), 1)
+		assert.Empty(t, lokiStreams(t, pushes[0])[0].Stream, "a plain Write has no level")
+		assert.Equal(t, "plain", lokiStreams(t, pushes[0])[0].Values[0][1])
+	})
+
+	t.Run("record attributes become labels or stay in the line", func(t *testing.T) {
+		t.Parallel()
+
+		loki, url := newHTTPStandIn(t)
+		h := LokiHandler(url, map[string]string{"env": "prod"}, WithLokiRecordLabels("tenant.id"), WithLokiBatch(1, 0))
+		at := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+		_, err := writeRecord(h, Record{Time: at, Level: 9, Message: "quota hit", Attrs: []Attr{{"tenant.id", "acme"}, {"used", 105}}})
+		require.NoError(t, err)
+
+		pushes := loki.received()
+		require.Len(t, pushes, 1)
+		s := lokiStreams(t, pushes[0])[0]
+		assert.Equal(t, map[string]string{"env": "prod", "level": "9", "tenant_id": "acme"}, s.Stream)
+		assert.Equal(t, [][2]string{{strconv.FormatInt(at.UnixNano(), 10), "quota hit used=105"}}, s.Values)
+	})
+
+	t.Run("429 and 5xx are retried and 4xx is not", func(t *testing.T) {
+		t.Parallel()
+
+		loki, url := newHTTPStandIn(t)
+		loki.fail, loki.reply = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, "ingester unavailable"
+		var slept waits
+		h := LokiHandler(url, nil, WithLokiBatch(1, 0), WithLokiRetry(RetryPolicy{Attempts: 3, wait: slept.wait}))
+		writeRotating(t, h, "kept\n")
+		assert.Len(t, loki.received(), 1)
+		assert.Len(t, slept, 2)
+
+		loki.mu.Lock()
+		loki.fail = []int{http.StatusBadRequest}
+		loki.mu.Unlock()
+		_, err := h.Write([]byte("rejected\n"))
+		var le *LokiError
+		require.ErrorAs(t, err, &le)
+		assert.Equal(t, http.StatusBadRequest, le.StatusCode)
+		assert.Equal(t, "ingester unavailable", le.Body)
+		assert.Len(t, slept, 2)
+	})
+
+	t.Run("a failed background push is returned by Close, not the next Write", func(t *testing.T) {
+		t.Parallel()
+
+		loki, url := newHTTPStandIn(t)
+		loki.fail = []int{http.StatusBadRequest}
+		var timer manualTimer
+		h := LokiHandler(url, nil, withLokiTimer(timer.afterFunc))
+		writeRotating(t, h, "rejected\n")
+		timer.fire(t)
+
+		n, err := h.Write([]byte("next\n"))
+		require.NoError(t, err, "the entry is batched: failing it would duplicate it behind a Retry")
+		assert.Equal(t, 5, n)
+		var le *LokiError
+		require.ErrorAs(t, closeHandler(h), &le)
+		assert.Equal(t, http.StatusBadRequest, le.StatusCode)
+		require.Len(t, loki.received(), 1)
+		assert.Equal(t, "next", lokiStreams(t, loki.received()[0])[0].Values[0][1])
+	})
+
+	t.Run("WithLokiOnError receives a failed background push", func(t *testing.T) {
+		t.Parallel()
+
+		loki, url := newHTTPStandIn(t)
+		loki.fail = []int{http.StatusBadRequest}
+		var timer manualTimer
+		var got []error
+		h := LokiHandler(url, nil, withLokiTimer(timer.afterFunc), WithLokiOnError(func(err error) { got = append(got, err) }))
+		writeRotating(t, h, "rejected\n")
+		timer.fire(t)
+
+		require.Len(t, got, 1)
+		var le *LokiError
+		assert.ErrorAs(t, got[0], &le)
+		require.NoError(t, closeHandler(h), "the error was reported already")
+	})
+
+	t.Run("a timer firing after its batch was pushed leaves the next batch alone", func(t *testing.T) {
+		t.Parallel()
+
+		loki, url := newHTTPStandIn(t)
+		var timer manualTimer
+		h := LokiHandler(url, nil, WithLokiBatch(2, 0), withLokiTimer(timer.afterFunc))
+		writeRotating(t, h, "a\n")
+		stale := timer.fn // fires while the batch it ends is pushed full.
+		writeRotating(t, h, "b\n")
+		require.Len(t, loki.received(), 1)
+
+		writeRotating(t, h, "c\n")
+		require.NotNil(t, timer.fn, "the next batch's timer")
+		stale()
+		assert.Len(t, loki.received(), 1, "the next batch is not pushed early")
+		writeRotating(t, h, "d\n")
+		assert.Nil(t, timer.fn, "the next batch's timer is still stopped")
+		require.Len(t, loki.received(), 2)
+		assert.Len(t, lokiStreams(t, loki.received()[1])[0].Values, 2)
+	})
+
+	t.Run("Close pushes what is batched", func(t *testing.T) {
+		t.Parallel()
+
+		loki, url := newHTTPStandIn(t)
+		var timer manualTimer
+		h := LokiHandler(url, nil, withLokiTimer(timer.afterFunc))
+		writeRotating(t, h, "last words\n")
+
+		require.NoError(t, closeHandler(h))
+		require.Len(t, loki.received(), 1)
+		assert.Nil(t, timer.fn)
+	})
+}
+
+func TestLokiLabelName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "tenant_id", lokiLabelName("tenant.id"))
+	assert.Equal(t, "_2xx", lokiLabelName("2xx"))
+	assert.Equal(t, "Already_ok", lokiLabelName("Already_ok"))
+}
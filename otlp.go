@@ -0,0 +1,503 @@
+package loginjector
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// otlpLogsPath is the path of the OTLP/HTTP logs endpoint.
+const otlpLogsPath = "/v1/logs"
+
+// otlpScope is the instrumentation scope every record is exported under.
+const otlpScope = "github.com/prorochestvo/loginjector"
+
+// OTLPSeverity is the OpenTelemetry severity of a LogLevel: a SeverityNumber, 1 (TRACE)
+// to 24 (FATAL4), and the SeverityText shown with it.
+type OTLPSeverity struct {
+	Number int
+	Text   string
+}
+
+// OTLPOption configures OTLPHandler.
+type OTLPOption func(*otlpConfig)
+
+type otlpConfig struct {
+	resource     []otlpKeyValue
+	serviceName  string
+	runtime      bool
+	severities   map[LogLevel]OTLPSeverity
+	headers      http.Header
+	client       *http.Client
+	gzip         bool
+	batchRecords int
+	batchWait    time.Duration
+	retry        RetryPolicy
+	onError      func(error)
+	afterFunc    func(d time.Duration, f func()) (stop func()) // withOTLPTimer: test seam.
+}
+
+// WithOTLPServiceName sets the service.name resource attribute. The default is the base
+// name of the executable.
+func WithOTLPServiceName(name string) OTLPOption {
+	return func(c *otlpConfig) { c.serviceName = name }
+}
+
+// WithOTLPResource adds a resource attribute, e.g. ("service.version", "1.4.2") or
+// ("deployment.environment", "prod"). A value is exported as a string, bool, int or
+// double attribute by its type, and as its fmt.Sprint text otherwise.
+func WithOTLPResource(key string, value any) OTLPOption {
+	return func(c *otlpConfig) { c.resource = append(c.resource, otlpKeyValue{key, otlpAnyValue(value)}) }
+}
+
+// WithOTLPRuntimeDetails adds the runtime details of StackTraceError.Runtime to the
+// resource: os as os.type, arch as host.arch, go as process.runtime.version, and every
+// key=value pair of the SetRuntimeDetailsProvider text under its own key. Reading them
+// freezes the details, so set the provider before building the handler.
+func WithOTLPRuntimeDetails() OTLPOption {
+	return func(c *otlpConfig) { c.runtime = true }
+}
+
+// WithOTLPSeverities replaces the table mapping a LogLevel to its OpenTelemetry severity.
+// The default maps the levels package ladder: Debug to DEBUG (5), Info to INFO (9),
+// Warning to WARNING (13), Error to ERROR (17), Severe to SEVERE (20, the top of ERROR)
+// and Critical to CRITICAL (21, FATAL). A level missing from the table is exported with
+// its number as SeverityText and no SeverityNumber.
+func WithOTLPSeverities(table map[LogLevel]OTLPSeverity) OTLPOption {
+	return func(c *otlpConfig) { c.severities = table }
+}
+
+// WithOTLPHeader adds a header to every request, e.g. the API key of a hosted collector.
+func WithOTLPHeader(key, value string) OTLPOption {
+	return func(c *otlpConfig) { c.headers.Add(key, value) }
+}
+
+// WithOTLPClient sets the HTTP client exports are sent with. The default is a client with
+// a 20 second timeout.
+func WithOTLPClient(client *http.Client) OTLPOption {
+	return func(c *otlpConfig) { c.client = client }
+}
+
+// WithOTLPGzip compresses every export with gzip.
+func WithOTLPGzip() OTLPOption {
+	return func(c *otlpConfig) { c.gzip = true }
+}
+
+// WithOTLPBatch sets when a batch is exported: once it holds maxRecords records, or
+// maxWait after its first record, whichever comes first. The defaults are 512 records and
+// 1 second; zero or less keeps a default.
+func WithOTLPBatch(maxRecords int, maxWait time.Duration) OTLPOption {
+	return func(c *otlpConfig) {
+		if maxRecords > 0 {
+			c.batchRecords = maxRecords
+		}
+		if maxWait > 0 {
+			c.batchWait = maxWait
+		}
+	}
+}
+
+// WithOTLPRetry sets how a failed export is retried; see Retry. A policy without
+// Retryable retries what the OTLP/HTTP specification calls retryable — an *OTLPError of
+// status 429, 502, 503 or 504 — and a failure to reach the collector at all. The default
+// is RetryPolicy's defaults with that classifier.
+func WithOTLPRetry(policy RetryPolicy) OTLPOption {
+	return func(c *otlpConfig) { c.retry = policy }
+}
+
+// WithOTLPOnError registers fn to be called with the error of a batch exported in the
+// background, when its wait ends. fn runs on the timer's goroutine. Without it those
+// errors are kept and returned by Close.
+func WithOTLPOnError(fn func(error)) OTLPOption {
+	return func(c *otlpConfig) { c.onError = fn }
+}
+
+// withOTLPTimer replaces the timer that ends a batch's wait.
+func withOTLPTimer(fn func(d time.Duration, f func()) (stop func())) OTLPOption {
+	return func(c *otlpConfig) { c.afterFunc = fn }
+}
+
+// defaultOTLPSeverities maps the levels package ladder, Debug..Critical = 1..6.
+var defaultOTLPSeverities = map[LogLevel]OTLPSeverity{
+	1: {5, "DEBUG"},
+	2: {9, "INFO"},
+	3: {13, "WARNING"},
+	4: {17, "ERROR"},
+	5: {20, "SEVERE"},
+	6: {21, "CRITICAL"},
+}
+
+// otlpRuntimeKeys names the built-in runtime details as resource attributes.
+var otlpRuntimeKeys = map[string]string{"os": "os.type", "arch": "host.arch", "go": "process.runtime.version"}
+
+// OTLPError is the error OTLPHandler returns when the collector answers an export with a
+// status outside 2xx.
+type OTLPError struct {
+	StatusCode int
+	Body       string        // the start of the response body.
+	RetryAfter time.Duration // the Retry-After header in seconds, zero when absent.
+}
+
+func (e *OTLPError) Error() string {
+	s := fmt.Sprintf("loginjector: OTLP collector answered with status code %d", e.StatusCode)
+	if e.Body != "" {
+		s += ": " + e.Body
+	}
+	return s
+}
+
+// retryAfter reports the RetryAfter hint to Retry.
+func (e *OTLPError) retryAfter() time.Duration { return e.RetryAfter }
+
+// otlpRetryable is the default classifier of WithOTLPRetry.
+func otlpRetryable(err error) bool {
+	var oe *OTLPError
+	if errors.As(err, &oe) {
+		switch oe.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// OTLPHandler exports messages to the OpenTelemetry collector at endpoint, e.g.
+// "http://collector:4318", as OTLP/HTTP JSON ExportLogsServiceRequest bodies POSTed to
+// its /v1/logs path; an endpoint already ending in that path is used as is. It needs no
+// protobuf or OpenTelemetry SDK.
+//
+// The level of a message picks its SeverityNumber and SeverityText (WithOTLPSeverities);
+// a plain Write has neither. A Logger.Log record exports its attributes and call site
+// (code.filepath, code.lineno and code.function) as attributes, and an attribute trace_id
+// of 32 hex digits or span_id of 16 — a string, a fmt.Stringer such as the SDK's IDs, or
+// the raw [16]byte or [8]byte — as the record's traceId and spanId. The resource carries
+// service.name, WithOTLPResource attributes and, with WithOTLPRuntimeDetails, the runtime
+// details.
+//
+// Records are batched (WithOTLPBatch) and exported as LokiHandler pushes: by the Write
+// that fills a batch, or in the background once its wait passes, with the error going to
+// WithOTLPOnError or else to Close. A failed export is retried through Retry
+// (WithOTLPRetry) and then dropped. Close exports what is batched and returns its error.
+func OTLPHandler(endpoint string, opts ...OTLPOption) io.Writer {
+	cfg := otlpConfig{
+		serviceName:  filepath.Base(os.Args[0]),
+		severities:   defaultOTLPSeverities,
+		headers:      make(http.Header),
+		client:       &http.Client{Timeout: 20 * time.Second},
+		batchRecords: 512,
+		batchWait:    time.Second,
+		afterFunc:    afterFunc,
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if cfg.retry.Retryable == nil {
+		cfg.retry.Retryable = otlpRetryable
+	}
+	logsURL := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(logsURL, otlpLogsPath) {
+		logsURL += otlpLogsPath
+	}
+
+	resource := []otlpKeyValue{{"service.name", otlpAnyValue(cfg.serviceName)}}
+	if cfg.runtime {
+		for _, field := range strings.Fields(cachedRuntime()) {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok || key == "" {
+				continue
+			}
+			if k, ok := otlpRuntimeKeys[key]; ok {
+				key = k
+			}
+			resource = append(resource, otlpKeyValue{key, otlpAnyValue(value)})
+		}
+	}
+	resource = append(resource, cfg.resource...)
+
+	o := &otlp{cfg: cfg, resource: resource}
+	o.batch = &batcher[otlpLogRecord]{
+		size:      cfg.batchRecords,
+		wait:      cfg.batchWait,
+		afterFunc: cfg.afterFunc,
+		encode:    o.encode,
+		push:      Retry(&writer{h: func(body []byte) (int, error) { return o.post(logsURL, body) }}, cfg.retry),
+		onError:   cfg.onError,
+	}
+
+	add := func(rec otlpLogRecord, n int) (int, error) {
+		if err := o.batch.add(rec); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+	return &writer{
+		h: func(msg []byte) (int, error) {
+			return add(o.record(Record{Time: time.Now(), Message: string(msg)}, false), len(msg))
+		},
+		hl: func(level LogLevel, msg []byte) (int, error) {
+			return add(o.record(Record{Time: time.Now(), Level: level, Message: string(msg)}, true), len(msg))
+		},
+		hr: func(r Record) (int, error) {
+			if r.Time.IsZero() {
+				r.Time = time.Now()
+			}
+			return add(o.record(r, true), len(r.Message))
+		},
+		closer: o.batch.close,
+	}
+}
+
+// The types below are the OTLP JSON encoding of an ExportLogsServiceRequest; 64-bit
+// integers are strings, as the protobuf JSON mapping has them.
+
+type otlpAnyValueJSON struct {
+	StringValue *string     `json:"stringValue,omitempty"`
+	BoolValue   *bool       `json:"boolValue,omitempty"`
+	IntValue    *string     `json:"intValue,omitempty"`
+	DoubleValue *otlpDouble `json:"doubleValue,omitempty"`
+}
+
+// otlpDouble is a double as the protobuf JSON mapping has it: a number, or the string
+// "NaN", "Infinity" or "-Infinity", which JSON numbers cannot express.
+type otlpDouble float64
+
+func (d otlpDouble) MarshalJSON() ([]byte, error) {
+	switch f := float64(d); {
+	case math.IsNaN(f):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(f, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(f, -1):
+		return []byte(`"-Infinity"`), nil
+	}
+	return json.Marshal(float64(d))
+}
+
+func (d *otlpDouble) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) != nil {
+		return json.Unmarshal(b, (*float64)(d))
+	}
+	switch s {
+	case "NaN":
+		*d = otlpDouble(math.NaN())
+	case "Infinity":
+		*d = otlpDouble(math.Inf(1))
+	case "-Infinity":
+		*d = otlpDouble(math.Inf(-1))
+	default:
+		f, err := strconv.ParseFloat(s, 64)
+		*d = otlpDouble(f)
+		return err
+	}
+	return nil
+}
+
+type otlpKeyValue struct {
+	Key   string           `json:"key"`
+	Value otlpAnyValueJSON `json:"value"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         string           `json:"timeUnixNano"`
+	ObservedTimeUnixNano string           `json:"observedTimeUnixNano"`
+	SeverityNumber       int              `json:"severityNumber,omitempty"`
+	SeverityText         string           `json:"severityText,omitempty"`
+	Body                 otlpAnyValueJSON `json:"body"`
+	Attributes           []otlpKeyValue   `json:"attributes,omitempty"`
+	TraceID              string           `json:"traceId,omitempty"`
+	SpanID               string           `json:"spanId,omitempty"`
+}
+
+type otlpScopeLogs struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpResourceLogs struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpExportRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+// otlpAnyValue encodes v as an AnyValue by its type.
+func otlpAnyValue(v any) otlpAnyValueJSON {
+	var out otlpAnyValueJSON
+	switch x := v.(type) {
+	case string:
+		out.StringValue = &x
+	case bool:
+		out.BoolValue = &x
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		s := fmt.Sprint(x)
+		out.IntValue = &s
+	case float32:
+		f := otlpDouble(x)
+		out.DoubleValue = &f
+	case float64:
+		f := otlpDouble(x)
+		out.DoubleValue = &f
+	default:
+		s := fmt.Sprint(x)
+		out.StringValue = &s
+	}
+	return out
+}
+
+// otlp is the state of an OTLPHandler.
+type otlp struct {
+	cfg      otlpConfig
+	resource []otlpKeyValue
+	batch    *batcher[otlpLogRecord]
+}
+
+// record encodes r as a log record; leveled reports whether r.Level is set.
+func (o *otlp) record(r Record, leveled bool) otlpLogRecord {
+	body := strings.TrimRight(r.Message, "\n")
+	rec := otlpLogRecord{
+		TimeUnixNano:         strconv.FormatInt(r.Time.UnixNano(), 10),
+		ObservedTimeUnixNano: strconv.FormatInt(time.Now().UnixNano(), 10),
+		Body:                 otlpAnyValue(body),
+	}
+	if leveled {
+		if s, ok := o.cfg.severities[r.Level]; ok {
+			rec.SeverityNumber, rec.SeverityText = s.Number, s.Text
+		} else {
+			rec.SeverityText = strconv.Itoa(int(r.Level))
+		}
+	}
+	for _, a := range r.Attrs {
+		switch a.Key {
+		case "trace_id":
+			if id, ok := otlpID(a.Value, 16); ok {
+				rec.TraceID = id
+				continue
+			}
+		case "span_id":
+			if id, ok := otlpID(a.Value, 8); ok {
+				rec.SpanID = id
+				continue
+			}
+		}
+		rec.Attributes = append(rec.Attributes, otlpKeyValue{a.Key, otlpAnyValue(a.Value)})
+	}
+	if r.File != "" {
+		rec.Attributes = append(rec.Attributes, otlpKeyValue{"code.filepath", otlpAnyValue(r.File)}, otlpKeyValue{"code.lineno", otlpAnyValue(r.Line)})
+	}
+	if r.Function != "" {
+		rec.Attributes = append(rec.Attributes, otlpKeyValue{"code.function", otlpAnyValue(r.Function)})
+	}
+	return rec
+}
+
+// otlpID renders a trace or span ID of size bytes as lowercase hex, reporting whether v
+// is one.
+func otlpID(v any, size int) (string, bool) {
+	var s string
+	switch x := v.(type) {
+	case [16]byte:
+		s = hex.EncodeToString(x[:])
+	case [8]byte:
+		s = hex.EncodeToString(x[:])
+	case []byte:
+		s = hex.EncodeToString(x)
+	default:
+		s = strings.ToLower(fmt.Sprint(x))
+	}
+	if len(s) != 2*size || strings.Trim(s, "0") == "" {
+		return "", false
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+// encode renders a batch as an ExportLogsServiceRequest.
+func (o *otlp) encode(records []otlpLogRecord) ([]byte, error) {
+	body, err := json.Marshal(o.request(records))
+	var dropErr error
+	if err != nil {
+		// leave out the records that cannot be encoded rather than the batch they are in.
+		var kept []otlpLogRecord
+		var errs []error
+		for _, r := range records {
+			if _, e := json.Marshal(r); e != nil {
+				errs = append(errs, e)
+				continue
+			}
+			kept = append(kept, r)
+		}
+		dropErr = fmt.Errorf("loginjector: could not encode %d OTLP records: %w", len(errs), errors.Join(errs...))
+		if len(kept) == 0 {
+			return nil, dropErr
+		}
+		if body, err = json.Marshal(o.request(kept)); err != nil {
+			return nil, fmt.Errorf("loginjector: could not encode OTLP export: %w", err)
+		}
+	}
+	if o.cfg.gzip {
+		if body, err = gzipBody(body); err != nil {
+			return nil, fmt.Errorf("loginjector: could not compress OTLP export: %w", err)
+		}
+	}
+	return body, dropErr
+}
+
+// request wraps records in an export request under the handler's resource.
+func (o *otlp) request(records []otlpLogRecord) otlpExportRequest {
+	scope := otlpScopeLogs{LogRecords: records}
+	scope.Scope.Name = otlpScope
+	resource := otlpResourceLogs{ScopeLogs: []otlpScopeLogs{scope}}
+	resource.Resource.Attributes = o.resource
+	return otlpExportRequest{ResourceLogs: []otlpResourceLogs{resource}}
+}
+
+// post sends one export request.
+func (o *otlp) post(logsURL string, body []byte) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, logsURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("loginjector: could not create OTLP request: %w", err)
+	}
+	request.Header = o.cfg.headers.Clone()
+	request.Header.Set("Content-Type", "application/json")
+	if o.cfg.gzip {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+
+	response, err := o.cfg.client.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("loginjector: could not export to OTLP collector: %w", err)
+	}
+	defer CloseOrLog(response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		head, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+		e := &OTLPError{StatusCode: response.StatusCode, Body: strings.TrimSpace(string(head))}
+		if s, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && s > 0 {
+			e.RetryAfter = time.Duration(s) * time.Second
+		}
+		return 0, e
+	}
+	// drain the body so the connection is reused.
+	_, _ = io.Copy(io.Discard, response.Body)
+	return len(body), nil
+}
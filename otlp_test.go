@@ -0,0 +1,208 @@
+package loginjector
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// otlpRequest decodes an export the stand-in received.
+func otlpRequest(t *testing.T, export httpRequest) otlpExportRequest {
+	t.Helper()
+	var req otlpExportRequest
+	require.NoError(t, json.Unmarshal(export.body, &req))
+	return req
+}
+
+// otlpAttr returns the value of the attribute key as JSON, "" when absent.
+func otlpAttr(t *testing.T, attrs []otlpKeyValue, key string) string {
+	t.Helper()
+	for _, a := range attrs {
+		if a.Key == key {
+			b, err := json.Marshal(a.Value)
+			require.NoError(t, err)
+			return string(b)
+		}
+	}
+	return ""
+}
+
+// traceID is a stand-in for the SDK's trace.TraceID, which prints as hex.
+type traceID [16]byte
+
+func (id traceID) String() string {
+	return "0af7651916cd43dd8448eb211c80319c"
+}
+
+func TestOTLPHandler(t *testing.T) {
+	t.Parallel()
+
+	t.Run("records are exported with severities, attributes and trace context", func(t *testing.T) {
+		t.Parallel()
+
+		collector, endpoint := newHTTPStandIn(t)
+		h := OTLPHandler(endpoint, WithOTLPServiceName("billing"), WithOTLPResource("deployment.environment", "prod"),
+			WithOTLPHeader("Api-Key", "k"), WithOTLPBatch(2, time.Minute))
+		at := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+		_, err := writeRecord(h, Record{Time: at, Level: 4, Message: "payment failed", File: "billing.go", Line: 12,
+			Attrs: []Attr{{"order_id", 42}, {"trace_id", traceID{}}, {"span_id", [8]byte{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31}}}})
+		require.NoError(t, err)
+		NewLogger(1, h).Printf(2, "retrying")
+
+		exports := collector.received()
+		require.Len(t, exports, 1)
+		assert.Equal(t, "/v1/logs", exports[0].path)
+		assert.Equal(t, "k", exports[0].header.Get("Api-Key"))
+		require.Len(t, otlpRequest(t, exports[0]).ResourceLogs, 1)
+		rl := otlpRequest(t, exports[0]).ResourceLogs[0]
+		assert.Equal(t, `{"stringValue":"billing"}`, otlpAttr(t, rl.Resource.Attributes, "service.name"))
+		assert.Equal(t, `{"stringValue":"prod"}`, otlpAttr(t, rl.Resource.Attributes, "deployment.environment"))
+		require.Len(t, rl.ScopeLogs, 1)
+		assert.Equal(t, otlpScope, rl.ScopeLogs[0].Scope.Name)
+
+		records := rl.ScopeLogs[0].LogRecords
+		require.Len(t, records, 2)
+		first := records[0]
+		assert.Equal(t, "1792324800000000000", first.TimeUnixNano)
+		assert.Equal(t, 17, first.SeverityNumber)
+		assert.Equal(t, "ERROR", first.SeverityText)
+		assert.Equal(t, "payment failed", *first.Body.StringValue)
+		assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", first.TraceID)
+		assert.Equal(t, "b7ad6b7169203331", first.SpanID)
+		assert.Equal(t, `{"intValue":"42"}`, otlpAttr(t, first.Attributes, "order_id"))
+		assert.Equal(t, `{"stringValue":"billing.go"}`, otlpAttr(t, first.Attributes, "code.filepath"))
+		assert.Equal(t, `{"intValue":"12"}`, otlpAttr(t, first.Attributes, "code.lineno"))
+		assert.Empty(t, otlpAttr(t, first.Attributes, "trace_id"))
+
+		assert.Equal(t, 9, records[1].SeverityNumber)
+		assert.Equal(t, "INFO", records[1].SeverityText)
+	})
+
+	t.Run("a non-finite double is exported as its proto-JSON string", func(t *testing.T) {
+		t.Parallel()
+
+		collector, endpoint := newHTTPStandIn(t)
+		h := OTLPHandler(endpoint, WithOTLPBatch(4, time.Minute))
+		for _, v := range []any{math.NaN(), math.Inf(1), math.Inf(-1), 0.5} {
+			_, err := writeRecord(h, Record{Message: "ratio", Attrs: []Attr{{"ratio", v}}})
+			require.NoError(t, err)
+		}
+
+		exports := collector.received()
+		require.Len(t, exports, 1, "one odd value does not fail the batch")
+		records := otlpRequest(t, exports[0]).ResourceLogs[0].ScopeLogs[0].LogRecords
+		require.Len(t, records, 4)
+		for i, want := range []string{`{"doubleValue":"NaN"}`, `{"doubleValue":"Infinity"}`, `{"doubleValue":"-Infinity"}`, `{"doubleValue":0.5}`} {
+			assert.Equal(t, want, otlpAttr(t, records[i].Attributes, "ratio"))
+		}
+	})
+
+	t.Run("an invalid trace ID stays an attribute", func(t *testing.T) {
+		t.Parallel()
+
+		rec := (&otlp{cfg: otlpConfig{severities: defaultOTLPSeverities}}).record(
+			Record{Time: time.Now(), Level: 99, Message: "x", Attrs: []Attr{{"trace_id", "not-hex"}}}, true)
+		assert.Empty(t, rec.TraceID)
+		assert.Equal(t, `{"stringValue":"not-hex"}`, otlpAttr(t, rec.Attributes, "trace_id"))
+		assert.Zero(t, rec.SeverityNumber)
+		assert.Equal(t, "99", rec.SeverityText)
+	})
+
+	t.Run("a batch is exported gzipped when its wait ends", func(t *testing.T) {
+		t.Parallel()
+
+		collector, endpoint := newHTTPStandIn(t)
+		var timer manualTimer
+		h := OTLPHandler(endpoint+"/v1/logs", WithOTLPGzip(), withOTLPTimer(timer.afterFunc))
+		writeRotating(t, h, "plain\n")
+		assert.Empty(t, collector.received())
+
+		timer.fire(t)
+		exports := collector.received()
+		require.Len(t, exports, 1)
+		assert.Equal(t, "gzip", exports[0].header.Get("Content-Encoding"))
+		assert.Equal(t, "/v1/logs", exports[0].path)
+		rec := otlpRequest(t, exports[0]).ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+		assert.Equal(t, "plain", *rec.Body.StringValue)
+		assert.Zero(t, rec.SeverityNumber, "a plain Write has no severity")
+		assert.Empty(t, rec.SeverityText)
+	})
+
+	t.Run("the retryable statuses are retried and others are not", func(t *testing.T) {
+		t.Parallel()
+
+		collector, endpoint := newHTTPStandIn(t)
+		collector.fail = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+		var slept waits
+		h := OTLPHandler(endpoint, WithOTLPBatch(1, 0), WithOTLPRetry(RetryPolicy{wait: slept.wait}))
+		writeRotating(t, h, "kept\n")
+		assert.Len(t, collector.received(), 1)
+		assert.Len(t, slept, 2)
+
+		collector.mu.Lock()
+		collector.fail = []int{http.StatusInternalServerError}
+		collector.mu.Unlock()
+		_, err := h.Write([]byte("dropped\n"))
+		var oe *OTLPError
+		require.ErrorAs(t, err, &oe)
+		assert.Equal(t, http.StatusInternalServerError, oe.StatusCode)
+		assert.Len(t, slept, 2)
+	})
+
+	t.Run("WithOTLPOnError receives a failed background export", func(t *testing.T) {
+		t.Parallel()
+
+		collector, endpoint := newHTTPStandIn(t)
+		collector.fail = []int{http.StatusBadRequest}
+		var timer manualTimer
+		var got []error
+		h := OTLPHandler(endpoint, withOTLPTimer(timer.afterFunc), WithOTLPOnError(func(err error) { got = append(got, err) }))
+		writeRotating(t, h, "rejected\n")
+		timer.fire(t)
+
+		require.Len(t, got, 1)
+		var oe *OTLPError
+		assert.ErrorAs(t, got[0], &oe)
+		writeRotating(t, h, "next\n")
+		require.NoError(t, closeHandler(h))
+		assert.Len(t, collector.received(), 1)
+	})
+
+	t.Run("Close exports what is batched", func(t *testing.T) {
+		t.Parallel()
+
+		collector, endpoint := newHTTPStandIn(t)
+		var timer manualTimer
+		h := OTLPHandler(endpoint, withOTLPTimer(timer.afterFunc))
+		writeRotating(t, h, "last words\n")
+
+		require.NoError(t, closeHandler(h))
+		require.Len(t, collector.received(), 1)
+		assert.Nil(t, timer.fn)
+	})
+}
+
+// TestOTLPHandlerRuntimeDetails is not parallel: it sets the process-wide runtime details
+// provider.
+func TestOTLPHandlerRuntimeDetails(t *testing.T) {
+	t.Cleanup(resetRuntimeCacheForTest)
+	resetRuntimeCacheForTest()
+	SetRuntimeDetailsProvider(func() string { return "service.version=1.4.2 region=eu" })
+
+	collector, endpoint := newHTTPStandIn(t)
+	h := OTLPHandler(endpoint, WithOTLPRuntimeDetails(), WithOTLPBatch(1, 0))
+	writeRotating(t, h, "started\n")
+
+	require.Len(t, collector.received(), 1)
+	attrs := otlpRequest(t, collector.received()[0]).ResourceLogs[0].Resource.Attributes
+	assert.Equal(t, `{"stringValue":"1.4.2"}`, otlpAttr(t, attrs, "service.version"))
+	assert.Equal(t, `{"stringValue":"eu"}`, otlpAttr(t, attrs, "region"))
+	assert.NotEmpty(t, otlpAttr(t, attrs, "os.type"))
+	assert.NotEmpty(t, otlpAttr(t, attrs, "host.arch"))
+	assert.NotEmpty(t, otlpAttr(t, attrs, "process.runtime.version"))
+}
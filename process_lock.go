@@ -0,0 +1,105 @@
+package loginjector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// WithProcessLock serialises the handler's writes and rotations across processes with an
+// advisory flock on prefix.lock in folder, so several processes (or a blue/green pair) can
+// safely share one rotating set. Every Write takes the lock, re-reads the current rotation
+// index that the lock file records, and follows a rotation another process made before
+// appending; the size that triggers rotation is the live file's real on-disk size, not a
+// per-process counter. Construction, the WithFreshStart wipe, and background compression
+// under WithAsyncCompress run under the same lock.
+//
+// The lock is advisory: every writer of the set must pass WithProcessLock, and a process
+// that does not is not excluded. It relies on flock(2), so it is supported on Unix only
+// (on other platforms every Write reports an error) and is not reliable on NFS. The lock
+// file is created with the WithFileMode bits and is left in folder; it never matches the
+// *.log patterns that resume, prune and WithFreshStart scan.
+//
+// Holding the lock for each Write costs two extra syscalls and a short read per message.
+// With WithAsyncCompress a compression holds the lock for its whole run, so it still keeps
+// the triggering Write free but makes other writers to the set wait.
+func WithProcessLock() RotatingFileOption {
+	return func(c *rotatingFileConfig) { c.processLock = true }
+}
+
+// processLock is the cross-process lock behind WithProcessLock: an flock on a lock file
+// that also records the shared rotation index. m serialises this process's goroutines —
+// flock excludes other open file descriptions, not the goroutines sharing this one. The
+// lock file is opened on first use, so a folder created after the handler still works.
+type processLock struct {
+	m    sync.Mutex
+	path string
+	mode os.FileMode
+	f    *os.File
+}
+
+// lockFileName is the WithProcessLock lock file for prefix: prefix.lock.
+func lockFileName(prefix string) string {
+	return prefix + ".lock"
+}
+
+// newProcessLock returns the (not yet opened) lock for prefix in folder; mode is used when
+// the lock file is created.
+func newProcessLock(folder, prefix string, mode os.FileMode) *processLock {
+	return &processLock{path: filepath.Join(folder, lockFileName(prefix)), mode: mode}
+}
+
+// lock acquires the lock for this goroutine, blocking until other processes release it.
+// The descriptor stays open for the life of the handler once opened.
+func (p *processLock) lock() error {
+	p.m.Lock()
+	if p.f == nil {
+		f, err := os.OpenFile(p.path, os.O_RDWR|os.O_CREATE, p.mode)
+		if err != nil {
+			p.m.Unlock()
+			return fmt.Errorf("loginjector: open lock file: %w", err)
+		}
+		p.f = f
+	}
+	if err := flockExclusive(p.f); err != nil {
+		p.m.Unlock()
+		return fmt.Errorf("loginjector: lock %s: %w", p.path, err)
+	}
+	return nil
+}
+
+// unlock releases the lock taken by lock.
+func (p *processLock) unlock() {
+	_ = flockRelease(p.f)
+	p.m.Unlock()
+}
+
+// readIndex returns the rotation index last recorded in the lock file: the live index in
+// indexed mode, the next backup slot under WithStableCurrentName. ok is false when nothing
+// valid has been recorded yet. It must be called with the lock held.
+func (p *processLock) readIndex() (index int, ok bool, err error) {
+	buf := make([]byte, 32)
+	n, err := p.f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return 0, false, err
+	}
+	v, e := strconv.ParseUint(string(bytes.TrimSpace(buf[:n])), 16, 32)
+	if e != nil || v == 0 {
+		return 0, false, nil
+	}
+	return int(v), true, nil
+}
+
+// writeIndex records index in the lock file for the other processes. It must be called
+// with the lock held.
+func (p *processLock) writeIndex(index int) error {
+	if err := p.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := p.f.WriteAt([]byte(fmt.Sprintf("%08X\n", index)), 0)
+	return err
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package loginjector
+
+import (
+	"errors"
+	"os"
+)
+
+// errProcessLockUnsupported reports that WithProcessLock needs flock(2).
+var errProcessLockUnsupported = errors.New("process lock is not supported on this platform")
+
+func flockExclusive(*os.File) error { return errProcessLockUnsupported }
+
+func flockRelease(*os.File) error { return nil }
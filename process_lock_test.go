@@ -0,0 +1,122 @@
+//go:build unix
+
+package loginjector
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWithProcessLock stands two handlers over one (folder, prefix) in for two processes:
+// each opens its own lock-file descriptor, and flock excludes separate open file
+// descriptions even within one process, so the cross-process path is exercised for real.
+func TestWithProcessLock(t *testing.T) {
+	t.Run("a peer follows a rotation it did not make", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		a := RotatingFileHandler(dir, "app", WithMaxFileSize(10), WithMaxFiles(50), WithProcessLock())
+		b := RotatingFileHandler(dir, "app", WithMaxFileSize(10), WithMaxFiles(50), WithProcessLock())
+
+		writeRotating(t, a, "aaaaaaaaaa") // 11 > 10 -> a rotates to index 2
+		writeRotating(t, b, "from-b")     // b was built at index 1 and must follow to 2
+
+		files := extractFilesWithGzOrFail(t, dir)
+		require.Equal(t, "aaaaaaaaaa\n", files[idxName("app", 1)], "the rotated backup must not be revived")
+		require.Equal(t, "from-b\n", files[idxName("app", 2)])
+	})
+
+	t.Run("rotation size counts every writer's bytes", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		a := RotatingFileHandler(dir, "app", WithMaxFileSize(12), WithMaxFiles(50), WithProcessLock())
+		b := RotatingFileHandler(dir, "app", WithMaxFileSize(12), WithMaxFiles(50), WithProcessLock())
+
+		writeRotating(t, a, "aaaaa") // 6
+		writeRotating(t, b, "bbbbb") // 12, still fits
+		writeRotating(t, a, "ccccc") // 18 > 12 -> rotate, though a alone wrote only 12
+
+		files := extractFilesWithGzOrFail(t, dir)
+		require.Equal(t, "aaaaa\nbbbbb\nccccc\n", files[idxName("app", 1)])
+		writeRotating(t, b, "ddddd")
+		files = extractFilesWithGzOrFail(t, dir)
+		require.Equal(t, "ddddd\n", files[idxName("app", 2)])
+	})
+
+	t.Run("lock file is not a rotation file", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(5), WithMaxFiles(2), WithProcessLock())
+		for i := 0; i < 4; i++ {
+			writeRotating(t, h, "abcde")
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, lockFileName("app")))
+		require.NoError(t, err)
+		require.Equal(t, "00000005\n", string(b), "the lock file records the live index")
+	})
+
+	for _, tc := range []struct {
+		name string
+		opts []RotatingFileOption
+	}{
+		{"indexed", nil},
+		{"stable name with compression", []RotatingFileOption{WithStableCurrentName(), WithCompress()}},
+		{"async compression", []RotatingFileOption{WithAsyncCompress()}},
+	} {
+		t.Run("concurrent writers lose nothing: "+tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			opts := append([]RotatingFileOption{WithMaxFileSize(60), WithMaxFiles(1000), WithProcessLock()}, tc.opts...)
+			writers := []io.Writer{
+				RotatingFileHandler(dir, "shared", opts...),
+				RotatingFileHandler(dir, "shared", opts...),
+				RotatingFileHandler(dir, "shared", opts...),
+			}
+
+			var wg sync.WaitGroup
+			var messages sync.Map
+			for w, h := range writers {
+				wg.Add(1)
+				go func(w int, h io.Writer) {
+					defer wg.Done()
+					for i := 0; i < 40; i++ {
+						m := fmt.Sprintf("w%d-%03d-%s", w, i, uniqueToken())
+						messages.Store(m, struct{}{})
+						_, err := h.Write([]byte(m))
+						assert.NoError(t, err)
+					}
+				}(w, h)
+			}
+			wg.Wait()
+			for _, h := range writers {
+				require.NoError(t, h.(io.Closer).Close())
+			}
+
+			seen := make(map[string]int)
+			for name, content := range extractFilesWithGzOrFail(t, dir) {
+				lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+				// a file may exceed the cap by the one line that tripped rotation, no more.
+				assert.LessOrEqual(t, len(content), 60+30, "file %s grew past the shared cap", name)
+				for _, ln := range lines {
+					seen[ln]++
+				}
+			}
+			messages.Range(func(k, _ any) bool {
+				assert.Equal(t, 1, seen[k.(string)], "message %s must appear exactly once", k)
+				return true
+			})
+		})
+	}
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package loginjector
+
+import (
+	"os"
+	"syscall"
+)
+
+// flockExclusive takes an exclusive flock on f, retrying when a signal interrupts the wait.
+func flockExclusive(f *os.File) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// flockRelease drops the flock on f.
+func flockRelease(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
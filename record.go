@@ -0,0 +1,64 @@
+package loginjector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Attr is a key-value attribute of a Record, e.g. Attr{"order_id", 42}.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// Record is one structured log entry, as built by Logger.Log: the message with its
+// attributes and the call site that logged it.
+type Record struct {
+	Time     time.Time
+	Level    LogLevel
+	Message  string
+	Attrs    []Attr
+	File     string // the caller's source file; "" when unknown.
+	Line     int    // the caller's line; 0 when unknown.
+	Function string // the caller's package-qualified function; "" when unknown.
+}
+
+// RecordWriter is an optional interface a handler may implement to receive the Record of
+// a Logger.Log call — its attributes and call site as fields — instead of a rendered line,
+// e.g. JournaldHandler. Logger.Log calls WriteRecord on a handler or hook that implements
+// it and writes Record.Text to the others. Every handler of this package implements it;
+// those with no use for the fields, and wrappers other than WithMinLevel, write the text.
+type RecordWriter interface {
+	io.Writer
+	WriteRecord(r Record) (int, error)
+}
+
+// Text renders r as a log line: the message, then each attribute as key=value with the
+// value quoted when it is empty or holds a space, quote or control character, and a
+// newline.
+func (r Record) Text() []byte {
+	var b bytes.Buffer
+	b.WriteString(r.Message)
+	for _, a := range r.Attrs {
+		v := fmt.Sprint(a.Value)
+		if v == "" || strings.ContainsFunc(v, func(c rune) bool { return c <= ' ' || c == '"' || c == '=' || c == 0x7f }) {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(" " + a.Key + "=" + v)
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+// writeRecord writes r to w, through WriteRecord when w implements RecordWriter and as
+// r.Text at r.Level otherwise.
+func writeRecord(w io.Writer, r Record) (int, error) {
+	if rw, ok := w.(RecordWriter); ok {
+		return rw.WriteRecord(r)
+	}
+	return writeLevel(w, r.Level, r.Text())
+}
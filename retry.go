@@ -0,0 +1,177 @@
+package loginjector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/textproto"
+	"time"
+)
+
+// RetryPolicy configures Retry. Its zero value retries a failed Write twice, after 100ms
+// and 200ms, without jitter or deadline.
+type RetryPolicy struct {
+	// Attempts is the number of times a Write is tried, the first one included. Zero or
+	// less means 3.
+	Attempts int
+	// Backoff is the delay before the first retry, doubled before every further one up to
+	// MaxBackoff. The defaults are 100ms and 10s.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// MaxRetryAfter is the longest retry hint of a sink that is waited for, even past
+	// MaxBackoff; a longer one fails the Write at once. Zero or less means one minute.
+	MaxRetryAfter time.Duration
+	// Jitter randomises each delay by up to this fraction of it in either direction, so
+	// many writers retrying one sink spread out: 0.2 turns a 1s delay into 0.8s–1.2s. It is
+	// clamped to [0, 1].
+	Jitter float64
+	// Retryable reports whether a failed Write is worth retrying. When nil, every error is
+	// retried except a *TelegramError or *WebhookError with a 4xx status other than 429 and
+	// an SMTP reply with a permanent 5xx code, which the same message would get again.
+	Retryable func(err error) bool
+	// Timeout bounds the time one Write spends retrying; zero means no bound. Context, when
+	// set, bounds every Write — e.g. a context cancelled at shutdown.
+	Timeout time.Duration
+	Context context.Context
+
+	// wait is a test seam for the delay between attempts.
+	wait func(ctx context.Context, d time.Duration) error
+}
+
+// retryAfterer is implemented by errors that carry the sink's own retry delay, such as a
+// *TelegramError for HTTP 429.
+type retryAfterer interface {
+	retryAfter() time.Duration
+}
+
+// Retry writes to inner, retrying a Write that fails with an error policy.Retryable accepts
+// up to policy.Attempts times with exponential backoff and jitter. When the error carries
+// the sink's own retry hint — the retry_after of a TelegramHandler 429, the Retry-After
+// of an HTTP sink — that delay is used instead, and a hint longer than MaxRetryAfter
+// fails the Write at once: retrying sooner would only be refused again. Retry never waits
+// past the deadline of policy.Context or policy.Timeout: when the next delay would end
+// after it, the Write fails at once with the last error joined with
+// context.DeadlineExceeded, so a logging call cannot hang on a sink that keeps failing.
+//
+// A Write that reports any byte written is not retried, even when it fails: inner has the
+// message, and its error is returned as is. Retries run inside the Write that failed, so
+// the caller waits for them; use Spool to keep undelivered messages without blocking. The
+// level of a message reaches inner when it implements LevelWriter. Close closes inner when
+// this package built it.
+func Retry(inner io.Writer, policy RetryPolicy) io.Writer {
+	p := policy
+	if p.Attempts <= 0 {
+		p.Attempts = 3
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = 100 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 10 * time.Second
+	}
+	if p.MaxRetryAfter <= 0 {
+		p.MaxRetryAfter = time.Minute
+	}
+	p.Jitter = min(max(p.Jitter, 0), 1)
+	if p.Retryable == nil {
+		p.Retryable = retryableByDefault
+	}
+	if p.Context == nil {
+		p.Context = context.Background()
+	}
+	if p.wait == nil {
+		p.wait = sleepContext
+	}
+
+	write := func(send func() (int, error)) (int, error) {
+		ctx := p.Context
+		if p.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+			defer cancel()
+		}
+
+		delay := p.Backoff
+		for attempt := 1; ; attempt++ {
+			n, err := send()
+			if err == nil || n > 0 {
+				// a byte written means inner has the message; another try would repeat it.
+				return n, err
+			}
+			if !p.Retryable(err) {
+				return n, err
+			}
+			if attempt >= p.Attempts {
+				return n, fmt.Errorf("loginjector: giving up after %d attempts: %w", attempt, err)
+			}
+
+			d := delay
+			if p.Jitter > 0 {
+				d = time.Duration(float64(d) * (1 + p.Jitter*(2*rand.Float64()-1)))
+			}
+			var hint retryAfterer
+			if errors.As(err, &hint) && hint.retryAfter() > 0 {
+				d = hint.retryAfter()
+				if d > p.MaxRetryAfter {
+					return n, fmt.Errorf("loginjector: not retrying: the sink asks for a %s wait, past MaxRetryAfter: %w", d, err)
+				}
+			}
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+				return n, errors.Join(err, context.DeadlineExceeded)
+			}
+			if e := p.wait(ctx, d); e != nil {
+				return n, errors.Join(err, e)
+			}
+			delay = min(2*delay, p.MaxBackoff)
+		}
+	}
+
+	return &writer{
+		h: func(msg []byte) (int, error) {
+			return write(func() (int, error) { return inner.Write(msg) })
+		},
+		hl: func(level LogLevel, msg []byte) (int, error) {
+			return write(func() (int, error) { return writeLevel(inner, level, msg) })
+		},
+		closer: func() error { return closeHandler(inner) },
+	}
+}
+
+// retryableByDefault is RetryPolicy's default classifier: a client error from the Bot API
+// or a webhook, other than 429, and a permanent SMTP reply are not retried.
+func retryableByDefault(err error) bool {
+	var tg *TelegramError
+	if errors.As(err, &tg) {
+		return retryableStatus(tg.StatusCode)
+	}
+	var wh *WebhookError
+	if errors.As(err, &wh) {
+		return retryableStatus(wh.StatusCode)
+	}
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return reply.Code < 500
+	}
+	return true
+}
+
+// retryableStatus reports whether an HTTP status is worth retrying: anything but a 4xx
+// other than 429.
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code < 400 || code >= 500
+}
+
+// sleepContext waits for d, returning ctx's error early when it is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,257 @@
+package loginjector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingWriter fails its first fails Writes with err and counts every call.
+type failingWriter struct {
+	fails int
+	err   error
+	calls int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	f.calls++
+	if f.calls <= f.fails {
+		return 0, f.err
+	}
+	return len(p), nil
+}
+
+// waits records the delays Retry asks for instead of sleeping.
+type waits []time.Duration
+
+func (w *waits) wait(_ context.Context, d time.Duration) error {
+	*w = append(*w, d)
+	return nil
+}
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	t.Run("a transient error is retried with exponential backoff", func(t *testing.T) {
+		t.Parallel()
+
+		inner := &failingWriter{fails: 3, err: errors.New("connection reset")}
+		var slept waits
+		h := Retry(inner, RetryPolicy{Attempts: 4, Backoff: time.Second, MaxBackoff: 3 * time.Second, wait: slept.wait})
+
+		writeRotating(t, h, "kept")
+		assert.Equal(t, 4, inner.calls)
+		assert.Equal(t, waits{time.Second, 2 * time.Second, 3 * time.Second}, slept)
+	})
+
+	t.Run("the last error is returned once the attempts are used up", func(t *testing.T) {
+		t.Parallel()
+
+		cause := errors.New("connection reset")
+		inner := &failingWriter{fails: 10, err: cause}
+		var slept waits
+		h := Retry(inner, RetryPolicy{wait: slept.wait})
+
+		_, err := h.Write([]byte("lost"))
+		require.ErrorIs(t, err, cause)
+		assert.Contains(t, err.Error(), "after 3 attempts")
+		assert.Equal(t, 3, inner.calls)
+	})
+
+	t.Run("the classifier stops retries of a permanent error", func(t *testing.T) {
+		t.Parallel()
+
+		permanent := errors.New("bad credentials")
+		inner := &failingWriter{fails: 10, err: permanent}
+		h := Retry(inner, RetryPolicy{Retryable: func(err error) bool { return !errors.Is(err, permanent) }})
+
+		_, err := h.Write([]byte("lost"))
+		require.ErrorIs(t, err, permanent)
+		assert.Equal(t, 1, inner.calls)
+	})
+
+	t.Run("jitter keeps each delay within its fraction", func(t *testing.T) {
+		t.Parallel()
+
+		inner := &failingWriter{fails: 50, err: errors.New("timeout")}
+		var slept waits
+		h := Retry(inner, RetryPolicy{Attempts: 50, Backoff: time.Second, MaxBackoff: time.Second, Jitter: 0.2, wait: slept.wait})
+
+		_, _ = h.Write([]byte("x"))
+		require.Len(t, slept, 49)
+		for _, d := range slept {
+			assert.True(t, d >= 800*time.Millisecond && d <= 1200*time.Millisecond, d)
+		}
+	})
+
+	t.Run("a delay past the deadline fails the write at once", func(t *testing.T) {
+		t.Parallel()
+
+		inner := &failingWriter{fails: 10, err: errors.New("timeout")}
+		h := Retry(inner, RetryPolicy{Backoff: time.Minute, Timeout: 50 * time.Millisecond})
+
+		start := time.Now()
+		_, err := h.Write([]byte("lost"))
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, 1, inner.calls)
+	})
+
+	t.Run("a cancelled context ends the wait", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		inner := &failingWriter{fails: 10, err: errors.New("timeout")}
+		h := Retry(inner, RetryPolicy{Context: ctx})
+
+		_, err := h.Write([]byte("lost"))
+		require.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, inner.calls)
+	})
+
+	t.Run("a Telegram 429 waits for its retry_after hint", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				w.WriteHeader(http.StatusTooManyRequests)
+				_, _ = fmt.Fprint(w, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 7","parameters":{"retry_after":7}}`)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"ok":true}`)
+		}))
+		t.Cleanup(srv.Close)
+
+		var slept waits
+		h := Retry(TelegramHandlerWithOptions("token", "chat", WithTelegramAPI(srv.URL)), RetryPolicy{wait: slept.wait})
+		writeRotating(t, h, "alert")
+		assert.Equal(t, waits{7 * time.Second}, slept)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("a hint past MaxBackoff is still waited for", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				w.WriteHeader(http.StatusTooManyRequests)
+				_, _ = fmt.Fprint(w, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 35","parameters":{"retry_after":35}}`)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"ok":true}`)
+		}))
+		t.Cleanup(srv.Close)
+
+		var slept waits
+		h := Retry(TelegramHandlerWithOptions("token", "chat", WithTelegramAPI(srv.URL)), RetryPolicy{wait: slept.wait})
+		writeRotating(t, h, "alert")
+		assert.Equal(t, waits{35 * time.Second}, slept, "the default MaxBackoff is 10s")
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("a hint past MaxRetryAfter fails at once", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		t.Cleanup(srv.Close)
+
+		var slept waits
+		h := LokiHandler(srv.URL, nil, WithLokiBatch(1, 0), WithLokiRetry(RetryPolicy{MaxRetryAfter: 10 * time.Minute, wait: slept.wait}))
+		_, err := h.Write([]byte("throttled\n"))
+		var le *LokiError
+		require.ErrorAs(t, err, &le)
+		assert.Equal(t, time.Hour, le.RetryAfter)
+		assert.Empty(t, slept)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("a Telegram client error is not retried by default", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprint(w, `{"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`)
+		}))
+		t.Cleanup(srv.Close)
+
+		h := Retry(TelegramHandlerWithOptions("token", "chat", WithTelegramAPI(srv.URL)), RetryPolicy{})
+		_, err := h.Write([]byte("alert"))
+		var tg *TelegramError
+		require.ErrorAs(t, err, &tg)
+		assert.Equal(t, http.StatusBadRequest, tg.StatusCode)
+		assert.Equal(t, "Bad Request: chat not found", tg.Description)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("a webhook client error is not retried by default, and 429 and 5xx are", func(t *testing.T) {
+		t.Parallel()
+
+		var mu sync.Mutex
+		statuses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK, http.StatusNotFound}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}))
+		t.Cleanup(srv.Close)
+
+		var slept waits
+		h := Retry(WebhookHandler(srv.URL), RetryPolicy{wait: slept.wait})
+		writeRotating(t, h, "delivered on the third try")
+		assert.Len(t, slept, 2)
+
+		_, err := h.Write([]byte("gone"))
+		var we *WebhookError
+		require.ErrorAs(t, err, &we)
+		assert.Equal(t, http.StatusNotFound, we.StatusCode)
+		assert.Len(t, slept, 2, "the 404 is not retried")
+	})
+
+	t.Run("a message the sink took with a deferred error is not sent again", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		var slept waits
+		h := Retry(RotatingFileHandler(dir, "app", WithCompressLevel(100)), RetryPolicy{wait: slept.wait})
+		n, err := h.Write([]byte("hello\n"))
+		require.ErrorContains(t, err, "compression level")
+		assert.Equal(t, 6, n)
+		assert.Empty(t, slept)
+
+		data, err := os.ReadFile(filepath.Join(dir, "app.00000001.log"))
+		require.NoError(t, err)
+		assert.Equal(t, "hello\n", string(data))
+	})
+
+	t.Run("the level reaches the sink", func(t *testing.T) {
+		t.Parallel()
+
+		inner := &gatedWriter{}
+		l := NewLogger(1, Retry(inner, RetryPolicy{}))
+		l.Printf(4, "payment")
+		assert.Equal(t, []LogLevel{4}, inner.levels)
+	})
+}
@@ -0,0 +1,53 @@
+package loginjector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RotatedFiles lists the files of prefix's rotating set in folder in the order their data
+// was written: rotated backups oldest first, then the live file. It applies the naming
+// rules RotatingFileHandler itself uses, so a reader never has to re-implement them, and
+// covers every layout the handler writes: index-named (the highest index is the live
+// file), WithStableCurrentName (the live prefix.log comes last), TimestampBackups, and
+// compressed backups. Names that are not part of the set are ignored.
+//
+// A backup present as both .log and .log.gz (a crash during compression) is listed once,
+// as the .gz, which the handler treats as authoritative; a backup whose compression is
+// still in flight is listed as its plaintext. Plain TimestampBackups names are recognised
+// without options; pass WithBackupNaming with the handler's scheme when it adds
+// WithHostname or WithPID. Other options are ignored. A missing folder yields no files
+// and no error.
+func RotatedFiles(folder, prefix string, opts ...RotatingFileOption) ([]string, error) {
+	var cfg rotatingFileConfig
+	for _, o := range opts {
+		o(&cfg)
+	}
+	naming := cfg.naming
+	if !naming.timestamp {
+		// the timestamp scheme also accepts indexed names, so one scan covers both.
+		naming = TimestampBackups()
+	}
+
+	live := stableLiveName(prefix)
+	groups, err := listBackups(folder, prefix, naming, true, live)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(groups)+1)
+	for _, g := range groups {
+		paths := g.paths
+		pick := paths[0]
+		for _, p := range paths {
+			if strings.HasSuffix(p, "."+gzipExtension) {
+				pick = p
+			}
+		}
+		files = append(files, pick)
+	}
+	if fi, e := os.Stat(filepath.Join(folder, live)); e == nil && fi.Mode().IsRegular() {
+		files = append(files, filepath.Join(folder, live))
+	}
+	return files, nil
+}
@@ -0,0 +1,60 @@
+package loginjector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotatedFiles(t *testing.T) {
+	t.Run("indexed set with compressed backups", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		for _, name := range []string{
+			idxName("app", 1) + "." + gzipExtension,
+			idxName("app", 2), idxName("app", 2) + "." + gzipExtension, // crash-left pair
+			idxName("app", 3),
+			idxName("app.errors", 1), "other.00000001.log", "app-notes.txt",
+		} {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("x\n"), defaultFilePermissions))
+		}
+
+		files, err := RotatedFiles(dir, "app")
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			filepath.Join(dir, idxName("app", 1)+"."+gzipExtension),
+			filepath.Join(dir, idxName("app", 2)+"."+gzipExtension),
+			filepath.Join(dir, idxName("app", 3)),
+		}, files)
+	})
+
+	t.Run("stable and timestamp layouts end with the live file", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		stamp := TimestampBackups().backupName("app", time.Date(2026, 10, 16, 14, 0, 0, 0, time.UTC))
+		for _, name := range []string{"app.log", stamp, idxName("app", 7)} {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("x\n"), defaultFilePermissions))
+		}
+
+		files, err := RotatedFiles(dir, "app")
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			filepath.Join(dir, idxName("app", 7)),
+			filepath.Join(dir, stamp),
+			filepath.Join(dir, "app.log"),
+		}, files, "legacy indexed backups sort before timestamped ones")
+	})
+
+	t.Run("a missing folder lists nothing", func(t *testing.T) {
+		t.Parallel()
+
+		files, err := RotatedFiles(filepath.Join(t.TempDir(), "absent"), "app")
+		require.NoError(t, err)
+		require.Empty(t, files)
+	})
+}
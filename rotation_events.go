@@ -0,0 +1,210 @@
+package loginjector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RotationEvent describes one rotated backup handed to a WithOnRotate callback. It is
+// delivered after the backup is final on disk — after compressFile completes when
+// WithCompress is set — so Path names the file an uploader should ship.
+type RotationEvent struct {
+	// Path is the backup's path, folder joined with the base name, e.g.
+	// "logs/app.00000003.log" or "logs/app.00000003.log.gz".
+	Path string
+	// Compressed reports whether Path is the gzipped .log.gz form.
+	Compressed bool
+	// Size is the backup's on-disk size in bytes (the compressed size when Compressed).
+	Size int64
+	// Start is when the handler began writing the file: the previous rotation, or the
+	// handler's construction for a file resumed from a prior run.
+	Start time.Time
+	// End is when the file was rotated out.
+	End time.Time
+
+	done func()
+}
+
+// Done confirms the backup has been handled (e.g. uploaded). Under WithDeferredPrune the
+// backup is exempt from pruning until Done is called; the next rotation after that prunes
+// it normally if it is past the WithMaxFiles or WithMaxAge bound. Without
+// WithDeferredPrune, Done is a no-op. It is safe to call more than once and from any
+// goroutine.
+func (e RotationEvent) Done() {
+	if e.done != nil {
+		e.done()
+	}
+}
+
+// WithOnRotate registers fn to be called once per rotated backup with a RotationEvent
+// describing it. fn runs on a background goroutine owned by the handler, never on the
+// Write that triggered the rotation, so a slow upload never blocks logging. Events are
+// delivered one at a time, in rotation order; a panic in fn is recovered and reported
+// on stderr so later events are still delivered.
+//
+// Pending callbacks are not waited for by Write. Call Close on the returned writer (or
+// Logger.Close) at shutdown to wait until every queued event has been delivered. A
+// rotation that leaves no backup behind (e.g. a stable-name rotation before the live file
+// was ever written) produces no event.
+func WithOnRotate(fn func(RotationEvent)) RotatingFileOption {
+	return func(c *rotatingFileConfig) { c.onRotate = fn }
+}
+
+// WithDeferredPrune exempts every backup reported to a WithOnRotate callback from
+// pruning until its RotationEvent.Done is called, so a backup is never deleted before the
+// callback confirms it was shipped. Held backups do not count toward the WithMaxFiles
+// bound while they are held; the folder may temporarily exceed the bound when uploads
+// lag. The hold is in-process only: after a restart every backup is prunable again.
+// Without WithOnRotate the option has no effect.
+func WithDeferredPrune() RotatingFileOption {
+	return func(c *rotatingFileConfig) { c.deferPrune = true }
+}
+
+// rotationHold tracks the backups (by base name) reported to WithOnRotate and not yet
+// confirmed via RotationEvent.Done, for WithDeferredPrune.
+type rotationHold struct {
+	m    sync.Mutex
+	held map[string]struct{}
+}
+
+// hold marks base as exempt from pruning and returns the idempotent release func that
+// RotationEvent.Done calls.
+func (h *rotationHold) hold(base string) func() {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if h.held == nil {
+		h.held = make(map[string]struct{})
+	}
+	h.held[base] = struct{}{}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.m.Lock()
+			defer h.m.Unlock()
+			delete(h.held, base)
+		})
+	}
+}
+
+// isHeld reports whether base is still awaiting confirmation. A nil receiver holds nothing.
+func (h *rotationHold) isHeld(base string) bool {
+	if h == nil {
+		return false
+	}
+	h.m.Lock()
+	defer h.m.Unlock()
+	_, ok := h.held[base]
+	return ok
+}
+
+// newRotationEvent stats the finished backup at folder/base and builds its event. ok is
+// false when the backup is absent, in which case no event is delivered.
+func newRotationEvent(folder, base string, start, end time.Time) (e RotationEvent, ok bool) {
+	path := filepath.Join(folder, base)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return RotationEvent{}, false
+	}
+	return RotationEvent{
+		Path:       path,
+		Compressed: filepath.Ext(base) == "."+gzipExtension,
+		Size:       fi.Size(),
+		Start:      start,
+		End:        end,
+	}, true
+}
+
+// backgroundQueue runs queued jobs one at a time, in order, on a single goroutine that
+// exists only while there is work. push never blocks on a running job, so it is safe to
+// call from a Write under the handler mutex; wait blocks until the queue is drained.
+type backgroundQueue struct {
+	m       sync.Mutex
+	idle    *sync.Cond
+	jobs    []func()
+	running bool
+}
+
+// push appends job and starts the worker goroutine if it is not already running.
+func (q *backgroundQueue) push(job func()) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.jobs = append(q.jobs, job)
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+}
+
+// run drains the queue and exits, waking any waiter once nothing is left.
+func (q *backgroundQueue) run() {
+	for {
+		q.m.Lock()
+		if len(q.jobs) == 0 {
+			q.running = false
+			if q.idle != nil {
+				q.idle.Broadcast()
+			}
+			q.m.Unlock()
+			return
+		}
+		job := q.jobs[0]
+		q.jobs[0] = nil
+		q.jobs = q.jobs[1:]
+		q.m.Unlock()
+
+		runRecovered(job)
+	}
+}
+
+// wait blocks until every queued job, including ones pushed while waiting, has run.
+func (q *backgroundQueue) wait() {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.idle == nil {
+		q.idle = sync.NewCond(&q.m)
+	}
+	for q.running {
+		q.idle.Wait()
+	}
+}
+
+// runRecovered calls job, reporting a panic on stderr instead of killing the worker: a
+// faulty user callback must not stop delivery of the events queued behind it.
+func runRecovered(job func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			println(fmt.Sprintf("loginjector: background job panicked: %v", r))
+		}
+	}()
+	job()
+}
+
+// errorSlot collects errors from background jobs until the write path, or Close, takes
+// them; the background goroutine cannot return an error to a caller directly.
+type errorSlot struct {
+	m   sync.Mutex
+	err error
+}
+
+// add records err; a nil err is ignored.
+func (s *errorSlot) add(err error) {
+	if err == nil {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.err = errors.Join(s.err, err)
+}
+
+// take returns the collected errors, or nil, and clears the slot.
+func (s *errorSlot) take() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	err := s.err
+	s.err = nil
+	return err
+}
@@ -0,0 +1,309 @@
+package loginjector
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// eventRecorder collects RotationEvents from a WithOnRotate callback for assertions.
+type eventRecorder struct {
+	m      sync.Mutex
+	events []RotationEvent
+}
+
+func (r *eventRecorder) record(e RotationEvent) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) snapshot() []RotationEvent {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return append([]RotationEvent(nil), r.events...)
+}
+
+func TestRotatingFileHandler_OnRotate(t *testing.T) {
+	t.Run("event describes the compressed backup", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		rec := &eventRecorder{}
+		before := time.Now()
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(7), WithMaxFiles(50), WithCompress(), WithOnRotate(rec.record))
+
+		writeRotating(t, h, "hello") // 6 bytes, fits
+		writeRotating(t, h, "world") // 12 > 7 -> rotate + compress index 1
+		require.NoError(t, h.(io.Closer).Close())
+
+		events := rec.snapshot()
+		require.Len(t, events, 1)
+		ev := events[0]
+		require.Equal(t, filepath.Join(dir, idxName("app", 1)+"."+gzipExtension), ev.Path)
+		require.True(t, ev.Compressed, "the event fires after compressFile, so it names the .gz")
+		fi, err := os.Stat(ev.Path)
+		require.NoError(t, err)
+		require.Equal(t, fi.Size(), ev.Size)
+		require.False(t, ev.Start.Before(before), "start is the construction time for the first file")
+		require.False(t, ev.End.Before(ev.Start))
+	})
+
+	t.Run("events arrive in rotation order for the stable name", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		rec := &eventRecorder{}
+		h := RotatingFileHandler(dir, "access", WithMaxFileSize(5), WithMaxFiles(50), WithStableCurrentName(), WithOnRotate(rec.record))
+
+		for i := 0; i < 3; i++ {
+			writeRotating(t, h, "abcde") // 6 > 5 -> every write rotates
+		}
+		require.NoError(t, h.(io.Closer).Close())
+
+		events := rec.snapshot()
+		require.Len(t, events, 3)
+		for i, ev := range events {
+			require.Equal(t, filepath.Join(dir, idxName("access", i+1)), ev.Path)
+			require.False(t, ev.Compressed)
+			require.EqualValues(t, 6, ev.Size)
+		}
+		require.False(t, events[1].Start.Before(events[0].End), "each span starts where the previous one ended")
+	})
+
+	t.Run("a slow callback never blocks the write path", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		release := make(chan struct{})
+		var delivered sync.WaitGroup
+		delivered.Add(2)
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(5), WithMaxFiles(50), WithOnRotate(func(RotationEvent) {
+			<-release
+			delivered.Done()
+		}))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			writeRotating(t, h, "abcde")
+			writeRotating(t, h, "abcde")
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Write blocked on a pending rotation callback")
+		}
+
+		close(release)
+		require.NoError(t, h.(io.Closer).Close())
+		delivered.Wait()
+	})
+
+	t.Run("deferred prune keeps a backup until Done", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		rec := &eventRecorder{}
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(5), WithMaxFiles(2), WithOnRotate(rec.record), WithDeferredPrune())
+
+		for i := 0; i < 4; i++ {
+			writeRotating(t, h, "abcde")
+		}
+		require.NoError(t, h.(io.Closer).Close())
+		for i := 1; i <= 4; i++ {
+			require.FileExists(t, filepath.Join(dir, idxName("app", i)), "an unconfirmed backup must not be pruned")
+		}
+
+		for _, ev := range rec.snapshot() {
+			ev.Done()
+			ev.Done() // idempotent
+		}
+		writeRotating(t, h, "abcde") // rotates again and prunes the confirmed backups
+		require.NoError(t, h.(io.Closer).Close())
+
+		require.NoFileExists(t, filepath.Join(dir, idxName("app", 1)), "a confirmed backup past the bound is pruned")
+		require.FileExists(t, filepath.Join(dir, idxName("app", 5)), "the newest backup is still held")
+	})
+
+	t.Run("a panicking callback does not stop later events", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		var m sync.Mutex
+		calls := 0
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(5), WithMaxFiles(50), WithOnRotate(func(RotationEvent) {
+			m.Lock()
+			calls++
+			n := calls
+			m.Unlock()
+			if n == 1 {
+				panic("upload failed")
+			}
+		}))
+
+		writeRotating(t, h, "abcde")
+		writeRotating(t, h, "abcde")
+		require.NoError(t, h.(io.Closer).Close())
+
+		m.Lock()
+		defer m.Unlock()
+		require.Equal(t, 2, calls)
+	})
+}
+
+func TestLogger_Close(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	rec := &eventRecorder{}
+	l := NewLogger(1, TimestampedHandler(RotatingFileHandler(dir, "app", WithMaxFileSize(10), WithOnRotate(func(e RotationEvent) {
+		time.Sleep(20 * time.Millisecond)
+		rec.record(e)
+	}))))
+
+	_, err := l.WriteLog(1, []byte("long enough to rotate"))
+	require.NoError(t, err)
+	require.NoError(t, l.Close(), "Close must reach the rotating handler through the timestamp wrapper")
+	require.Len(t, rec.snapshot(), 1, "Close waits for the queued callback")
+}
+
+func TestRotatingFileHandler_AsyncCompress(t *testing.T) {
+	t.Run("backups are gzipped in the background and Close waits", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(5), WithMaxFiles(50), WithAsyncCompress())
+
+		writeRotating(t, h, "first") // 6 > 5 -> rotate index 1
+		writeRotating(t, h, "again") // 6 > 5 -> rotate index 2
+		writeRotating(t, h, "z")     // live index 3
+		require.NoError(t, h.(io.Closer).Close())
+
+		files := extractFilesWithGzOrFail(t, dir)
+		require.Equal(t, "first\n", files[idxName("app", 1)+"."+gzipExtension])
+		require.Equal(t, "again\n", files[idxName("app", 2)+"."+gzipExtension])
+		require.Equal(t, "z\n", files[idxName("app", 3)], "the live file is never compressed")
+		require.NotContains(t, files, idxName("app", 1), "the plaintext is removed once the .gz is durable")
+	})
+
+	t.Run("event fires after the background compression", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		rec := &eventRecorder{}
+		h := RotatingFileHandler(dir, "access", WithMaxFileSize(5), WithMaxFiles(50),
+			WithStableCurrentName(), WithAsyncCompress(), WithOnRotate(rec.record))
+
+		writeRotating(t, h, "abcde")
+		require.NoError(t, h.(io.Closer).Close())
+
+		events := rec.snapshot()
+		require.Len(t, events, 1)
+		require.True(t, events[0].Compressed)
+		require.Equal(t, "abcde\n", readGzOrFail(t, events[0].Path))
+	})
+
+	t.Run("interrupted compressions are queued again on construction", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		// a prior run rotated twice and died before compressing; index 3 is live.
+		require.NoError(t, os.WriteFile(filepath.Join(dir, idxName("app", 1)), []byte("one\n"), defaultFilePermissions))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, idxName("app", 2)), []byte("two\n"), defaultFilePermissions))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, idxName("app", 2)+"."+gzipExtension+"."+tmpExtension), []byte("junk"), defaultFilePermissions))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, idxName("app", 3)), []byte("three\n"), defaultFilePermissions))
+
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(1000), WithMaxFiles(50), WithAsyncCompress())
+		require.NoError(t, h.(io.Closer).Close())
+
+		files := extractFilesWithGzOrFail(t, dir)
+		require.Equal(t, "one\n", files[idxName("app", 1)+"."+gzipExtension])
+		require.Equal(t, "two\n", files[idxName("app", 2)+"."+gzipExtension])
+		require.Equal(t, "three\n", files[idxName("app", 3)], "the resumed live file is left alone")
+		require.NoFileExists(t, filepath.Join(dir, idxName("app", 2)+"."+gzipExtension+"."+tmpExtension))
+	})
+
+	t.Run("prune runs after compression and keeps the bound", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(5), WithMaxFiles(3), WithAsyncCompress())
+		for i := 0; i < 6; i++ {
+			writeRotating(t, h, "abcde")
+		}
+		require.NoError(t, h.(io.Closer).Close())
+
+		files := extractFilesWithGzOrFail(t, dir)
+		require.Len(t, files, 2, "maxFiles-1 backups remain; the live file was never created")
+		require.Contains(t, files, idxName("app", 5)+"."+gzipExtension)
+		require.Contains(t, files, idxName("app", 6)+"."+gzipExtension)
+	})
+}
+
+func TestWithCompressLevel(t *testing.T) {
+	t.Run("a valid level produces a readable gzip", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(5), WithMaxFiles(50), WithCompress(), WithCompressLevel(gzip.BestSpeed))
+		writeRotating(t, h, "abcde")
+
+		require.Equal(t, "abcde\n", readGzOrFail(t, filepath.Join(dir, idxName("app", 1)+"."+gzipExtension)))
+	})
+
+	t.Run("an invalid level is reported and falls back to the default", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := RotatingFileHandler(dir, "app", WithMaxFileSize(5), WithMaxFiles(50), WithCompress(), WithCompressLevel(42))
+
+		_, err := h.Write([]byte("abcde"))
+		require.ErrorContains(t, err, "invalid gzip compression level 42")
+		require.Equal(t, "abcde\n", readGzOrFail(t, filepath.Join(dir, idxName("app", 1)+"."+gzipExtension)))
+
+		_, err = h.Write([]byte("next"))
+		require.NoError(t, err, "the level error is reported once")
+	})
+}
+
+// TestRotatingFileHandler_AsyncCompressForRaceCondition drives concurrent writes while the
+// background compressor gzips and prunes; no message may be lost and the race detector
+// must stay quiet.
+func TestRotatingFileHandler_AsyncCompressForRaceCondition(t *testing.T) {
+	dir := t.TempDir()
+	h := RotatingFileHandler(dir, "race", WithMaxFileSize(70), WithMaxFiles(100), WithAsyncCompress())
+
+	messages := make([]string, 0, 32)
+	for i := 0; i < 32; i++ {
+		messages = append(messages, fmt.Sprintf("%0.3d->>%s", i, uniqueToken()))
+	}
+
+	var wg sync.WaitGroup
+	for _, m := range messages {
+		wg.Add(1)
+		go func(txt string) {
+			defer wg.Done()
+			_, err := h.Write([]byte(txt))
+			assert.NoError(t, err)
+		}(m)
+	}
+	wg.Wait()
+	require.NoError(t, h.(io.Closer).Close())
+
+	all := ""
+	for _, ctx := range extractFilesWithGzOrFail(t, dir) {
+		all += ctx + "\n"
+	}
+	for _, m := range messages {
+		assert.Contains(t, all, m)
+	}
+}
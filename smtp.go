@@ -0,0 +1,284 @@
+package loginjector
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SMTPOption configures SMTPHandler.
+type SMTPOption func(*smtpConfig)
+
+type smtpConfig struct {
+	subject   string
+	labels    []string
+	hostname  string
+	tls       *tls.Config
+	plaintext bool
+	timeout   time.Duration
+}
+
+// SMTPSubject is the data the subject template of SMTPHandler is executed with.
+type SMTPSubject struct {
+	Labels   []string // the labels of WithSMTPLabels.
+	Level    LogLevel // the level of the message, zero for a plain Write.
+	Summary  string   // the first line of the message, cut to 120 characters.
+	Hostname string   // the host the message is sent from.
+}
+
+// smtpDefaultSubject puts each label in brackets before the summary.
+const smtpDefaultSubject = `{{range .Labels}}[{{.}}] {{end}}{{.Summary}}`
+
+// WithSMTPSubject sets the text/template of the subject, executed with an SMTPSubject,
+// e.g. `{{.Hostname}}: {{.Summary}}`. The default puts each label in brackets before the
+// summary: "[billing] [prod] db timeout".
+func WithSMTPSubject(tmpl string) SMTPOption {
+	return func(c *smtpConfig) { c.subject = tmpl }
+}
+
+// WithSMTPLabels sets the service labels, such as a service name and an environment,
+// shown in the subject and above the message in the body.
+func WithSMTPLabels(labels ...string) SMTPOption {
+	return func(c *smtpConfig) { c.labels = labels }
+}
+
+// WithSMTPHostname sets the name sent with EHLO and offered to the subject template. The
+// default is os.Hostname.
+func WithSMTPHostname(name string) SMTPOption {
+	return func(c *smtpConfig) { c.hostname = name }
+}
+
+// WithSMTPTLSConfig sets the TLS configuration of STARTTLS. Without it the system roots
+// verify the server, whose name is taken from addr.
+func WithSMTPTLSConfig(cfg *tls.Config) SMTPOption {
+	return func(c *smtpConfig) { c.tls = cfg }
+}
+
+// WithSMTPAllowPlaintext sends over an unencrypted connection when the server does not
+// offer STARTTLS, instead of failing. Use it only for a relay on a trusted network: an
+// attacker on the path can strip STARTTLS from the server's reply to read the mail.
+func WithSMTPAllowPlaintext() SMTPOption {
+	return func(c *smtpConfig) { c.plaintext = true }
+}
+
+// WithSMTPTimeout bounds sending one email, from connecting to QUIT. The default is 30
+// seconds.
+func WithSMTPTimeout(d time.Duration) SMTPOption {
+	return func(c *smtpConfig) { c.timeout = d }
+}
+
+// SMTPHandler emails every message from from to the addresses of to through the SMTP
+// server at addr ("host:port"), with net/smtp alone. The connection is upgraded with
+// STARTTLS before auth is used, and a server that does not offer it fails the Write unless
+// WithSMTPAllowPlaintext is set; auth may be nil for a relay that needs none, and
+// smtp.PlainAuth refuses to send a password over a connection that is not encrypted
+// unless the server is on localhost.
+//
+// Each email is multipart/alternative with a plain-text and an HTML body, both carrying
+// the labels of WithSMTPLabels above the message, and a subject rendered from
+// WithSMTPSubject. Every message is one connection, so for anything but rare alerts put
+// a Digest in front of the handler: Digest(SMTPHandler(...), 5*time.Minute, 100) sends one
+// email per burst, its subject the digest's "N messages in the last 5m" header. An error
+// of the server is returned wrapped as the *textproto.Error net/smtp reports, and an
+// invalid subject template fails every Write.
+func SMTPHandler(addr string, auth smtp.Auth, from string, to []string, opts ...SMTPOption) io.Writer {
+	cfg := smtpConfig{subject: smtpDefaultSubject, hostname: "localhost", timeout: 30 * time.Second}
+	if h, err := os.Hostname(); err == nil {
+		cfg.hostname = h
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	s := &smtpSender{addr: addr, auth: auth, from: from, to: to, cfg: cfg}
+	s.subject, s.seedErr = template.New("subject").Parse(cfg.subject)
+	if s.seedErr != nil {
+		s.seedErr = fmt.Errorf("loginjector: invalid SMTP subject template: %w", s.seedErr)
+	}
+
+	return &writer{
+		h: func(msg []byte) (int, error) {
+			if err := s.send(0, msg); err != nil {
+				return 0, err
+			}
+			return len(msg), nil
+		},
+		hl: func(level LogLevel, msg []byte) (int, error) {
+			if err := s.send(level, msg); err != nil {
+				return 0, err
+			}
+			return len(msg), nil
+		},
+	}
+}
+
+// smtpSender sends the emails of an SMTPHandler.
+type smtpSender struct {
+	addr    string
+	auth    smtp.Auth
+	from    string
+	to      []string
+	cfg     smtpConfig
+	subject *template.Template
+	seedErr error
+}
+
+// send emails msg in one SMTP session.
+func (s *smtpSender) send(level LogLevel, msg []byte) error {
+	if s.seedErr != nil {
+		return s.seedErr
+	}
+	email, err := s.compose(level, msg)
+	if err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return fmt.Errorf("loginjector: invalid SMTP address: %w", err)
+	}
+	conn, err := net.DialTimeout("tcp", s.addr, s.cfg.timeout)
+	if err != nil {
+		return fmt.Errorf("loginjector: could not connect to SMTP server: %w", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(s.cfg.timeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		CloseOrLog(conn)
+		return fmt.Errorf("loginjector: could not greet SMTP server: %w", err)
+	}
+	// after a failed command the session is abandoned: its error is the one returned, and
+	// after QUIT the connection is already closed.
+	defer func() { _ = c.Close() }()
+
+	if err = c.Hello(s.cfg.hostname); err != nil {
+		return fmt.Errorf("loginjector: SMTP EHLO failed: %w", err)
+	}
+	starttls, _ := c.Extension("STARTTLS")
+	if !starttls && !s.cfg.plaintext {
+		return errors.New("loginjector: SMTP server does not offer STARTTLS")
+	}
+	if starttls {
+		tlsCfg := &tls.Config{ServerName: host}
+		if s.cfg.tls != nil {
+			tlsCfg = s.cfg.tls.Clone()
+			if tlsCfg.ServerName == "" {
+				tlsCfg.ServerName = host
+			}
+		}
+		if err = c.StartTLS(tlsCfg); err != nil {
+			return fmt.Errorf("loginjector: SMTP STARTTLS failed: %w", err)
+		}
+	}
+	if s.auth != nil {
+		if err = c.Auth(s.auth); err != nil {
+			return fmt.Errorf("loginjector: SMTP authentication failed: %w", err)
+		}
+	}
+	if err = c.Mail(s.from); err != nil {
+		return fmt.Errorf("loginjector: SMTP MAIL FROM failed: %w", err)
+	}
+	for _, rcpt := range s.to {
+		if err = c.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("loginjector: SMTP RCPT TO %s failed: %w", rcpt, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("loginjector: SMTP DATA failed: %w", err)
+	}
+	if _, err = w.Write(email); err != nil {
+		return fmt.Errorf("loginjector: could not write SMTP message: %w", err)
+	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("loginjector: SMTP server rejected the message: %w", err)
+	}
+	// the server has the message: failing on QUIT would have a Retry send it again.
+	_ = c.Quit()
+	return nil
+}
+
+// compose renders the email of msg: headers and a multipart/alternative body, with CRLF
+// line endings.
+func (s *smtpSender) compose(level LogLevel, msg []byte) ([]byte, error) {
+	text := strings.TrimRight(string(msg), "\n")
+	summary, _, _ := strings.Cut(text, "\n")
+	if r := []rune(summary); len(r) > 120 {
+		summary = string(r[:120])
+	}
+	subject := &strings.Builder{}
+	data := SMTPSubject{Labels: s.cfg.labels, Level: level, Summary: summary, Hostname: s.cfg.hostname}
+	if err := s.subject.Execute(subject, data); err != nil {
+		return nil, fmt.Errorf("loginjector: could not render SMTP subject: %w", err)
+	}
+	// a line break in a header would end it, or start another one.
+	subj := strings.Join(strings.Fields(subject.String()), " ")
+
+	var plain, rich strings.Builder
+	for _, label := range s.cfg.labels {
+		plain.WriteString(label + "\n")
+		rich.WriteString("<p><b>" + html.EscapeString(label) + "</b></p>\n")
+	}
+	if len(s.cfg.labels) > 0 {
+		plain.WriteString("\n")
+	}
+	plain.WriteString(text + "\n")
+	rich.WriteString("<pre>" + html.EscapeString(text) + "</pre>\n")
+
+	body := &bytes.Buffer{}
+	parts := multipart.NewWriter(body)
+	for _, p := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", plain.String()},
+		{"text/html; charset=utf-8", "<!DOCTYPE html>\n<html><body>\n" + rich.String() + "</body></html>\n"},
+	} {
+		part, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(part)
+		if _, err = io.WriteString(qp, strings.ReplaceAll(p.content, "\n", "\r\n")); err != nil {
+			return nil, err
+		}
+		if err = qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 12)
+	_, _ = rand.Read(id)
+	email := &bytes.Buffer{}
+	for _, h := range [][2]string{
+		{"From", s.from},
+		{"To", strings.Join(s.to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subj)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", "<" + hex.EncodeToString(id) + "@" + s.cfg.hostname + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + parts.Boundary()},
+	} {
+		fmt.Fprintf(email, "%s: %s\r\n", h[0], h[1])
+	}
+	email.WriteString("\r\n")
+	email.Write(body.Bytes())
+	return email.Bytes(), nil
+}
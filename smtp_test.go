@@ -0,0 +1,280 @@
+package loginjector
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smtpMail is one email an smtpStandIn accepted.
+type smtpMail struct {
+	from   string
+	to     []string
+	auth   string // the decoded AUTH PLAIN response, NUL-separated.
+	secure bool   // whether the session was upgraded with STARTTLS.
+	data   string
+}
+
+// smtpStandIn is an in-process SMTP server that offers STARTTLS and AUTH PLAIN and records
+// the emails it accepts.
+type smtpStandIn struct {
+	addr   string
+	tls    *tls.Config
+	reject string // the reply to RCPT TO, e.g. "550 no such user"; empty accepts.
+	strip  bool   // leaves STARTTLS out of the EHLO reply, as a downgrading attacker would.
+	hangup bool   // drops the connection at QUIT instead of answering it.
+
+	mu    sync.Mutex
+	mails []smtpMail
+}
+
+// newSMTPStandIn starts an smtpStandIn and returns it with a client TLS configuration
+// that trusts its certificate.
+func newSMTPStandIn(t *testing.T) (*smtpStandIn, *tls.Config) {
+	// borrow httptest's certificate, valid for 127.0.0.1.
+	certSrv := httptest.NewTLSServer(nil)
+	cert := certSrv.TLS.Certificates
+	roots := x509.NewCertPool()
+	roots.AddCert(certSrv.Certificate())
+	certSrv.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	s := &smtpStandIn{addr: l.Addr().String(), tls: &tls.Config{Certificates: cert}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, &tls.Config{RootCAs: roots}
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 standin ESMTP")
+	var m smtpMail
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			if !m.secure && !s.strip {
+				_ = tp.PrintfLine("250-standin\r\n250-STARTTLS\r\n250 AUTH PLAIN")
+			} else {
+				_ = tp.PrintfLine("250-standin\r\n250 AUTH PLAIN")
+			}
+		case "STARTTLS":
+			_ = tp.PrintfLine("220 ready")
+			tc := tls.Server(conn, s.tls)
+			if tc.Handshake() != nil {
+				return
+			}
+			conn, tp, m.secure = tc, textproto.NewConn(tc), true
+		case "AUTH":
+			raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(arg, "PLAIN "))
+			m.auth = string(raw)
+			_ = tp.PrintfLine("235 accepted")
+		case "MAIL":
+			m.from = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			_ = tp.PrintfLine("250 ok")
+		case "RCPT":
+			if s.reject != "" {
+				_ = tp.PrintfLine("%s", s.reject)
+				continue
+			}
+			m.to = append(m.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			_ = tp.PrintfLine("250 ok")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			m.data = string(data)
+			s.mu.Lock()
+			s.mails = append(s.mails, m)
+			s.mu.Unlock()
+			_ = tp.PrintfLine("250 queued")
+		case "QUIT":
+			if s.hangup {
+				return
+			}
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (s *smtpStandIn) received() []smtpMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpMail(nil), s.mails...)
+}
+
+// parseEmail returns the decoded subject of an email and its bodies by content type.
+func parseEmail(t *testing.T, data string) (string, map[string]string) {
+	t.Helper()
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	require.NoError(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	require.NoError(t, err)
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/alternative", mediaType)
+	bodies := map[string]string{}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(part) // quoted-printable is decoded by NextPart.
+		require.NoError(t, err)
+		contentType, _, _ := strings.Cut(part.Header.Get("Content-Type"), ";")
+		bodies[contentType] = strings.ReplaceAll(string(body), "\r\n", "\n")
+	}
+	return subject, bodies
+}
+
+func TestSMTPHandler(t *testing.T) {
+	t.Parallel()
+
+	t.Run("a message is sent over STARTTLS with labels, text and HTML", func(t *testing.T) {
+		t.Parallel()
+
+		srv, clientTLS := newSMTPStandIn(t)
+		auth := smtp.PlainAuth("", "alerts", "secret", "127.0.0.1")
+		h := SMTPHandler(srv.addr, auth, "alerts@example.com", []string{"oncall@example.com", "ops@example.com"},
+			WithSMTPLabels("billing", "prod"), WithSMTPTLSConfig(clientTLS))
+		l := NewLogger(1, h)
+		l.Printf(4, "payment <failed> for order 7\nstack: main.go:12")
+
+		mails := srv.received()
+		require.Len(t, mails, 1)
+		m := mails[0]
+		assert.True(t, m.secure)
+		assert.Equal(t, "\x00alerts\x00secret", m.auth)
+		assert.Equal(t, "alerts@example.com", m.from)
+		assert.Equal(t, []string{"oncall@example.com", "ops@example.com"}, m.to)
+
+		subject, bodies := parseEmail(t, m.data)
+		assert.Equal(t, "[billing] [prod] payment <failed> for order 7", subject)
+		assert.Equal(t, "billing\nprod\n\npayment <failed> for order 7\nstack: main.go:12\n", bodies["text/plain"])
+		assert.Contains(t, bodies["text/html"], "<pre>payment &lt;failed&gt; for order 7\nstack: main.go:12</pre>")
+		assert.Contains(t, bodies["text/html"], "<p><b>billing</b></p>")
+	})
+
+	t.Run("the subject template sees the level and the hostname", func(t *testing.T) {
+		t.Parallel()
+
+		srv, clientTLS := newSMTPStandIn(t)
+		h := SMTPHandler(srv.addr, nil, "a@example.com", []string{"b@example.com"}, WithSMTPTLSConfig(clientTLS),
+			WithSMTPHostname("api-1"), WithSMTPSubject("{{.Hostname}} level {{.Level}}: {{.Summary}} ✓"))
+		NewLogger(1, h).Printf(5, "disk full")
+
+		require.Len(t, srv.received(), 1)
+		subject, _ := parseEmail(t, srv.received()[0].data)
+		assert.Equal(t, "api-1 level 5: disk full ✓", subject)
+	})
+
+	t.Run("a Digest in front sends one email per window", func(t *testing.T) {
+		t.Parallel()
+
+		srv, clientTLS := newSMTPStandIn(t)
+		var timer manualTimer
+		h := Digest(SMTPHandler(srv.addr, nil, "a@example.com", []string{"b@example.com"},
+			WithSMTPTLSConfig(clientTLS), WithSMTPLabels("api")), time.Minute, 0, withDigestTimer(timer.afterFunc))
+		for range 3 {
+			writeRotating(t, h, "db timeout\n")
+		}
+		assert.Empty(t, srv.received())
+
+		timer.fire(t)
+		require.Len(t, srv.received(), 1)
+		subject, bodies := parseEmail(t, srv.received()[0].data)
+		assert.Equal(t, "[api] 3 messages in the last 1s", subject)
+		assert.Contains(t, bodies["text/plain"], "db timeout (×3)\n")
+	})
+
+	t.Run("a permanent rejection is a textproto.Error Retry does not repeat", func(t *testing.T) {
+		t.Parallel()
+
+		srv, clientTLS := newSMTPStandIn(t)
+		srv.reject = "550 no such user"
+		var slept waits
+		h := Retry(SMTPHandler(srv.addr, nil, "a@example.com", []string{"nobody@example.com"}, WithSMTPTLSConfig(clientTLS)),
+			RetryPolicy{wait: slept.wait})
+
+		_, err := h.Write([]byte("alert"))
+		var reply *textproto.Error
+		require.ErrorAs(t, err, &reply)
+		assert.Equal(t, 550, reply.Code)
+		assert.Empty(t, slept)
+	})
+
+	t.Run("a server not offering STARTTLS fails the write unless plaintext is allowed", func(t *testing.T) {
+		t.Parallel()
+
+		srv, clientTLS := newSMTPStandIn(t)
+		srv.strip = true
+		h := SMTPHandler(srv.addr, nil, "a@example.com", []string{"b@example.com"}, WithSMTPTLSConfig(clientTLS))
+		n, err := h.Write([]byte("alert"))
+		require.ErrorContains(t, err, "does not offer STARTTLS")
+		assert.Zero(t, n)
+		assert.Empty(t, srv.received(), "nothing is sent in the clear")
+
+		h = SMTPHandler(srv.addr, nil, "a@example.com", []string{"b@example.com"}, WithSMTPAllowPlaintext())
+		writeRotating(t, h, "alert")
+		require.Len(t, srv.received(), 1)
+		assert.False(t, srv.received()[0].secure)
+	})
+
+	t.Run("a failed QUIT after the message is accepted does not fail the write", func(t *testing.T) {
+		t.Parallel()
+
+		srv, clientTLS := newSMTPStandIn(t)
+		srv.hangup = true
+		var slept waits
+		h := Retry(SMTPHandler(srv.addr, nil, "a@example.com", []string{"b@example.com"}, WithSMTPTLSConfig(clientTLS)),
+			RetryPolicy{wait: slept.wait})
+		writeRotating(t, h, "alert")
+		assert.Len(t, srv.received(), 1, "the email is sent once")
+		assert.Empty(t, slept)
+	})
+
+	t.Run("an invalid subject template fails every write", func(t *testing.T) {
+		t.Parallel()
+
+		h := SMTPHandler("127.0.0.1:1", nil, "a@example.com", nil, WithSMTPSubject("{{.Summary"))
+		_, err := h.Write([]byte("alert"))
+		require.ErrorContains(t, err, "invalid SMTP subject template")
+	})
+}
@@ -0,0 +1,313 @@
+package loginjector
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSpoolFull is returned by a Spool's Write when the message would take the spool past
+// its WithSpoolMaxBytes bound; the message is not stored.
+var ErrSpoolFull = errors.New("loginjector: spool is full")
+
+// SpoolOption configures Spool.
+type SpoolOption func(*spoolConfig)
+
+type spoolConfig struct {
+	maxBytes   int64            // WithSpoolMaxBytes: bound on the stored messages.
+	minBackoff time.Duration    // WithSpoolBackoff: first retry delay.
+	maxBackoff time.Duration    // WithSpoolBackoff: retry delay cap.
+	retryable  func(error) bool // WithSpoolRetryable: which delivery errors are retried.
+}
+
+// WithSpoolMaxBytes bounds the bytes of undelivered messages the spool keeps on disk. The
+// default is 64 MiB; a n of zero or less removes the bound.
+func WithSpoolMaxBytes(n int64) SpoolOption {
+	return func(c *spoolConfig) { c.maxBytes = n }
+}
+
+// WithSpoolBackoff sets the delay before the first retry of a failed delivery, doubled on
+// every further failure up to max. The defaults are one second and five minutes.
+func WithSpoolBackoff(min, max time.Duration) SpoolOption {
+	return func(c *spoolConfig) {
+		c.minBackoff = min
+		c.maxBackoff = max
+	}
+}
+
+// WithSpoolRetryable sets which delivery errors are retried; a message whose delivery
+// fails with any other error is set aside as a dead letter. The default is the classifier
+// of RetryPolicy: a 4xx from TelegramHandler or WebhookHandler, other than 429, and a
+// permanent SMTP reply are not retried.
+func WithSpoolRetryable(fn func(err error) bool) SpoolOption {
+	return func(c *spoolConfig) { c.retryable = fn }
+}
+
+// spoolExtension names a stored message: <16 hex sequence>[.<level>].msg, the level
+// present when the message came through WriteLevel. A dead letter is a stored message
+// renamed with deadExtension added.
+const (
+	spoolExtension = "msg"
+	deadExtension  = "dead"
+)
+
+// Spool is a store-and-forward wrapper for a network sink such as TelegramHandler: Write
+// stores the message as a file in dir and returns, and a background goroutine delivers
+// the stored messages to inner one at a time, oldest first, removing each once inner
+// accepts it. A failed delivery is retried with exponential backoff (WithSpoolBackoff),
+// and nothing behind it is delivered first, so order is preserved. A message inner rejects
+// for good (WithSpoolRetryable) would block the queue forever instead: it is renamed to
+// <name>.dead in dir, where it no longer counts toward WithSpoolMaxBytes and is left for
+// inspection, and its error is reported on the next Write. Messages left in dir when the
+// process stops are delivered, in order, by the next Spool over dir.
+//
+// Write fails only when the message cannot be stored: with ErrSpoolFull past the
+// WithSpoolMaxBytes bound, or with the file system's error. A message's level reaches
+// inner when inner implements LevelWriter. Close stops the delivery goroutine, waiting for
+// a delivery in progress, and closes inner when this package built it; undelivered
+// messages stay in dir. Only one Spool may use dir at a time.
+//
+// An error reading dir at construction, or removing a delivered message, is reported on
+// the next Write. Temp files of messages a crash left half stored are removed at
+// construction.
+func Spool(dir string, inner io.Writer, opts ...SpoolOption) io.Writer {
+	cfg := spoolConfig{
+		maxBytes:   64 << 20,
+		minBackoff: time.Second,
+		maxBackoff: 5 * time.Minute,
+		retryable:  retryableByDefault,
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	s := &spool{
+		dir:   dir,
+		inner: inner,
+		cfg:   cfg,
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	s.err = s.load()
+	go s.run()
+
+	var closeOnce sync.Once
+	return &writer{
+		h:  func(msg []byte) (int, error) { return s.store(msg, nil) },
+		hl: func(level LogLevel, msg []byte) (int, error) { return s.store(msg, &level) },
+		closer: func() error {
+			closeOnce.Do(func() { close(s.stop) })
+			<-s.done
+			return closeHandler(inner)
+		},
+	}
+}
+
+// spool is the state shared by a Spool's Write and its delivery goroutine.
+type spool struct {
+	dir   string
+	inner io.Writer
+	cfg   spoolConfig
+
+	mu      sync.Mutex
+	queue   []spoolEntry // stored messages, oldest first.
+	size    int64        // bytes stored.
+	nextSeq uint64
+	err     error // a background error not yet reported by a Write.
+
+	wake chan struct{} // signals a newly stored message.
+	stop chan struct{}
+	done chan struct{} // closed when the delivery goroutine returns.
+}
+
+// spoolEntry is a stored message: its file name and size.
+type spoolEntry struct {
+	name string
+	size int64
+}
+
+// load queues the messages an earlier Spool left in dir and removes the temp files of
+// those it was still storing.
+func (s *spool) load() error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if stored, ok := strings.CutSuffix(e.Name(), "."+tmpExtension); ok {
+			if _, _, ok := parseSpoolName(stored); ok {
+				err = errors.Join(err, removeIfExists(filepath.Join(s.dir, e.Name())))
+			}
+			continue
+		}
+		seq, _, ok := parseSpoolName(e.Name())
+		if !ok {
+			continue
+		}
+		fi, infoErr := e.Info()
+		if infoErr != nil {
+			continue
+		}
+		s.queue = append(s.queue, spoolEntry{name: fi.Name(), size: fi.Size()})
+		s.size += fi.Size()
+		s.nextSeq = max(s.nextSeq, seq+1)
+	}
+	// the fixed-width hex sequence sorts by name in store order.
+	sort.Slice(s.queue, func(i, j int) bool { return s.queue[i].name < s.queue[j].name })
+	return err
+}
+
+// store writes msg to a new file in dir and queues it for delivery.
+func (s *spool) store(msg []byte, level *LogLevel) (int, error) {
+	s.mu.Lock()
+	err := s.err
+	s.err = nil
+	if s.cfg.maxBytes > 0 && s.size+int64(len(msg)) > s.cfg.maxBytes {
+		s.mu.Unlock()
+		return 0, errors.Join(err, ErrSpoolFull)
+	}
+	name := fmt.Sprintf("%016x", s.nextSeq)
+	if level != nil {
+		name += "." + strconv.Itoa(int(*level))
+	}
+	name += "." + spoolExtension
+	s.nextSeq++
+	s.mu.Unlock()
+
+	// a temp file renamed into place, so the delivery goroutine and a later run never
+	// see a message half written.
+	path := filepath.Join(s.dir, name)
+	if e := os.WriteFile(path+"."+tmpExtension, msg, 0o600); e != nil {
+		return 0, errors.Join(err, e, removeIfExists(path+"."+tmpExtension))
+	}
+	if e := os.Rename(path+"."+tmpExtension, path); e != nil {
+		return 0, errors.Join(err, e, removeIfExists(path+"."+tmpExtension))
+	}
+
+	s.mu.Lock()
+	s.queue = append(s.queue, spoolEntry{name: name, size: int64(len(msg))})
+	s.size += int64(len(msg))
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return len(msg), err
+}
+
+// run delivers the queued messages in order until stop is closed.
+func (s *spool) run() {
+	defer close(s.done)
+	backoff := s.cfg.minBackoff
+	for {
+		s.mu.Lock()
+		var next spoolEntry
+		if len(s.queue) > 0 {
+			next = s.queue[0]
+		}
+		s.mu.Unlock()
+
+		if next.name == "" {
+			select {
+			case <-s.wake:
+				continue
+			case <-s.stop:
+				return
+			}
+		}
+
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		if err := s.deliver(next); err != nil {
+			select {
+			case <-time.After(backoff):
+			case <-s.stop:
+				return
+			}
+			backoff = min(2*backoff, s.cfg.maxBackoff)
+			continue
+		}
+		backoff = s.cfg.minBackoff
+	}
+}
+
+// deliver writes the stored message m to inner and removes it once inner has it. It
+// returns the error of a delivery worth retrying; a message that can no longer be read is
+// dropped from the queue, and one inner rejects for good is set aside as a dead letter.
+func (s *spool) deliver(m spoolEntry) error {
+	path := filepath.Join(s.dir, m.name)
+	msg, err := os.ReadFile(path)
+	if err != nil {
+		s.dequeue(m, err)
+		return nil
+	}
+	_, level, _ := parseSpoolName(m.name)
+	var n int
+	if level != nil {
+		n, err = writeLevel(s.inner, *level, msg)
+	} else {
+		n, err = s.inner.Write(msg)
+	}
+	switch {
+	case err == nil || n > 0:
+		// inner has the message even when it reports an error as well, such as a file
+		// handler's deferred one: delivering it again would duplicate it.
+		s.dequeue(m, errors.Join(err, removeIfExists(path)))
+	case s.cfg.retryable(err):
+		return err
+	default:
+		dead := path + "." + deadExtension
+		s.dequeue(m, errors.Join(fmt.Errorf("loginjector: spool set %s aside: %w", filepath.Base(dead), err), os.Rename(path, dead)))
+	}
+	return nil
+}
+
+// dequeue drops m, the oldest queued message, keeping err for the next Write.
+func (s *spool) dequeue(m spoolEntry, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = s.queue[1:]
+	s.size -= m.size
+	s.err = errors.Join(s.err, err)
+}
+
+// parseSpoolName reads the sequence and, when present, the level of a stored message's
+// file name.
+func parseSpoolName(name string) (seq uint64, level *LogLevel, ok bool) {
+	rest, found := strings.CutSuffix(name, "."+spoolExtension)
+	if !found {
+		return 0, nil, false
+	}
+	hexSeq, lvl, hasLevel := strings.Cut(rest, ".")
+	if len(hexSeq) != 16 {
+		return 0, nil, false
+	}
+	seq, err := strconv.ParseUint(hexSeq, 16, 64)
+	if err != nil {
+		return 0, nil, false
+	}
+	if hasLevel {
+		n, err := strconv.Atoi(lvl)
+		if err != nil {
+			return 0, nil, false
+		}
+		l := LogLevel(n)
+		level = &l
+	}
+	return seq, level, true
+}
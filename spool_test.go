@@ -0,0 +1,198 @@
+package loginjector
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gatedWriter fails while down and records what it accepts, safe for a Spool's
+// delivery goroutine.
+type gatedWriter struct {
+	mu     sync.Mutex
+	down   bool
+	err    error // what a Write fails with while down; a generic error when nil.
+	got    []string
+	levels []LogLevel
+}
+
+func (g *gatedWriter) Write(p []byte) (int, error) {
+	return g.WriteLevel(-1, p)
+}
+
+func (g *gatedWriter) WriteLevel(level LogLevel, p []byte) (int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.down {
+		if g.err != nil {
+			return 0, g.err
+		}
+		return 0, errors.New("api unreachable")
+	}
+	g.got = append(g.got, string(p))
+	g.levels = append(g.levels, level)
+	return len(p), nil
+}
+
+func (g *gatedWriter) setDown(down bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.down = down
+}
+
+func (g *gatedWriter) received() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.got...)
+}
+
+// spooled counts the messages stored in dir.
+func spooled(t *testing.T, dir string) int {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	n := 0
+	for _, e := range entries {
+		if _, _, ok := parseSpoolName(e.Name()); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSpool(t *testing.T) {
+	t.Parallel()
+
+	t.Run("messages are kept while the sink is down and delivered in order after", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		inner := &gatedWriter{down: true}
+		h := Spool(dir, inner, WithSpoolBackoff(time.Millisecond, 5*time.Millisecond))
+		t.Cleanup(func() { _ = closeHandler(h) })
+
+		for _, s := range []string{"one", "two", "three"} {
+			writeRotating(t, h, s)
+		}
+		assert.Empty(t, inner.received())
+		assert.Equal(t, 3, spooled(t, dir))
+
+		inner.setDown(false)
+		require.Eventually(t, func() bool { return len(inner.received()) == 3 }, 5*time.Second, time.Millisecond)
+		assert.Equal(t, []string{"one", "two", "three"}, inner.received())
+		assert.Equal(t, 0, spooled(t, dir), "delivered messages are removed")
+	})
+
+	t.Run("a new Spool replays what an earlier one left behind", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := Spool(dir, &gatedWriter{down: true})
+		writeRotating(t, h, "before restart")
+		require.NoError(t, closeHandler(h))
+		assert.Equal(t, 1, spooled(t, dir))
+
+		inner := &gatedWriter{}
+		h = Spool(dir, inner)
+		t.Cleanup(func() { _ = closeHandler(h) })
+		writeRotating(t, h, "after restart")
+		require.Eventually(t, func() bool { return len(inner.received()) == 2 }, 5*time.Second, time.Millisecond)
+		assert.Equal(t, []string{"before restart", "after restart"}, inner.received())
+	})
+
+	t.Run("a full spool rejects the message", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		h := Spool(dir, &gatedWriter{down: true}, WithSpoolMaxBytes(8))
+		t.Cleanup(func() { _ = closeHandler(h) })
+
+		writeRotating(t, h, "12345")
+		_, err := h.Write([]byte("6789"))
+		require.ErrorIs(t, err, ErrSpoolFull)
+		assert.Equal(t, 1, spooled(t, dir))
+	})
+
+	t.Run("a message the sink rejects for good is set aside", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		inner := &gatedWriter{down: true, err: &TelegramError{StatusCode: http.StatusBadRequest, Description: "Bad Request: chat not found"}}
+		h := Spool(dir, inner, WithSpoolBackoff(time.Millisecond, 5*time.Millisecond), WithSpoolMaxBytes(8))
+		t.Cleanup(func() { _ = closeHandler(h) })
+
+		writeRotating(t, h, "1234567")
+		dead := filepath.Join(dir, fmt.Sprintf("%016x.%s.%s", 0, spoolExtension, deadExtension))
+		require.Eventually(t, func() bool { _, err := os.Stat(dead); return err == nil }, 5*time.Second, time.Millisecond)
+		assert.Equal(t, 0, spooled(t, dir))
+
+		inner.setDown(false)
+		n, err := h.Write([]byte("next"))
+		assert.Equal(t, 4, n, "the dead letter frees its bytes and the message is stored")
+		var te *TelegramError
+		require.ErrorAs(t, err, &te, "the rejection is reported")
+		require.Eventually(t, func() bool { return len(inner.received()) == 1 }, 5*time.Second, time.Millisecond)
+		assert.Equal(t, []string{"next"}, inner.received())
+	})
+
+	t.Run("a message the sink took with a deferred error is not delivered again", func(t *testing.T) {
+		t.Parallel()
+
+		dir, logs := t.TempDir(), t.TempDir()
+		h := Spool(dir, RotatingFileHandler(logs, "app", WithCompressLevel(100)), WithSpoolBackoff(time.Millisecond, 5*time.Millisecond))
+		t.Cleanup(func() { _ = closeHandler(h) })
+
+		writeRotating(t, h, "hello\n")
+		require.Eventually(t, func() bool { return spooled(t, dir) == 0 }, 5*time.Second, time.Millisecond)
+		_, err := h.Write([]byte("next\n"))
+		require.ErrorContains(t, err, "compression level", "the deferred error is reported")
+		require.Eventually(t, func() bool { return spooled(t, dir) == 0 }, 5*time.Second, time.Millisecond)
+		data, err := os.ReadFile(filepath.Join(logs, "app.00000001.log"))
+		require.NoError(t, err)
+		assert.Equal(t, "hello\nnext\n", string(data))
+	})
+
+	t.Run("temp files are removed and a vanished message frees its bytes", func(t *testing.T) {
+		t.Parallel()
+
+		dir := t.TempDir()
+		tmp := filepath.Join(dir, fmt.Sprintf("%016x.%s.%s", 7, spoolExtension, tmpExtension))
+		require.NoError(t, os.WriteFile(tmp, []byte("half"), 0o600))
+		inner := &gatedWriter{down: true}
+		h := Spool(dir, inner, WithSpoolBackoff(time.Millisecond, 5*time.Millisecond), WithSpoolMaxBytes(8))
+		t.Cleanup(func() { _ = closeHandler(h) })
+		assert.NoFileExists(t, tmp)
+
+		writeRotating(t, h, "12345")
+		require.NoError(t, os.Remove(filepath.Join(dir, fmt.Sprintf("%016x.%s", 0, spoolExtension))))
+		inner.setDown(false)
+		require.Eventually(t, func() bool {
+			n, _ := h.Write([]byte("6789"))
+			return n == 4
+		}, 5*time.Second, time.Millisecond)
+		require.Eventually(t, func() bool { return len(inner.received()) == 1 }, 5*time.Second, time.Millisecond)
+		assert.Equal(t, []string{"6789"}, inner.received())
+	})
+
+	t.Run("the level is stored and reaches the sink", func(t *testing.T) {
+		t.Parallel()
+
+		inner := &gatedWriter{}
+		h := Spool(t.TempDir(), inner)
+		t.Cleanup(func() { _ = closeHandler(h) })
+
+		l := NewLogger(1, h)
+		l.Printf(4, "payment")
+		require.Eventually(t, func() bool { return len(inner.received()) == 1 }, 5*time.Second, time.Millisecond)
+		inner.mu.Lock()
+		defer inner.mu.Unlock()
+		assert.Equal(t, []LogLevel{4}, inner.levels)
+	})
+}
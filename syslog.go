@@ -0,0 +1,299 @@
+package loginjector
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyslogFormat selects the syslog message format SyslogHandler writes.
+type SyslogFormat int
+
+const (
+	// SyslogRFC5424 is the IETF syslog format, with an RFC 3339 timestamp and structured
+	// data. It is the default.
+	SyslogRFC5424 SyslogFormat = iota
+	// SyslogRFC3164 is the BSD syslog format understood by older collectors.
+	SyslogRFC3164
+)
+
+// SyslogOption configures SyslogHandler.
+type SyslogOption func(*syslogConfig)
+
+type syslogConfig struct {
+	format     SyslogFormat
+	facility   int
+	severities map[LogLevel]int
+	appName    string
+	hostname   string
+	sd         []string // rendered structured data elements, in option order.
+	sdErr      error    // an SD-ID or PARAM-NAME RFC 5424 does not allow.
+	tls        *tls.Config
+	timeout    time.Duration
+	clock      func() time.Time // withSyslogClock: test seam for the timestamp.
+}
+
+// WithSyslogFormat selects RFC 5424 (the default) or RFC 3164 messages.
+func WithSyslogFormat(f SyslogFormat) SyslogOption {
+	return func(c *syslogConfig) { c.format = f }
+}
+
+// WithSyslogFacility sets the facility code, 0 to 23, of every message. The default is 1,
+// user-level messages; 16 to 23 are local0 to local7.
+func WithSyslogFacility(facility int) SyslogOption {
+	return func(c *syslogConfig) { c.facility = facility }
+}
+
+// WithSyslogSeverities replaces the table mapping a LogLevel to a syslog severity, 0
+// (emergency) to 7 (debug). The default maps the levels package ladder: Debug to 7, Info
+// to 6, Warning to 4, Error to 3, Severe to 2 and Critical to 1.
+func WithSyslogSeverities(table map[LogLevel]int) SyslogOption {
+	return func(c *syslogConfig) { c.severities = table }
+}
+
+// WithSyslogAppName sets the APP-NAME (RFC 5424) or TAG (RFC 3164) of every message. The
+// default is the base name of the executable. Like the hostname, it is cut to the length
+// RFC 5424 allows, 48 characters (255 for the hostname), and a space or any character
+// outside printable ASCII is replaced with '_', so the header stays parseable.
+func WithSyslogAppName(name string) SyslogOption {
+	return func(c *syslogConfig) { c.appName = name }
+}
+
+// WithSyslogHostname sets the HOSTNAME of every message. The default is os.Hostname.
+func WithSyslogHostname(name string) SyslogOption {
+	return func(c *syslogConfig) { c.hostname = name }
+}
+
+// WithSyslogStructuredData adds an RFC 5424 structured data element with the given SD-ID
+// (e.g. "origin" or "app@32473") and parameters to every message. It may be given more
+// than once; elements keep option order and parameters are sorted by name. RFC 3164
+// messages carry no structured data. The SD-ID and the parameter names must be 1 to 32
+// printable ASCII characters other than space, '=', ']' and '"'; otherwise every Write
+// fails.
+func WithSyslogStructuredData(id string, params map[string]string) SyslogOption {
+	return func(c *syslogConfig) {
+		names := make([]string, 0, len(params))
+		for name := range params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range append([]string{id}, names...) {
+			if !validSDName(name) {
+				c.sdErr = errors.Join(c.sdErr, fmt.Errorf("loginjector: %q is not a valid syslog SD-NAME", name))
+			}
+		}
+
+		var b strings.Builder
+		b.WriteString("[" + id)
+		for _, name := range names {
+			b.WriteString(" " + name + `="` + sdEscaper.Replace(params[name]) + `"`)
+		}
+		b.WriteString("]")
+		c.sd = append(c.sd, b.String())
+	}
+}
+
+// sdEscaper escapes the characters RFC 5424 reserves in a PARAM-VALUE.
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// WithSyslogTLSConfig sets the TLS configuration of the "tls" network. Without it the
+// system roots verify the collector, whose name is taken from addr.
+func WithSyslogTLSConfig(cfg *tls.Config) SyslogOption {
+	return func(c *syslogConfig) { c.tls = cfg }
+}
+
+// WithSyslogTimeout bounds connecting to the collector and writing one message. The
+// default is 10 seconds.
+func WithSyslogTimeout(d time.Duration) SyslogOption {
+	return func(c *syslogConfig) { c.timeout = d }
+}
+
+// withSyslogClock replaces the clock message timestamps are taken from.
+func withSyslogClock(fn func() time.Time) SyslogOption {
+	return func(c *syslogConfig) { c.clock = fn }
+}
+
+// defaultSyslogSeverities maps the levels package ladder, Debug..Critical = 1..6.
+var defaultSyslogSeverities = map[LogLevel]int{1: 7, 2: 6, 3: 4, 4: 3, 5: 2, 6: 1}
+
+// SyslogHandler sends every message to the syslog collector at addr over network: "udp",
+// "tcp", "tls" (TCP with TLS, RFC 5425), "unixgram" or "unix" (a local socket such as
+// /dev/log), or a variant such as "udp4". Each message is one datagram on "udp" and
+// "unixgram", is framed by octet counting (RFC 6587) on "tcp" and "tls", and ends with a
+// newline on "unix", where a newline within the message is sent as "#012", the escape
+// rsyslog shows, so the message stays one; a trailing newline of the message itself is
+// dropped.
+//
+// The level of a message picks its severity through WithSyslogSeverities; a plain Write,
+// and a level missing from the table, is sent as informational (6). The connection is made
+// by the first Write, and a Write that fails on it reconnects and tries once more. That
+// recovers from a connection the collector closed only once a write notices it: over
+// "tcp" and "tls" the first message after a collector restart usually lands in the
+// kernel's buffer for the dead connection and is lost. Close closes the connection.
+func SyslogHandler(network, addr string, opts ...SyslogOption) io.Writer {
+	cfg := syslogConfig{
+		facility:   1,
+		severities: defaultSyslogSeverities,
+		appName:    filepath.Base(os.Args[0]),
+		timeout:    10 * time.Second,
+		clock:      time.Now,
+	}
+	if h, err := os.Hostname(); err == nil {
+		cfg.hostname = h
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	var seedErr error
+	switch network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "tls", "unix", "unixgram":
+	default:
+		seedErr = fmt.Errorf("loginjector: unsupported syslog network %q", network)
+	}
+	if cfg.facility < 0 || cfg.facility > 23 {
+		seedErr = errors.Join(seedErr, fmt.Errorf("loginjector: syslog facility %d is outside 0..23", cfg.facility))
+	}
+	seedErr = errors.Join(seedErr, cfg.sdErr)
+	cfg.hostname = syslogHeaderField(cfg.hostname, 255)
+	cfg.appName = syslogHeaderField(cfg.appName, 48)
+	stream := network != "unixgram" && !strings.HasPrefix(network, "udp")
+	octetCounted := stream && network != "unix"
+
+	var mu sync.Mutex
+	var conn net.Conn
+
+	dial := func() (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+		defer cancel()
+		if network == "tls" {
+			d := tls.Dialer{NetDialer: &net.Dialer{}, Config: cfg.tls}
+			return d.DialContext(ctx, "tcp", addr)
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}
+
+	write := func(msg []byte, severity int) (int, error) {
+		if seedErr != nil {
+			return 0, seedErr
+		}
+		body := bytes.TrimRight(msg, "\n")
+		if stream && !octetCounted {
+			// the newline ends the message on "unix": one within it would split it.
+			body = bytes.ReplaceAll(body, []byte("\n"), []byte("#012"))
+		}
+		frame := cfg.render(body, severity)
+		if octetCounted {
+			frame = append([]byte(strconv.Itoa(len(frame))+" "), frame...)
+		} else if stream {
+			frame = append(frame, '\n')
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		var err error
+		// a second try on a fresh connection rides out a collector restart.
+		for attempt := 0; attempt < 2; attempt++ {
+			if conn == nil {
+				if conn, err = dial(); err != nil {
+					conn = nil
+					continue
+				}
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(cfg.timeout))
+			if _, err = conn.Write(frame); err == nil {
+				return len(msg), nil
+			}
+			_ = conn.Close()
+			conn = nil
+		}
+		return 0, fmt.Errorf("loginjector: syslog %s %s: %w", network, addr, err)
+	}
+
+	return &writer{
+		h: func(msg []byte) (int, error) { return write(msg, 6) },
+		hl: func(level LogLevel, msg []byte) (int, error) {
+			severity, ok := cfg.severities[level]
+			if !ok {
+				severity = 6
+			}
+			return write(msg, severity)
+		},
+		closer: func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			if conn == nil {
+				return nil
+			}
+			err := conn.Close()
+			conn = nil
+			return err
+		},
+	}
+}
+
+// render formats msg as a syslog message of the configured format.
+func (c *syslogConfig) render(msg []byte, severity int) []byte {
+	pri := c.facility*8 + min(max(severity, 0), 7)
+	now := c.clock()
+	var b bytes.Buffer
+	if c.format == SyslogRFC3164 {
+		fmt.Fprintf(&b, "<%d>%s %s %s[%d]: ", pri, now.Format(time.Stamp), orNil(c.hostname), orNil(c.appName), os.Getpid())
+	} else {
+		sd := "-"
+		if len(c.sd) > 0 {
+			sd = strings.Join(c.sd, "")
+		}
+		fmt.Fprintf(&b, "<%d>1 %s %s %s %d - %s ", pri, now.Format("2006-01-02T15:04:05.000000Z07:00"), orNil(c.hostname), orNil(c.appName), os.Getpid(), sd)
+	}
+	b.Write(msg)
+	return b.Bytes()
+}
+
+// syslogHeaderField makes s a valid RFC 5424 header field of at most max characters:
+// printable ASCII without spaces, anything else replaced with '_'.
+func syslogHeaderField(s string, max int) string {
+	b := make([]byte, 0, min(len(s), max))
+	for _, r := range s {
+		if len(b) == max {
+			break
+		}
+		if r < '!' || r > '~' {
+			r = '_'
+		}
+		b = append(b, byte(r))
+	}
+	return string(b)
+}
+
+// validSDName reports whether s is a valid RFC 5424 SD-NAME, as an SD-ID or PARAM-NAME.
+func validSDName(s string) bool {
+	if len(s) == 0 || len(s) > 32 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < '!' || c > '~' || c == '=' || c == ']' || c == '"' {
+			return false
+		}
+	}
+	return true
+}
+
+// orNil returns s, or the RFC 5424 NILVALUE "-" when s is empty.
+func orNil(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
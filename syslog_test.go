@@ -0,0 +1,303 @@
+package loginjector
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syslogStamp is the fixed clock of the syslog tests.
+var syslogStamp = time.Date(2026, 10, 18, 9, 5, 7, 123456000, time.UTC)
+
+// readOctetCounted reads one RFC 6587 octet-counted frame from r.
+func readOctetCounted(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	n, err := r.ReadString(' ')
+	require.NoError(t, err)
+	size, err := strconv.Atoi(strings.TrimSuffix(n, " "))
+	require.NoError(t, err)
+	buf := make([]byte, size)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	return string(buf)
+}
+
+// acceptFrames serves l, sending every octet-counted frame it reads to the returned
+// channel.
+func acceptFrames(l net.Listener) <-chan string {
+	frames := make(chan string, 16)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					n, err := r.ReadString(' ')
+					if err != nil {
+						return
+					}
+					size, _ := strconv.Atoi(strings.TrimSuffix(n, " "))
+					buf := make([]byte, size)
+					if _, err := io.ReadFull(r, buf); err != nil {
+						return
+					}
+					frames <- string(buf)
+				}
+			}()
+		}
+	}()
+	return frames
+}
+
+func receive(t *testing.T, frames <-chan string) string {
+	t.Helper()
+	select {
+	case f := <-frames:
+		return f
+	case <-time.After(5 * time.Second):
+		t.Fatal("no syslog message received")
+		return ""
+	}
+}
+
+func TestSyslogHandler(t *testing.T) {
+	t.Parallel()
+
+	pid := os.Getpid()
+
+	t.Run("RFC 5424 over UDP maps the level to a severity", func(t *testing.T) {
+		t.Parallel()
+
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = pc.Close() })
+
+		h := SyslogHandler("udp", pc.LocalAddr().String(), WithSyslogHostname("web-1"), WithSyslogAppName("billing"),
+			WithSyslogStructuredData("origin", map[string]string{"software": "loginjector", "note": `a "quoted" ]`}),
+			withSyslogClock(func() time.Time { return syslogStamp }))
+		t.Cleanup(func() { _ = closeHandler(h) })
+
+		l := NewLogger(1, h)
+		l.Printf(4, "card declined")
+
+		buf := make([]byte, 1024)
+		require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := pc.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf(`<11>1 2026-10-18T09:05:07.123456Z web-1 billing %d - [origin note="a \"quoted\" \]" software="loginjector"] card declined`, pid), string(buf[:n]))
+	})
+
+	t.Run("RFC 3164 over TCP is octet counted", func(t *testing.T) {
+		t.Parallel()
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = l.Close() })
+		frames := acceptFrames(l)
+
+		h := SyslogHandler("tcp", l.Addr().String(), WithSyslogFormat(SyslogRFC3164), WithSyslogFacility(16),
+			WithSyslogHostname("web-1"), WithSyslogAppName("billing"), withSyslogClock(func() time.Time { return syslogStamp }))
+		t.Cleanup(func() { _ = closeHandler(h) })
+
+		writeRotating(t, h, "first line\nsecond line\n")
+		_, err = h.(LevelWriter).WriteLevel(1, []byte("debug"))
+		require.NoError(t, err)
+
+		assert.Equal(t, fmt.Sprintf("<134>Oct 18 09:05:07 web-1 billing[%d]: first line\nsecond line", pid), receive(t, frames))
+		assert.Equal(t, fmt.Sprintf("<135>Oct 18 09:05:07 web-1 billing[%d]: debug", pid), receive(t, frames))
+	})
+
+	t.Run("a custom severity table is used", func(t *testing.T) {
+		t.Parallel()
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = l.Close() })
+		frames := acceptFrames(l)
+
+		h := SyslogHandler("tcp", l.Addr().String(), WithSyslogSeverities(map[LogLevel]int{10: 0}))
+		t.Cleanup(func() { _ = closeHandler(h) })
+		_, err = h.(LevelWriter).WriteLevel(10, []byte("meltdown"))
+		require.NoError(t, err)
+		_, err = h.(LevelWriter).WriteLevel(4, []byte("unmapped"))
+		require.NoError(t, err)
+
+		assert.True(t, strings.HasPrefix(receive(t, frames), "<8>1 "))
+		assert.True(t, strings.HasPrefix(receive(t, frames), "<14>1 "), "a level missing from the table is informational")
+	})
+
+	t.Run("TLS is supported", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewUnstartedServer(nil)
+		srv.StartTLS()
+		cert := srv.TLS.Certificates[0]
+		roots := x509.NewCertPool()
+		roots.AddCert(srv.Certificate())
+		srv.Close()
+
+		l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = l.Close() })
+		frames := acceptFrames(l)
+
+		h := SyslogHandler("tls", l.Addr().String(), WithSyslogTLSConfig(&tls.Config{RootCAs: roots}))
+		t.Cleanup(func() { _ = closeHandler(h) })
+		writeRotating(t, h, "over tls")
+		assert.True(t, strings.HasSuffix(receive(t, frames), " - - over tls"))
+	})
+
+	t.Run("a unixgram socket receives one datagram per message", func(t *testing.T) {
+		t.Parallel()
+
+		// a short path: unix socket paths are limited to about 100 bytes.
+		dir, err := os.MkdirTemp("", "sl")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = os.RemoveAll(dir) })
+		path := filepath.Join(dir, "log")
+		pc, err := net.ListenPacket("unixgram", path)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = pc.Close() })
+
+		h := SyslogHandler("unixgram", path)
+		t.Cleanup(func() { _ = closeHandler(h) })
+		writeRotating(t, h, "local")
+
+		buf := make([]byte, 1024)
+		require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := pc.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(string(buf[:n]), " - - local"), string(buf[:n]))
+	})
+
+	t.Run("a unix stream socket receives one line per message", func(t *testing.T) {
+		t.Parallel()
+
+		dir, err := os.MkdirTemp("", "sl")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = os.RemoveAll(dir) })
+		path := filepath.Join(dir, "log")
+		l, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = l.Close() })
+		lines := make(chan string, 4)
+		go func() {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			r := bufio.NewReader(c)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				lines <- line
+			}
+		}()
+
+		h := SyslogHandler("unix", path)
+		t.Cleanup(func() { _ = closeHandler(h) })
+		writeRotating(t, h, "panic: boom\ngoroutine 1\n")
+		writeRotating(t, h, "next")
+		assert.True(t, strings.HasSuffix(receive(t, lines), " - - panic: boom#012goroutine 1\n"))
+		assert.True(t, strings.HasSuffix(receive(t, lines), " - - next\n"))
+	})
+
+	t.Run("the handler reconnects after the collector restarts", func(t *testing.T) {
+		t.Parallel()
+
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := l.Addr().String()
+		first := make(chan net.Conn, 1)
+		go func() {
+			c, err := l.Accept()
+			if err == nil {
+				first <- c
+			}
+		}()
+
+		h := SyslogHandler("tcp", addr)
+		t.Cleanup(func() { _ = closeHandler(h) })
+		writeRotating(t, h, "before")
+		c := <-first
+		r := bufio.NewReader(c)
+		assert.True(t, strings.HasSuffix(readOctetCounted(t, r), " before"))
+		require.NoError(t, c.Close())
+		require.NoError(t, l.Close())
+
+		l, err = net.Listen("tcp", addr)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = l.Close() })
+		frames := acceptFrames(l)
+
+		// a write into the dropped connection may still be accepted by the kernel, so keep
+		// writing until one reaches the new collector.
+		require.Eventually(t, func() bool {
+			_, _ = h.Write([]byte("after"))
+			select {
+			case f := <-frames:
+				return strings.HasSuffix(f, " after")
+			default:
+				return false
+			}
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("header fields are made valid", func(t *testing.T) {
+		t.Parallel()
+
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = pc.Close() })
+
+		h := SyslogHandler("udp", pc.LocalAddr().String(), WithSyslogHostname("web 1"),
+			WithSyslogAppName("My App\tß"+strings.Repeat("x", 60)), withSyslogClock(func() time.Time { return syslogStamp }))
+		t.Cleanup(func() { _ = closeHandler(h) })
+		writeRotating(t, h, "up")
+
+		buf := make([]byte, 1024)
+		require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := pc.ReadFrom(buf)
+		require.NoError(t, err)
+		app := "My_App__" + strings.Repeat("x", 40)
+		assert.Equal(t, fmt.Sprintf("<14>1 2026-10-18T09:05:07.123456Z web_1 %s %d - - up", app, pid), string(buf[:n]))
+	})
+
+	t.Run("an invalid SD-ID or parameter name fails every write", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := SyslogHandler("udp", "127.0.0.1:514", WithSyslogStructuredData("my origin", nil)).Write([]byte("x"))
+		require.ErrorContains(t, err, `"my origin" is not a valid syslog SD-NAME`)
+		_, err = SyslogHandler("udp", "127.0.0.1:514", WithSyslogStructuredData("origin", map[string]string{"a=b": "c"})).Write([]byte("x"))
+		require.ErrorContains(t, err, `"a=b" is not a valid syslog SD-NAME`)
+		_, err = SyslogHandler("udp", "127.0.0.1:514", WithSyslogStructuredData(strings.Repeat("x", 33), nil)).Write([]byte("x"))
+		require.ErrorContains(t, err, "not a valid syslog SD-NAME")
+	})
+
+	t.Run("an unsupported network fails every write", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := SyslogHandler("sctp", "127.0.0.1:514").Write([]byte("x"))
+		require.ErrorContains(t, err, `unsupported syslog network "sctp"`)
+	})
+}
@@ -0,0 +1,345 @@
+package loginjector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// telegramMessageLimit is the Bot API's limit on the text of one message, in UTF-16 code
+// units.
+const telegramMessageLimit = 4096
+
+// TelegramError is the error TelegramHandler returns when the Bot API answers with a
+// status other than 200.
+type TelegramError struct {
+	StatusCode  int
+	Description string        // the API's description of the failure, when it sent one.
+	RetryAfter  time.Duration // the API's retry_after hint of a 429, zero when absent.
+}
+
+func (e *TelegramError) Error() string {
+	s := fmt.Sprintf("could not to deliver message, status code: %v", e.StatusCode)
+	if e.Description != "" {
+		s += ": " + e.Description
+	}
+	if e.RetryAfter > 0 {
+		s += fmt.Sprintf(" (retry after %v)", e.RetryAfter)
+	}
+	return s
+}
+
+// retryAfter reports the RetryAfter hint to Retry.
+func (e *TelegramError) retryAfter() time.Duration { return e.RetryAfter }
+
+// TelegramOption configures TelegramHandlerWithOptions.
+type TelegramOption func(*telegramConfig)
+
+type telegramConfig struct {
+	api            string
+	client         *http.Client
+	fileName       string
+	labels         []string
+	text           bool
+	documentAbove  int
+	thread         int
+	silentBelow    LogLevel
+	silentBelowSet bool
+	floodPerMinute int                                           // WithTelegramFloodControl; zero when off.
+	clock          func() time.Time                              // withTelegramClock: test seam for flood control.
+	afterFunc      func(d time.Duration, f func()) (stop func()) // withTelegramTimer: test seam for flood control.
+}
+
+// WithTelegramAPI sets the Bot API base URL, e.g. a local Bot API server or an httptest
+// server. The default is https://api.telegram.org.
+func WithTelegramAPI(baseURL string) TelegramOption {
+	return func(c *telegramConfig) { c.api = strings.TrimSuffix(baseURL, "/") }
+}
+
+// WithTelegramClient sets the HTTP client requests are sent with. The default is a client
+// with a 20 second timeout.
+func WithTelegramClient(client *http.Client) TelegramOption {
+	return func(c *telegramConfig) { c.client = client }
+}
+
+// WithTelegramFileName sets the name of the document a message is sent as. The default is
+// log.txt.
+func WithTelegramFileName(name string) TelegramOption {
+	return func(c *telegramConfig) { c.fileName = name }
+}
+
+// WithTelegramLabels sets the lines after the time in a document's caption or above a
+// text message. They are sent as HTML, so they may hold tags such as <b>.
+func WithTelegramLabels(labels ...string) TelegramOption {
+	return func(c *telegramConfig) { c.labels = labels }
+}
+
+// WithTelegramText sends messages with sendMessage, HTML-escaped, instead of as documents.
+// A message longer than Telegram's 4096 characters is split into several, at a line
+// break where one is near; a message longer than documentAbove characters is sent as a
+// document instead, so a stack dump does not become a screenful of messages. A
+// documentAbove of zero or less means 4 × 4096.
+func WithTelegramText(documentAbove int) TelegramOption {
+	return func(c *telegramConfig) {
+		c.text = true
+		c.documentAbove = documentAbove
+	}
+}
+
+// WithTelegramThread sends messages to the forum topic with the given message_thread_id.
+func WithTelegramThread(id int) TelegramOption {
+	return func(c *telegramConfig) { c.thread = id }
+}
+
+// WithTelegramSilentBelow sends messages below level with disable_notification, so only
+// the levels that need attention make a sound. A plain Write carries no level and
+// notifies.
+func WithTelegramSilentBelow(level LogLevel) TelegramOption {
+	return func(c *telegramConfig) {
+		c.silentBelow = level
+		c.silentBelowSet = true
+	}
+}
+
+// TelegramHandler sends every message as a document named fileName to chatID, captioned
+// with the time and labels. A message the Bot API rejects fails with a *TelegramError.
+func TelegramHandler(botToken, chatID, fileName string, labels ...string) io.Writer {
+	return TelegramHandlerWithOptions(botToken, chatID, WithTelegramFileName(fileName), WithTelegramLabels(labels...))
+}
+
+// TelegramHandlerWithOptions is TelegramHandler with options: messages go to chatID as
+// documents captioned with the time and labels, or with WithTelegramText as HTML-escaped
+// text messages, split when longer than Telegram allows. WithTelegramThread targets a
+// forum topic, WithTelegramSilentBelow mutes the lower levels, and WithTelegramAPI with
+// WithTelegramClient point the handler at another server, such as an httptest one.
+//
+// A message the Bot API rejects fails with a *TelegramError; when a split message fails
+// part way, the parts before it have been sent. botToken is redacted from every error.
+func TelegramHandlerWithOptions(botToken, chatID string, opts ...TelegramOption) io.Writer {
+	cfg := telegramConfig{
+		api:       "https://api.telegram.org",
+		client:    &http.Client{Timeout: time.Second * 20},
+		fileName:  "log.txt",
+		clock:     time.Now,
+		afterFunc: afterFunc,
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if cfg.documentAbove <= 0 {
+		cfg.documentAbove = 4 * telegramMessageLimit
+	}
+	t := &telegram{cfg: cfg, token: botToken, chatID: chatID}
+	if cfg.floodPerMinute > 0 {
+		t.flood = newFloodControl(botToken, cfg.floodPerMinute)
+	}
+
+	return &writer{
+		h: func(msg []byte) (int, error) {
+			if err := t.write(msg, false); err != nil {
+				return 0, err
+			}
+			return len(msg), nil
+		},
+		hl: func(level LogLevel, msg []byte) (int, error) {
+			if err := t.write(msg, cfg.silentBelowSet && level < cfg.silentBelow); err != nil {
+				return 0, err
+			}
+			return len(msg), nil
+		},
+		closer: t.closeFlood,
+	}
+}
+
+// telegram sends messages to one chat of the Bot API.
+type telegram struct {
+	cfg    telegramConfig
+	token  string
+	chatID string
+	flood  *floodControl // nil without WithTelegramFloodControl.
+}
+
+// header is the time and labels that caption a document or open a text message.
+func (t *telegram) header() string {
+	caption := strings.Join(t.cfg.labels, "\n")
+	caption = fmt.Sprintf("%s %s", time.Now().UTC().Format("2006-01-02 15:04:05"), caption)
+	return strings.TrimSpace(caption)
+}
+
+// deliver sends msg as a document, or as one or more text messages.
+func (t *telegram) deliver(msg []byte, silent bool) error {
+	header := t.header()
+	room := telegramMessageLimit - utf16Len(header) - 1
+	// labels that leave no room for the message in a text message caption a document.
+	if !t.cfg.text || room < 1 || utf16Len(string(msg)) > t.cfg.documentAbove {
+		return t.sendDocument(msg, silent)
+	}
+	parts := splitTelegram(strings.TrimRight(string(msg), "\n"), room)
+	for i, part := range parts {
+		text := escapeTelegramHTML(part)
+		if i == 0 {
+			text = header + "\n" + text
+		}
+		if err := t.sendMessage(text, silent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fields are the form fields every request carries.
+func (t *telegram) fields(silent bool) map[string]string {
+	f := map[string]string{"chat_id": t.chatID, "parse_mode": "HTML"}
+	if t.cfg.thread != 0 {
+		f["message_thread_id"] = strconv.Itoa(t.cfg.thread)
+	}
+	if silent {
+		f["disable_notification"] = "true"
+	}
+	return f
+}
+
+// sendMessage sends text with sendMessage.
+func (t *telegram) sendMessage(text string, silent bool) error {
+	form := url.Values{"text": {text}}
+	for k, v := range t.fields(silent) {
+		form.Set(k, v)
+	}
+	return t.call("sendMessage", strings.NewReader(form.Encode()), "application/x-www-form-urlencoded")
+}
+
+// sendDocument sends msg as a document captioned with the header.
+func (t *telegram) sendDocument(msg []byte, silent bool) error {
+	return t.sendDocumentCaptioned(msg, t.header(), silent)
+}
+
+// sendDocumentCaptioned sends msg as a document with the given caption.
+func (t *telegram) sendDocumentCaptioned(msg []byte, caption string, silent bool) error {
+	payload := &bytes.Buffer{}
+	parts := multipart.NewWriter(payload)
+
+	if filePart, err := parts.CreateFormFile("document", t.cfg.fileName); err != nil {
+		return fmt.Errorf("could not create request form file, details: %s", err)
+	} else if _, err = filePart.Write(msg); err != nil {
+		return fmt.Errorf("could not write file part to request, details: %s", err)
+	}
+
+	fields := t.fields(silent)
+	fields["caption"] = caption
+	for _, k := range []string{"chat_id", "caption", "parse_mode", "message_thread_id", "disable_notification"} {
+		if v, ok := fields[k]; ok {
+			if err := parts.WriteField(k, v); err != nil {
+				return fmt.Errorf("could not write %s field to request: %s", k, err)
+			}
+		}
+	}
+
+	contentType := parts.FormDataContentType()
+	if err := parts.Close(); err != nil {
+		return fmt.Errorf("could not close request: %s", err)
+	}
+	return t.call("sendDocument", payload, contentType)
+}
+
+// call posts body to the Bot API method and checks the response.
+func (t *telegram) call(method string, body io.Reader, contentType string) error {
+	request, err := http.NewRequest("POST", fmt.Sprintf("%s/bot%s/%s", t.cfg.api, t.token, method), body)
+	if err != nil {
+		return fmt.Errorf("could not create HTTP request: %v", t.redactToken(err.Error()))
+	}
+	request.Header.Set("Content-Type", contentType)
+
+	r, err := t.cfg.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("could not send HTTP request: %v", t.redactToken(err.Error()))
+	}
+	defer CloseOrLog(r.Body)
+
+	var response struct {
+		Ok          bool   `json:"ok"`
+		Description string `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	rawResponse := bytes.NewBufferString("")
+	err = json.NewDecoder(io.TeeReader(r.Body, rawResponse)).Decode(&response)
+
+	if r.StatusCode != http.StatusOK {
+		// the body of a rejection is best effort: the status code alone is the error.
+		return &TelegramError{
+			StatusCode:  r.StatusCode,
+			Description: response.Description,
+			RetryAfter:  time.Duration(response.Parameters.RetryAfter) * time.Second,
+		}
+	}
+	if err != nil || !response.Ok {
+		return fmt.Errorf("could not decode response: %v\n%s", err, rawResponse.String())
+	}
+	return nil
+}
+
+// redactToken masks the bot token in an error string before it is surfaced: a failed
+// request build or client.Do returns a *url.Error whose text embeds the full URL, token
+// included, which would otherwise land in logs and log files.
+func (t *telegram) redactToken(s string) string {
+	// guard: an empty or very short token would over-match unrelated substrings, and no
+	// real Telegram token is that short, so skip redaction below the threshold.
+	if len(t.token) < 8 {
+		return s
+	}
+	return strings.ReplaceAll(s, t.token, "***")
+}
+
+// escapeTelegramHTML escapes the characters the Bot API's HTML parse mode reserves.
+var escapeTelegramHTML = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+
+// utf16Len is the length of s in UTF-16 code units, the unit of Telegram's limits.
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n++
+		if r > 0xFFFF {
+			n++
+		}
+	}
+	return n
+}
+
+// splitTelegram cuts s into parts of at most limit UTF-16 code units, each ending at the
+// last line break of its second half when it has one. A part holds at least one rune,
+// whatever the limit.
+func splitTelegram(s string, limit int) []string {
+	limit = max(limit, 2)
+	var parts []string
+	for utf16Len(s) > limit {
+		cut, n := 0, 0
+		for i, r := range s {
+			w := 1
+			if r > 0xFFFF {
+				w = 2
+			}
+			if n+w > limit {
+				break
+			}
+			n += w
+			cut = i + utf8.RuneLen(r)
+		}
+		if nl := strings.LastIndexByte(s[:cut], '\n'); nl >= cut/2 {
+			parts = append(parts, s[:nl])
+			s = s[nl+1:]
+			continue
+		}
+		parts = append(parts, s[:cut])
+		s = s[cut:]
+	}
+	return append(parts, s)
+}
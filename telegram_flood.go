@@ -0,0 +1,243 @@
+package loginjector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// telegramGlobalRate is the Bot API's limit on messages a bot sends per second across all
+// chats.
+const telegramGlobalRate = 30
+
+// telegramHeldBytes bounds the messages flood control keeps for the summary.
+const telegramHeldBytes = 1 << 20
+
+// WithTelegramFloodControl keeps the handler within Telegram's send limits instead of
+// running into 429 errors during an incident: perMinute messages a minute to the chat, in
+// bursts of up to 3 (zero or less means 20, Telegram's limit for groups), and 30 a second
+// across every handler of the same bot. A message over a limit, or sent while the API's
+// retry_after window of an earlier 429 is open, is held back and the Write succeeds.
+// When the window opens the held messages are sent as a single document captioned "N
+// messages held back by flood control", keeping up to 1 MiB of them; a send that fails
+// then is tried again a minute later, and its error is returned by the next Write. Close
+// sends what is still held if the limits allow it then, and otherwise fails naming how
+// many messages were not sent.
+func WithTelegramFloodControl(perMinute int) TelegramOption {
+	return func(c *telegramConfig) {
+		if perMinute <= 0 {
+			perMinute = 20
+		}
+		c.floodPerMinute = perMinute
+	}
+}
+
+// withTelegramClock replaces the clock flood control is measured with.
+func withTelegramClock(fn func() time.Time) TelegramOption {
+	return func(c *telegramConfig) { c.clock = fn }
+}
+
+// withTelegramTimer replaces the timer flood control schedules its summary with.
+func withTelegramTimer(fn func(d time.Duration, f func()) (stop func())) TelegramOption {
+	return func(c *telegramConfig) { c.afterFunc = fn }
+}
+
+// afterFunc runs f after d on its own goroutine.
+func afterFunc(d time.Duration, f func()) (stop func()) {
+	t := time.AfterFunc(d, f)
+	return func() { t.Stop() }
+}
+
+// tokenBucket allows rate events a second in bursts of up to burst.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst}
+}
+
+// refill adds the tokens earned since the last call; the caller holds mu.
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	if b.last.IsZero() || now.After(b.last) {
+		b.last = now
+	}
+}
+
+// ready reports how long until a token is available, zero when one is.
+func (b *tokenBucket) ready(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// take uses a token.
+func (b *tokenBucket) take(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens--
+}
+
+// telegramBotBuckets holds the global bucket of every bot token, shared by its handlers.
+var telegramBotBuckets sync.Map // bot token -> *tokenBucket
+
+// floodControl is the state of WithTelegramFloodControl.
+type floodControl struct {
+	mu     sync.Mutex
+	chat   *tokenBucket
+	bot    *tokenBucket
+	until  time.Time // the end of the retry_after window of the last 429.
+	held   [][]byte
+	size   int // bytes in held.
+	count  int // messages held back, kept or not.
+	stop   func()
+	err    error // the error of a background summary, for the next Write.
+	closed bool
+}
+
+func newFloodControl(botToken string, perMinute int) *floodControl {
+	bot, _ := telegramBotBuckets.LoadOrStore(botToken, newTokenBucket(telegramGlobalRate, telegramGlobalRate))
+	return &floodControl{
+		chat: newTokenBucket(float64(perMinute)/60, 3),
+		bot:  bot.(*tokenBucket),
+	}
+}
+
+// wait reports how long until a message may be sent, zero when it may be now; the caller
+// holds mu.
+func (f *floodControl) wait(now time.Time) time.Duration {
+	return max(f.until.Sub(now), f.chat.ready(now), f.bot.ready(now), 0)
+}
+
+// write delivers msg under flood control.
+func (t *telegram) write(msg []byte, silent bool) error {
+	f := t.flood
+	if f == nil {
+		return t.deliver(msg, silent)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err := f.err
+	f.err = nil
+
+	now := t.cfg.clock()
+	if f.count > 0 || f.wait(now) > 0 {
+		t.hold(msg, now)
+		return err
+	}
+	f.chat.take(now)
+	f.bot.take(now)
+	if e := t.deliver(msg, silent); e != nil {
+		if !t.throttled(e, now) {
+			return errors.Join(err, e)
+		}
+		t.hold(msg, now)
+	}
+	return err
+}
+
+// throttled records the retry_after window of a 429 and reports whether err was one; the
+// caller holds mu.
+func (t *telegram) throttled(err error, now time.Time) bool {
+	var tg *TelegramError
+	if !errors.As(err, &tg) || tg.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	t.flood.until = now.Add(max(tg.RetryAfter, time.Second))
+	return true
+}
+
+// hold keeps msg for the summary and schedules it; the caller holds mu.
+func (t *telegram) hold(msg []byte, now time.Time) {
+	f := t.flood
+	f.count++
+	if f.size+len(msg) <= telegramHeldBytes {
+		f.held = append(f.held, bytes.Clone(msg))
+		f.size += len(msg)
+	}
+	t.schedule(f.wait(now))
+}
+
+// schedule arranges for the summary to be sent after d, unless it already is; the caller
+// holds mu.
+func (t *telegram) schedule(d time.Duration) {
+	f := t.flood
+	if f.stop != nil || f.closed {
+		return
+	}
+	f.stop = t.cfg.afterFunc(d, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.stop = nil
+		t.summarise()
+	})
+}
+
+// summarise sends the held messages as one document once the limits allow, and otherwise
+// schedules itself for when they will; the caller holds mu.
+func (t *telegram) summarise() {
+	f := t.flood
+	if f.count == 0 {
+		return
+	}
+	now := t.cfg.clock()
+	if d := f.wait(now); d > 0 {
+		t.schedule(d)
+		return
+	}
+
+	body := bytes.Join(f.held, nil)
+	if kept := len(f.held); kept < f.count {
+		body = append(body, fmt.Sprintf("… and %d more messages not kept\n", f.count-kept)...)
+	}
+	caption := fmt.Sprintf("%s\n%d messages held back by flood control", t.header(), f.count)
+	f.chat.take(now)
+	f.bot.take(now)
+	if err := t.sendDocumentCaptioned(body, caption, false); err != nil {
+		if !t.throttled(err, now) {
+			f.err = errors.Join(f.err, err)
+			t.schedule(time.Minute)
+			return
+		}
+		t.schedule(f.wait(now))
+		return
+	}
+	f.held, f.size, f.count = nil, 0, 0
+}
+
+// closeFlood stops the summary timer and sends what is held, if the limits allow.
+func (t *telegram) closeFlood() error {
+	f := t.flood
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	if f.stop != nil {
+		f.stop()
+		f.stop = nil
+	}
+	t.summarise()
+	err := f.err
+	f.err = nil
+	if f.count > 0 {
+		err = errors.Join(err, fmt.Errorf("loginjector: %d messages held back by flood control were not sent", f.count))
+	}
+	return err
+}
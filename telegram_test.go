@@ -0,0 +1,265 @@
+package loginjector
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// botRequest is one call a botAPIStandIn received.
+type botRequest struct {
+	method   string
+	fields   map[string]string
+	document string // the uploaded file of a sendDocument.
+}
+
+// botAPIStandIn is an httptest Bot API that accepts every call and records it.
+type botAPIStandIn struct {
+	mu       sync.Mutex
+	calls    []botRequest
+	throttle int // how many calls to answer with a 429 first.
+}
+
+func (b *botAPIStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := botRequest{method: r.URL.Path[strings.LastIndexByte(r.URL.Path, '/')+1:], fields: map[string]string{}}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		_ = r.ParseMultipartForm(1 << 24)
+		if f, _, err := r.FormFile("document"); err == nil {
+			body, _ := io.ReadAll(f)
+			req.document = string(body)
+		}
+	} else {
+		_ = r.ParseForm()
+	}
+	for k := range r.Form {
+		req.fields[k] = r.Form.Get(k)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls = append(b.calls, req)
+	if b.throttle > 0 {
+		b.throttle--
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = io.WriteString(w, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 5","parameters":{"retry_after":5}}`)
+		return
+	}
+	_, _ = io.WriteString(w, `{"ok":true}`)
+}
+
+func newBotAPIStandIn(t *testing.T) (*botAPIStandIn, TelegramOption) {
+	b := &botAPIStandIn{}
+	srv := httptest.NewServer(b)
+	t.Cleanup(srv.Close)
+	return b, WithTelegramAPI(srv.URL)
+}
+
+func TestTelegramHandlerWithOptions(t *testing.T) {
+	t.Parallel()
+
+	t.Run("text mode escapes the message under the labels", func(t *testing.T) {
+		t.Parallel()
+
+		api, base := newBotAPIStandIn(t)
+		h := TelegramHandlerWithOptions("token", "chat", base, WithTelegramText(0), WithTelegramLabels("<b>billing</b>"), WithTelegramThread(7))
+		writeRotating(t, h, "if a < b && c > d\n")
+
+		require.Len(t, api.calls, 1)
+		c := api.calls[0]
+		assert.Equal(t, "sendMessage", c.method)
+		assert.Equal(t, "chat", c.fields["chat_id"])
+		assert.Equal(t, "7", c.fields["message_thread_id"])
+		assert.Equal(t, "HTML", c.fields["parse_mode"])
+		assert.True(t, strings.HasSuffix(c.fields["text"], " <b>billing</b>\nif a &lt; b &amp;&amp; c &gt; d"), c.fields["text"])
+		assert.NotContains(t, c.fields, "disable_notification")
+	})
+
+	t.Run("a long message is split at line breaks", func(t *testing.T) {
+		t.Parallel()
+
+		api, base := newBotAPIStandIn(t)
+		h := TelegramHandlerWithOptions("token", "chat", base, WithTelegramText(0))
+		line := strings.Repeat("x", 99)
+		msg := strings.Repeat(line+"\n", 100) // 10000 characters
+		writeRotating(t, h, msg)
+
+		require.Len(t, api.calls, 3)
+		var got []string
+		for i, c := range api.calls {
+			assert.Equal(t, "sendMessage", c.method)
+			assert.LessOrEqual(t, utf16Len(c.fields["text"]), telegramMessageLimit)
+			text := c.fields["text"]
+			if i == 0 {
+				_, text, _ = strings.Cut(text, "\n") // the header line
+			}
+			got = append(got, text)
+		}
+		assert.Equal(t, strings.TrimSuffix(msg, "\n"), strings.Join(got, "\n"))
+	})
+
+	t.Run("labels that fill a text message caption a document instead", func(t *testing.T) {
+		t.Parallel()
+
+		api, base := newBotAPIStandIn(t)
+		h := TelegramHandlerWithOptions("token", "chat", base, WithTelegramText(0), WithTelegramLabels(strings.Repeat("l", telegramMessageLimit)))
+		writeRotating(t, h, "alert")
+
+		require.Len(t, api.calls, 1)
+		assert.Equal(t, "sendDocument", api.calls[0].method)
+		assert.Equal(t, "alert", api.calls[0].document)
+	})
+
+	t.Run("a message above the threshold is sent as a document", func(t *testing.T) {
+		t.Parallel()
+
+		api, base := newBotAPIStandIn(t)
+		h := TelegramHandlerWithOptions("token", "chat", base, WithTelegramText(100), WithTelegramFileName("trace.txt"), WithTelegramLabels("api"))
+		msg := strings.Repeat("<frame>\n", 20)
+		writeRotating(t, h, msg)
+
+		require.Len(t, api.calls, 1)
+		assert.Equal(t, "sendDocument", api.calls[0].method)
+		assert.Equal(t, msg, api.calls[0].document, "a document is not escaped")
+		assert.True(t, strings.HasSuffix(api.calls[0].fields["caption"], " api"))
+	})
+
+	t.Run("the levels below the threshold are silent", func(t *testing.T) {
+		t.Parallel()
+
+		api, base := newBotAPIStandIn(t)
+		l := NewLogger(1, TelegramHandlerWithOptions("token", "chat", base, WithTelegramSilentBelow(4), WithTelegramThread(3)))
+		l.Printf(3, "warning")
+		l.Printf(4, "error")
+
+		require.Len(t, api.calls, 2)
+		assert.Equal(t, "sendDocument", api.calls[0].method)
+		assert.Equal(t, "true", api.calls[0].fields["disable_notification"])
+		assert.Equal(t, "3", api.calls[0].fields["message_thread_id"])
+		assert.NotContains(t, api.calls[1].fields, "disable_notification")
+	})
+
+	t.Run("the token is redacted from errors", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewServer(http.NotFoundHandler())
+		base := srv.URL
+		srv.Close()
+
+		_, err := TelegramHandlerWithOptions("1234567890:SECRET", "chat", WithTelegramAPI(base)).Write([]byte("x"))
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "1234567890:SECRET")
+		assert.Contains(t, err.Error(), "bot***/sendDocument")
+	})
+}
+
+func TestSplitTelegram(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, []string{"short"}, splitTelegram("short", 10))
+	assert.Equal(t, []string{"abcdefghij", "klm"}, splitTelegram("abcdefghijklm", 10), "no line break: a hard cut")
+	assert.Equal(t, []string{"abcdef", "ghijklm"}, splitTelegram("abcdef\nghijklm", 10))
+	// a surrogate pair counts twice and is never cut in half.
+	assert.Equal(t, []string{"ab😀", "😀"}, splitTelegram("ab😀😀", 5))
+	// a limit too small for a rune still makes progress.
+	assert.Equal(t, []string{"ab", "c"}, splitTelegram("abc", 0))
+	assert.Equal(t, []string{"😀", "😀"}, splitTelegram("😀😀", 1))
+}
+
+// manualTimer records what flood control schedules and runs it on demand.
+type manualTimer struct {
+	delays []time.Duration
+	fn     func()
+}
+
+func (m *manualTimer) afterFunc(d time.Duration, f func()) (stop func()) {
+	m.delays = append(m.delays, d)
+	m.fn = f
+	return func() { m.fn = nil }
+}
+
+// fire runs the scheduled function.
+func (m *manualTimer) fire(t *testing.T) {
+	t.Helper()
+	require.NotNil(t, m.fn, "nothing is scheduled")
+	f := m.fn
+	m.fn = nil
+	f()
+}
+
+func TestWithTelegramFloodControl(t *testing.T) {
+	t.Parallel()
+
+	t.Run("messages over the chat limit are collapsed into one summary", func(t *testing.T) {
+		t.Parallel()
+
+		api, base := newBotAPIStandIn(t)
+		now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+		var timer manualTimer
+		h := TelegramHandlerWithOptions(t.Name(), "chat", base, WithTelegramFloodControl(60),
+			withTelegramClock(func() time.Time { return now }), withTelegramTimer(timer.afterFunc))
+
+		for i := 1; i <= 5; i++ {
+			writeRotating(t, h, fmt.Sprintf("error %d\n", i))
+		}
+		require.Len(t, api.calls, 3, "a burst of 3 goes out")
+		assert.Equal(t, []time.Duration{time.Second}, timer.delays)
+
+		now = now.Add(time.Second)
+		timer.fire(t)
+		require.Len(t, api.calls, 4)
+		summary := api.calls[3]
+		assert.Equal(t, "sendDocument", summary.method)
+		assert.True(t, strings.HasSuffix(summary.fields["caption"], "\n2 messages held back by flood control"), summary.fields["caption"])
+		assert.Equal(t, "error 4\nerror 5\n", summary.document)
+
+		now = now.Add(time.Second)
+		writeRotating(t, h, "error 6\n")
+		require.Len(t, api.calls, 5, "writes go straight out again")
+		require.NoError(t, closeHandler(h))
+	})
+
+	t.Run("a 429 holds messages until its retry_after window opens", func(t *testing.T) {
+		t.Parallel()
+
+		api, base := newBotAPIStandIn(t)
+		api.throttle = 1
+		now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+		var timer manualTimer
+		h := TelegramHandlerWithOptions(t.Name(), "chat", base, WithTelegramFloodControl(0),
+			withTelegramClock(func() time.Time { return now }), withTelegramTimer(timer.afterFunc))
+
+		writeRotating(t, h, "first\n")
+		writeRotating(t, h, "second\n")
+		require.Len(t, api.calls, 1, "only the throttled attempt reached the API")
+		assert.Equal(t, []time.Duration{5 * time.Second}, timer.delays)
+
+		now = now.Add(2 * time.Second)
+		timer.fire(t)
+		require.Len(t, api.calls, 1, "the window is still closed")
+
+		now = now.Add(3 * time.Second)
+		timer.fire(t)
+		require.Len(t, api.calls, 2)
+		assert.Equal(t, "first\nsecond\n", api.calls[1].document)
+		assert.True(t, strings.HasSuffix(api.calls[1].fields["caption"], "\n2 messages held back by flood control"))
+	})
+
+	t.Run("Close reports messages it could not send", func(t *testing.T) {
+		t.Parallel()
+
+		api, base := newBotAPIStandIn(t)
+		api.throttle = 1
+		var timer manualTimer
+		h := TelegramHandlerWithOptions(t.Name(), "chat", base, WithTelegramFloodControl(0), withTelegramTimer(timer.afterFunc))
+
+		writeRotating(t, h, "held\n")
+		require.ErrorContains(t, closeHandler(h), "1 messages held back by flood control were not sent")
+	})
+}
@@ -1,9 +1,14 @@
 package loginjector
 
 import (
+	"bytes"
+	"compress/gzip"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"testing"
 )
@@ -46,3 +51,61 @@ func captureStdout(t *testing.T, fn func()) string {
 	_ = r.Close()
 	return out
 }
+
+// httpRequest is one request an httpStandIn received, its body already gunzipped.
+type httpRequest struct {
+	path   string
+	header http.Header
+	body   []byte
+}
+
+// httpStandIn is an httptest endpoint for the HTTP handlers. It answers the first requests
+// with the statuses of fail and a body of reply, and records every other one.
+type httpStandIn struct {
+	mu         sync.Mutex
+	requests   []httpRequest
+	fail       []int
+	reply      string
+	retryAfter string
+}
+
+func (s *httpStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err == nil && r.Header.Get("Content-Encoding") == "gzip" {
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(body)); err == nil {
+			body, err = io.ReadAll(zr)
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.retryAfter != "" {
+		w.Header().Set("Retry-After", s.retryAfter)
+	}
+	if len(s.fail) > 0 {
+		status := s.fail[0]
+		s.fail = s.fail[1:]
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, s.reply)
+		return
+	}
+	s.requests = append(s.requests, httpRequest{path: r.URL.Path, header: r.Header, body: body})
+}
+
+// received returns the requests recorded so far.
+func (s *httpStandIn) received() []httpRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]httpRequest(nil), s.requests...)
+}
+
+func newHTTPStandIn(t *testing.T) (*httpStandIn, string) {
+	s := &httpStandIn{}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, srv.URL
+}
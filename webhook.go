@@ -0,0 +1,205 @@
+package loginjector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// WebhookPreset is the JSON body template of a webhook: a text/template executed with the
+// Record of each message (Message with its trailing newline dropped, Level, Time and
+// Attrs — for a plain Write only Message and Time are set). Besides the builtins it may
+// use json, which renders its argument as a JSON value, and truncate, which cuts a string
+// to at most n characters, e.g. `{"text":{{json (truncate 1000 .Message)}}}`.
+type WebhookPreset string
+
+const (
+	// WebhookJSON posts the message with its level and time — the default.
+	WebhookJSON WebhookPreset = `{"time":{{json .Time}},"level":{{.Level}},"message":{{json .Message}}}`
+	// WebhookSlack posts to a Slack incoming webhook.
+	WebhookSlack WebhookPreset = `{"text":{{json .Message}}}`
+	// WebhookDiscord posts to a Discord webhook, cutting the message to Discord's 2000
+	// characters.
+	WebhookDiscord WebhookPreset = `{"content":{{json (truncate 2000 .Message)}}}`
+	// WebhookMattermost posts to a Mattermost incoming webhook.
+	WebhookMattermost WebhookPreset = `{"text":{{json .Message}}}`
+)
+
+// WebhookOption configures WebhookHandler.
+type WebhookOption func(*webhookConfig)
+
+type webhookConfig struct {
+	preset  WebhookPreset
+	headers http.Header
+	client  *http.Client
+	timeout time.Duration
+}
+
+// WithWebhookPreset sets the JSON body template; see WebhookPreset.
+func WithWebhookPreset(p WebhookPreset) WebhookOption {
+	return func(c *webhookConfig) { c.preset = p }
+}
+
+// WithWebhookHeader adds a header to every request, e.g. an Authorization token. Its value
+// is redacted from errors as the URL is.
+func WithWebhookHeader(key, value string) WebhookOption {
+	return func(c *webhookConfig) { c.headers.Add(key, value) }
+}
+
+// WithWebhookClient sets the HTTP client requests are sent with. The default is a client
+// with a 20 second timeout.
+func WithWebhookClient(client *http.Client) WebhookOption {
+	return func(c *webhookConfig) { c.client = client }
+}
+
+// WithWebhookTimeout bounds each request, on top of any timeout of the client.
+func WithWebhookTimeout(d time.Duration) WebhookOption {
+	return func(c *webhookConfig) { c.timeout = d }
+}
+
+// WebhookError is the error WebhookHandler returns when the endpoint answers with a
+// status outside 2xx.
+type WebhookError struct {
+	StatusCode int
+	Body       string        // the start of the response body, secrets redacted.
+	RetryAfter time.Duration // the Retry-After header in seconds, zero when absent.
+}
+
+func (e *WebhookError) Error() string {
+	s := fmt.Sprintf("loginjector: webhook answered with status code %d", e.StatusCode)
+	if e.Body != "" {
+		s += ": " + e.Body
+	}
+	return s
+}
+
+// retryAfter reports the RetryAfter hint to Retry.
+func (e *WebhookError) retryAfter() time.Duration { return e.RetryAfter }
+
+// WebhookHandler POSTs every message to the webhook at rawURL as a JSON body rendered from
+// a preset (WithWebhookPreset): generic JSON by default, or the payload of a Slack,
+// Discord or Mattermost incoming webhook. A response outside 2xx fails the Write with a
+// *WebhookError, whose Retry-After Retry honours; Retry's default classifier does not
+// retry a 4xx other than 429.
+//
+// As with TelegramHandler's token, the secret parts of rawURL — its path and query, which
+// is where Slack and Discord keep the webhook's key — and the values of WithWebhookHeader
+// are replaced by *** in every error, so a failure logged elsewhere does not leak them. An
+// invalid rawURL or preset fails every Write.
+func WebhookHandler(rawURL string, opts ...WebhookOption) io.Writer {
+	cfg := webhookConfig{
+		preset:  WebhookJSON,
+		headers: make(http.Header),
+		client:  &http.Client{Timeout: 20 * time.Second},
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+
+	var secrets []string
+	u, seedErr := url.Parse(rawURL)
+	if seedErr == nil {
+		secrets = append(secrets, u.EscapedPath(), u.RawQuery, u.Path)
+	}
+	for _, values := range cfg.headers {
+		secrets = append(secrets, values...)
+	}
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	// redact masks the secrets in an error string, longest first so one secret holding
+	// another is masked whole; like TelegramHandler's guard, very short values are skipped
+	// as they would over-match unrelated text.
+	redact := func(s string) string {
+		for _, secret := range secrets {
+			if len(secret) >= 8 {
+				s = strings.ReplaceAll(s, secret, "***")
+			}
+		}
+		return s
+	}
+	if seedErr != nil {
+		seedErr = fmt.Errorf("loginjector: invalid webhook URL: %s", redact(strings.ReplaceAll(seedErr.Error(), rawURL, "***")))
+	}
+
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"truncate": func(n int, s string) string {
+			if r := []rune(s); len(r) > n {
+				return string(r[:n])
+			}
+			return s
+		},
+	}).Parse(string(cfg.preset))
+	if err != nil && seedErr == nil {
+		seedErr = fmt.Errorf("loginjector: invalid webhook preset: %w", err)
+	}
+
+	post := func(r Record) (int, error) {
+		if seedErr != nil {
+			return 0, seedErr
+		}
+		r.Message = strings.TrimSuffix(r.Message, "\n")
+		body := &bytes.Buffer{}
+		if err := tmpl.Execute(body, r); err != nil {
+			return 0, fmt.Errorf("loginjector: could not render webhook body: %w", err)
+		}
+
+		ctx := context.Background()
+		if cfg.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cfg.timeout)
+			defer cancel()
+		}
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, body)
+		if err != nil {
+			return 0, fmt.Errorf("loginjector: could not create webhook request: %v", redact(err.Error()))
+		}
+		request.Header = cfg.headers.Clone()
+		request.Header.Set("Content-Type", "application/json")
+
+		response, err := cfg.client.Do(request)
+		if err != nil {
+			return 0, fmt.Errorf("loginjector: could not send webhook request: %v", redact(err.Error()))
+		}
+		defer CloseOrLog(response.Body)
+
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			head, _ := io.ReadAll(io.LimitReader(response.Body, 512))
+			e := &WebhookError{StatusCode: response.StatusCode, Body: redact(strings.TrimSpace(string(head)))}
+			if s, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil && s > 0 {
+				e.RetryAfter = time.Duration(s) * time.Second
+			}
+			return 0, e
+		}
+		// drain the body so the connection is reused.
+		_, _ = io.Copy(io.Discard, response.Body)
+		return len(r.Message), nil
+	}
+
+	return &writer{
+		h: func(msg []byte) (int, error) {
+			if _, err := post(Record{Time: time.Now(), Message: string(msg)}); err != nil {
+				return 0, err
+			}
+			return len(msg), nil
+		},
+		hl: func(level LogLevel, msg []byte) (int, error) {
+			if _, err := post(Record{Time: time.Now(), Level: level, Message: string(msg)}); err != nil {
+				return 0, err
+			}
+			return len(msg), nil
+		},
+		hr: post,
+	}
+}
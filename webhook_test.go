@@ -0,0 +1,109 @@
+package loginjector
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	t.Parallel()
+
+	t.Run("the presets render their payloads", func(t *testing.T) {
+		t.Parallel()
+
+		s, base := newHTTPStandIn(t)
+		for _, preset := range []WebhookPreset{WebhookSlack, WebhookMattermost} {
+			writeRotating(t, WebhookHandler(base+"/hook", WithWebhookPreset(preset)), "disk \"full\"\n")
+		}
+		writeRotating(t, WebhookHandler(base+"/hook", WithWebhookPreset(WebhookDiscord)), strings.Repeat("é", 2500))
+
+		assert.Equal(t, `{"text":"disk \"full\""}`, string(s.received()[0].body))
+		assert.Equal(t, `{"text":"disk \"full\""}`, string(s.received()[1].body))
+		var discord struct{ Content string }
+		require.NoError(t, json.Unmarshal(s.received()[2].body, &discord))
+		assert.Equal(t, strings.Repeat("é", 2000), discord.Content)
+		assert.Equal(t, "application/json", s.received()[0].header.Get("Content-Type"))
+	})
+
+	t.Run("the default body carries the level and a custom template the attributes", func(t *testing.T) {
+		t.Parallel()
+
+		s, base := newHTTPStandIn(t)
+		l := NewLogger(1, WebhookHandler(base))
+		l.Printf(4, "payment failed")
+		var body struct {
+			Time    time.Time
+			Level   LogLevel
+			Message string
+		}
+		require.NoError(t, json.Unmarshal(s.received()[0].body, &body))
+		assert.Equal(t, LogLevel(4), body.Level)
+		assert.Equal(t, "payment failed", body.Message)
+		assert.False(t, body.Time.IsZero())
+
+		custom := WebhookPreset(`{"text":{{json .Message}}{{range .Attrs}},{{json .Key}}:{{json .Value}}{{end}}}`)
+		NewLogger(1, WebhookHandler(base, WithWebhookPreset(custom))).Log(4, "order failed", Attr{"order_id", 42})
+		assert.Equal(t, `{"text":"order failed","order_id":42}`, string(s.received()[1].body))
+	})
+
+	t.Run("headers and the client are used", func(t *testing.T) {
+		t.Parallel()
+
+		s, base := newHTTPStandIn(t)
+		client := &http.Client{Transport: http.DefaultTransport}
+		writeRotating(t, WebhookHandler(base, WithWebhookHeader("Authorization", "Bearer abc"), WithWebhookClient(client),
+			WithWebhookTimeout(time.Second)), "x")
+		assert.Equal(t, "Bearer abc", s.received()[0].header.Get("Authorization"))
+	})
+
+	t.Run("a rejection is a WebhookError that Retry honours", func(t *testing.T) {
+		t.Parallel()
+
+		s, base := newHTTPStandIn(t)
+		s.fail = []int{http.StatusTooManyRequests, http.StatusTooManyRequests}
+		s.reply, s.retryAfter = "rejected by /services/T000/B000/XXXXSECRETXXXX", "3"
+
+		var slept waits
+		h := Retry(WebhookHandler(base+"/services/T000/B000/XXXXSECRETXXXX"), RetryPolicy{Attempts: 2, wait: slept.wait})
+		_, err := h.Write([]byte("alert"))
+		var we *WebhookError
+		require.ErrorAs(t, err, &we)
+		assert.Equal(t, http.StatusTooManyRequests, we.StatusCode)
+		assert.Equal(t, 3*time.Second, we.RetryAfter)
+		assert.Equal(t, waits{3 * time.Second}, slept)
+		assert.NotContains(t, err.Error(), "XXXXSECRETXXXX", "the URL's secret is redacted from the echoed body")
+	})
+
+	t.Run("secrets are redacted from transport errors", func(t *testing.T) {
+		t.Parallel()
+
+		srv := httptest.NewServer(http.NotFoundHandler())
+		base := srv.URL
+		srv.Close() // every request now fails to connect, echoing the URL.
+
+		h := WebhookHandler(base+"/services/T000/B000/XXXXSECRETXXXX?token=querysecret", WithWebhookHeader("X-Api-Key", "headersecret"))
+		_, err := h.Write([]byte("alert"))
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "XXXXSECRETXXXX")
+		assert.NotContains(t, err.Error(), "querysecret")
+		assert.Contains(t, err.Error(), "***")
+	})
+
+	t.Run("an invalid URL or preset fails every write", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := WebhookHandler("http://host/%zzsecretpath").Write([]byte("x"))
+		require.ErrorContains(t, err, "invalid webhook URL")
+		assert.NotContains(t, err.Error(), "secretpath")
+
+		_, err = WebhookHandler("http://host/", WithWebhookPreset(`{{json`)).Write([]byte("x"))
+		require.ErrorContains(t, err, "invalid webhook preset")
+	})
+}